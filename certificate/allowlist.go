@@ -0,0 +1,312 @@
+package iprs_cert
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+
+	clock "github.com/dirkmc/go-iprs/clock"
+	pb "github.com/dirkmc/go-iprs/pb"
+	u "github.com/ipfs/go-ipfs-util"
+	mh "github.com/multiformats/go-multihash"
+	routing "gx/ipfs/QmPCGUjMRuBcPybZFpjhzpifwPP9wPRoiy5geTQKU4vqWA/go-libp2p-routing"
+	record "gx/ipfs/QmWGtsyPYEoiqTtWLpeUA2jpW4YSZgarKDD2zivYAFz7sR/go-libp2p-record"
+	proto "gx/ipfs/QmZ4Qi3GaRbjcx28Sme5eMH7RQjGkt8wHxt2a65oLaeFEV/gogo-protobuf/proto"
+	ds "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore"
+)
+
+const AllowListType = "allowlist"
+const allowListPrefix = "/" + AllowListType + "/"
+const allowListPrefixLen = len(allowListPrefix)
+const AllowListFetchTimeout = time.Second * 10
+const AllowListPutTimeout = time.Second * 10
+const DefaultAllowListCacheTTL = time.Minute
+const DefaultAllowListCacheSize = 256
+
+// Prepended to the allow list before it is signed, so that the signature
+// can't be confused with a record signature made with the same key
+var allowListSigDomain = []byte("iprs-allow-list:")
+
+// AllowList lists the certificates that are currently allowed to sign
+// records for the name of the CA certificate that issued them, either by
+// certificate hash or by subject (eg "CN=alice,O=Example")
+type AllowList struct {
+	Issuer     *x509.Certificate
+	Sequence   uint64
+	CertHashes []string
+	Subjects   []string
+}
+
+func getAllowListPath(issuerHash string) string {
+	return allowListPrefix + issuerHash
+}
+
+// CreateAllowList creates an allow list signed with the issuer
+// certificate's private key. The sequence number must be higher than
+// that of any previously published allow list. It returns the list
+// marshalled as a routing record value.
+func CreateAllowList(issuer *x509.Certificate, pk crypto.Signer, seq uint64, certHashes, subjects []string) ([]byte, error) {
+	for _, h := range certHashes {
+		if !u.IsValidHash(h) {
+			return nil, fmt.Errorf("Bad certificate hash: [%s]", h)
+		}
+	}
+
+	data, err := proto.Marshal(&pb.AllowList{
+		Sequence:   proto.Uint64(seq),
+		CertHashes: certHashes,
+		Subjects:   subjects,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := Sign(pk, append(allowListSigDomain, data...))
+	if err != nil {
+		return nil, err
+	}
+
+	issuerBytes, err := MarshalCertificate(issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	return proto.Marshal(&pb.SignedAllowList{
+		AllowList: data,
+		Signature: sig,
+		Issuer:    issuerBytes,
+	})
+}
+
+// UnmarshalAllowList parses an allow list created with CreateAllowList
+// and checks that it was signed by the issuer
+func UnmarshalAllowList(data []byte) (*AllowList, error) {
+	sl := new(pb.SignedAllowList)
+	err := proto.Unmarshal(data, sl)
+	if err != nil {
+		return nil, fmt.Errorf("Could not unmarshal allow list: %s", err)
+	}
+
+	issuer, err := UnmarshalCertificate(sl.GetIssuer())
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse allow list issuer: %s", err)
+	}
+
+	err = CheckSignature(issuer, append(allowListSigDomain, sl.GetAllowList()...), sl.GetSignature())
+	if err != nil {
+		return nil, fmt.Errorf("Allow list signature check failed: %s", err)
+	}
+
+	l := new(pb.AllowList)
+	err = proto.Unmarshal(sl.GetAllowList(), l)
+	if err != nil {
+		return nil, fmt.Errorf("Could not unmarshal allow list: %s", err)
+	}
+
+	return &AllowList{
+		Issuer:     issuer,
+		Sequence:   l.GetSequence(),
+		CertHashes: l.GetCertHashes(),
+		Subjects:   l.GetSubjects(),
+	}, nil
+}
+
+// IsAllowed indicates whether the certificate is on the list, either by
+// hash or by subject
+func (l *AllowList) IsAllowed(cert *x509.Certificate) (bool, error) {
+	allowed, err := l.HasCertHash(cert)
+	if err != nil || allowed {
+		return allowed, err
+	}
+	return l.HasSubject(cert), nil
+}
+
+// HasCertHash indicates whether the certificate's hash is on the list
+func (l *AllowList) HasCertHash(cert *x509.Certificate) (bool, error) {
+	h, err := GetCertificateHash(cert)
+	if err != nil {
+		return false, err
+	}
+	for _, ah := range l.CertHashes {
+		if ah == h {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// HasSubject indicates whether the certificate's subject is on the list.
+// Any CA can issue a certificate with a given subject, so a subject match
+// only means something for certificates issued by the list's issuer.
+func (l *AllowList) HasSubject(cert *x509.Certificate) bool {
+	subject := cert.Subject.String()
+	for _, s := range l.Subjects {
+		if s == subject {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateAllowListRecord implements ValidatorFunc and verifies that
+// the passed in record value is an allow list signed by the Certificate
+// whose hash is in the passed in key
+func ValidateAllowListRecord(k string, val []byte) error {
+	if len(k) < allowListPrefixLen {
+		return errors.New("invalid allow list record key")
+	}
+
+	if k[:allowListPrefixLen] != allowListPrefix {
+		return errors.New("allow list record key was not prefixed with " + allowListPrefix)
+	}
+
+	issuerHash := k[allowListPrefixLen:]
+	if _, err := mh.FromB58String(issuerHash); err != nil {
+		return errors.New("allow list record key did not contain valid multihash: " + err.Error())
+	}
+
+	l, err := UnmarshalAllowList(val)
+	if err != nil {
+		return err
+	}
+
+	h, err := GetCertificateHash(l.Issuer)
+	if err != nil {
+		return err
+	}
+	if h != issuerHash {
+		return errors.New("allow list record key does not match hash of issuer certificate")
+	}
+	return nil
+}
+
+var AllowListValidator = &record.ValidChecker{
+	Func: ValidateAllowListRecord,
+	Sign: false,
+}
+
+// AllowListSelector selects the allow list with the highest sequence number
+func AllowListSelector(k string, vals [][]byte) (int, error) {
+	var best_seq uint64
+	best_i := -1
+
+	for i, val := range vals {
+		l, err := UnmarshalAllowList(val)
+		if err != nil {
+			continue
+		}
+
+		if best_i == -1 || l.Sequence > best_seq {
+			best_seq = l.Sequence
+			best_i = i
+		} else if l.Sequence == best_seq {
+			// This is just to make sure the selection is deterministic
+			if bytes.Compare(val, vals[best_i]) > 0 {
+				best_i = i
+			}
+		}
+	}
+	if best_i == -1 {
+		return 0, errors.New("no usable allow lists in given set")
+	}
+
+	return best_i, nil
+}
+
+// AllowListManager publishes and fetches allow lists.
+// Fetched lists (and the absence of a list) are cached for the cache TTL.
+// Up to DefaultAllowListCacheSize lists are cached.
+type AllowListManager struct {
+	routing routing.ValueStore
+	cache   *ttlCache
+}
+
+// If ttlp is nil, DefaultAllowListCacheTTL is used.
+// If clk is nil the system clock is used to expire cached lists.
+func NewAllowListManager(r routing.ValueStore, ttlp *time.Duration, clk clock.Clock) *AllowListManager {
+	ttl := DefaultAllowListCacheTTL
+	if ttlp != nil {
+		ttl = *ttlp
+	}
+
+	return &AllowListManager{
+		routing: r,
+		cache:   newTtlCache(DefaultAllowListCacheSize, ttl, clk),
+	}
+}
+
+// PutAllowList publishes an allow list created with CreateAllowList at
+// /allowlist/<issuer cert hash>, and returns the issuer cert hash
+func (m *AllowListManager) PutAllowList(ctx context.Context, data []byte) (string, error) {
+	l, err := UnmarshalAllowList(data)
+	if err != nil {
+		log.Warningf("Failed to unmarshal allow list: %s", err)
+		return "", err
+	}
+
+	issuerHash, err := GetCertificateHash(l.Issuer)
+	if err != nil {
+		return "", err
+	}
+	allowListKey := getAllowListPath(issuerHash)
+	log.Debugf("Putting allow list at %s", allowListKey)
+
+	timectx, cancel := context.WithTimeout(ctx, AllowListPutTimeout)
+	defer cancel()
+
+	if err := m.routing.PutValue(timectx, allowListKey, data); err != nil {
+		log.Warningf("Failed to put allow list at %s: %s", allowListKey, err)
+		return "", err
+	}
+
+	m.cache.set(issuerHash, l, time.Time{})
+	return issuerHash, nil
+}
+
+// GetAllowList gets the allow list published by the issuer with the
+// given cert hash. If the issuer has not published a list it returns nil.
+func (m *AllowListManager) GetAllowList(ctx context.Context, issuerHash string) (*AllowList, error) {
+	log.Debugf("AllowListManager get allow list [%s]", issuerHash)
+	if !u.IsValidHash(issuerHash) {
+		return nil, fmt.Errorf("Bad certificate hash: [%s]", issuerHash)
+	}
+
+	if l, ok := m.cache.get(issuerHash); ok {
+		return l.(*AllowList), nil
+	}
+
+	allowListKey := getAllowListPath(issuerHash)
+	log.Debugf("Fetching allow list at %s", allowListKey)
+
+	timectx, cancel := context.WithTimeout(ctx, AllowListFetchTimeout)
+	defer cancel()
+
+	val, err := m.routing.GetValue(timectx, allowListKey)
+	if err == routing.ErrNotFound || err == ds.ErrNotFound {
+		log.Debugf("No allow list at %s", allowListKey)
+		m.cache.set(issuerHash, (*AllowList)(nil), time.Time{})
+		return nil, nil
+	}
+	if err != nil {
+		log.Warningf("Failed to fetch allow list at %s: %s", allowListKey, err)
+		return nil, err
+	}
+
+	// Make sure the list was signed by the issuer
+	err = ValidateAllowListRecord(allowListKey, val)
+	if err != nil {
+		log.Warningf("Invalid allow list at %s: %s", allowListKey, err)
+		return nil, err
+	}
+	l, err := UnmarshalAllowList(val)
+	if err != nil {
+		return nil, err
+	}
+
+	m.cache.set(issuerHash, l, time.Time{})
+	return l, nil
+}
@@ -0,0 +1,364 @@
+package iprs_cert
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	clock "github.com/dirkmc/go-iprs/clock"
+)
+
+// Default lifetimes of certificates issued by a CertificateAuthority when
+// the options don't specify NotAfter
+var DefaultCALifetime = time.Hour * 24 * 365 * 10
+var DefaultCertificateLifetime = time.Hour * 24 * 365
+
+// Certificates are valid from slightly in the past so that they can be
+// used immediately by nodes whose clocks are a little behind
+var certificateBackdate = time.Minute * 5
+
+var CAKeyMismatchError = errors.New("Private key does not match CA certificate")
+var CANotCAError = errors.New("CA certificate is not a CA certificate")
+
+type KeyType int
+
+const (
+	KeyTypeECDSA KeyType = iota
+	KeyTypeRSA
+	KeyTypeEd25519
+)
+
+// GenerateKey generates a private key of the given type. ECDSA keys use
+// the P-256 curve and RSA keys are 2048 bits.
+func GenerateKey(kt KeyType) (crypto.Signer, error) {
+	switch kt {
+	case KeyTypeECDSA:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyTypeRSA:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case KeyTypeEd25519:
+		_, pk, err := ed25519.GenerateKey(rand.Reader)
+		return pk, err
+	}
+	return nil, UnsupportedKeyTypeError
+}
+
+// CertificateOptions are the options used to create a certificate
+type CertificateOptions struct {
+	Subject pkix.Name
+	// If NotBefore is zero the certificate is valid from now. If NotAfter
+	// is zero the default lifetime is used. NotAfter is never later than
+	// the issuer's NotAfter.
+	NotBefore time.Time
+	NotAfter  time.Time
+	// The type of key to generate
+	KeyType KeyType
+	// If PermittedPaths is not empty, the certificate may only sign records
+	// at these relative paths (and their sub-paths) under the root
+	// certificate's IPRS key, eg "/teamname"
+	PermittedPaths []string
+	// For CA certificates, the maximum number of intermediate CAs that may
+	// follow it in a chain. As for x509.Certificate, MaxPathLenZero must be
+	// set to restrict the CA to issuing only end certificates.
+	MaxPathLen     int
+	MaxPathLenZero bool
+	// The clock that gives the current time when NotBefore is zero, so
+	// that certificates line up with the clock that records are checked
+	// against. If Clock is nil the system clock is used.
+	Clock clock.Clock
+}
+
+// CertificateAuthority issues certificates that sign IPRS records.
+// Records signed by any certificate that the CA issues (directly or
+// through intermediate CAs) are published under the root certificate's
+// IPRS key.
+type CertificateAuthority struct {
+	Cert *x509.Certificate
+	Key  crypto.Signer
+	// The chain of certificates from the CA certificate up to and
+	// including the root certificate
+	chain []*x509.Certificate
+}
+
+// NewRootCA generates a key and creates a self-signed root CA certificate
+func NewRootCA(opts CertificateOptions) (*CertificateAuthority, error) {
+	pk, err := GenerateKey(opts.KeyType)
+	if err != nil {
+		return nil, err
+	}
+	template, err := newTemplate(opts, nil, DefaultCALifetime)
+	if err != nil {
+		return nil, err
+	}
+	setCATemplate(template, opts)
+	cert, err := createCertificate(template, template, pk.Public(), pk)
+	if err != nil {
+		return nil, err
+	}
+	return &CertificateAuthority{Cert: cert, Key: pk, chain: []*x509.Certificate{cert}}, nil
+}
+
+// NewCertificateAuthority creates a CertificateAuthority from an existing
+// CA certificate and its private key. The issuers are the
+// certificates from the CA certificate's issuer up to and including the
+// root certificate.
+func NewCertificateAuthority(cert *x509.Certificate, pk crypto.Signer, issuers []*x509.Certificate) (*CertificateAuthority, error) {
+	if !cert.BasicConstraintsValid || !cert.IsCA {
+		return nil, CANotCAError
+	}
+	if !publicKeysEqual(cert.PublicKey, pk.Public()) {
+		return nil, CAKeyMismatchError
+	}
+	chain := []*x509.Certificate{cert}
+	chain = append(chain, issuers...)
+	return &CertificateAuthority{Cert: cert, Key: pk, chain: chain}, nil
+}
+
+// LoadCertificateAuthority creates a CertificateAuthority from PEM encoded
+// data. certPEM contains the CA certificate followed by any intermediate
+// certificates up to the root certificate, as output by MarshalChain.
+func LoadCertificateAuthority(certPEM, keyPEM []byte) (*CertificateAuthority, error) {
+	certs, err := UnmarshalCertificateChain(certPEM)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("No CA certificate in PEM data")
+	}
+	pk, err := UnmarshalPrivateKey(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return NewCertificateAuthority(certs[0], pk, certs[1:])
+}
+
+// Root returns the root certificate of the chain
+func (ca *CertificateAuthority) Root() *x509.Certificate {
+	return ca.chain[len(ca.chain)-1]
+}
+
+// Chain returns the certificates from the CA certificate up to the root
+// certificate
+func (ca *CertificateAuthority) Chain() []*x509.Certificate {
+	return append([]*x509.Certificate{}, ca.chain...)
+}
+
+// Intermediates returns the intermediate certificates that a certificate
+// issued by this CA must present to chain up to the root, ie the
+// certificates from the CA certificate up to (but not including) the
+// root certificate. Pass them to NewCertChainRecordSigner.
+func (ca *CertificateAuthority) Intermediates() []*x509.Certificate {
+	return append([]*x509.Certificate{}, ca.chain[:len(ca.chain)-1]...)
+}
+
+// NewIntermediateCA generates a key and issues an intermediate CA
+// certificate
+func (ca *CertificateAuthority) NewIntermediateCA(opts CertificateOptions) (*CertificateAuthority, error) {
+	pk, err := GenerateKey(opts.KeyType)
+	if err != nil {
+		return nil, err
+	}
+	template, err := newTemplate(opts, ca.Cert, DefaultCALifetime)
+	if err != nil {
+		return nil, err
+	}
+	setCATemplate(template, opts)
+	cert, err := createCertificate(template, ca.Cert, pk.Public(), ca.Key)
+	if err != nil {
+		return nil, err
+	}
+	chain := []*x509.Certificate{cert}
+	chain = append(chain, ca.chain...)
+	return &CertificateAuthority{Cert: cert, Key: pk, chain: chain}, nil
+}
+
+// IssueCertificate generates a key and issues a certificate that may sign
+// IPRS records
+func (ca *CertificateAuthority) IssueCertificate(opts CertificateOptions) (*x509.Certificate, crypto.Signer, error) {
+	pk, err := GenerateKey(opts.KeyType)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := ca.IssueCertificateForKey(opts, pk.Public())
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, pk, nil
+}
+
+// IssueCertificateForKey issues a certificate that may sign IPRS records
+// for a public key, so that the holder of the private key doesn't need
+// to share it with the CA. opts.KeyType is ignored.
+func (ca *CertificateAuthority) IssueCertificateForKey(opts CertificateOptions, pub crypto.PublicKey) (*x509.Certificate, error) {
+	template, err := newTemplate(opts, ca.Cert, DefaultCertificateLifetime)
+	if err != nil {
+		return nil, err
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.UnknownExtKeyUsage = []asn1.ObjectIdentifier{ExtKeyUsageIprsRecordSigning}
+	return createCertificate(template, ca.Cert, pub, ca.Key)
+}
+
+// Publish puts the CA certificate and the certificates above it in the
+// chain (including the root) to the network, so that verifiers can fetch
+// them. Certificates issued by the CA are published by the record signer.
+func (ca *CertificateAuthority) Publish(ctx context.Context, m *CertificateManager) error {
+	_, err := m.PutCertificateChain(ctx, ca.chain)
+	return err
+}
+
+// MarshalChain PEM encodes the CA certificate followed by the
+// certificates above it in the chain
+func (ca *CertificateAuthority) MarshalChain() ([]byte, error) {
+	return MarshalCertificateChain(ca.chain)
+}
+
+// MarshalKey PEM encodes the CA's private key
+func (ca *CertificateAuthority) MarshalKey() ([]byte, error) {
+	return MarshalPrivateKey(ca.Key)
+}
+
+// MarshalCertificateChain PEM encodes the certificates in order
+func MarshalCertificateChain(certs []*x509.Certificate) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, cert := range certs {
+		pemBytes, err := MarshalCertificate(cert)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(pemBytes)
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalCertificateChain parses all the certificates in the PEM data,
+// in order. Blocks that are not certificates are skipped.
+func UnmarshalCertificateChain(pemBytes []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, pemBytes = pem.Decode(pemBytes)
+		if block == nil {
+			return certs, nil
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+}
+
+// MarshalPrivateKey PEM encodes the private key in PKCS #8 form
+func MarshalPrivateKey(pk crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(pk)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// UnmarshalPrivateKey parses a PEM encoded private key in PKCS #8, PKCS #1
+// (RSA) or SEC 1 (ECDSA) form
+func UnmarshalPrivateKey(pemBytes []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("Could not decode private key")
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, UnsupportedKeyTypeError
+	}
+	return signer, nil
+}
+
+func newTemplate(opts CertificateOptions, issuer *x509.Certificate, lifetime time.Duration) (*x509.Certificate, error) {
+	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
+	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	notBefore := opts.NotBefore
+	if notBefore.IsZero() {
+		notBefore = clock.OrRealClock(opts.Clock).Now().Add(-certificateBackdate)
+	}
+	notAfter := opts.NotAfter
+	if notAfter.IsZero() {
+		notAfter = notBefore.Add(lifetime)
+	}
+	if issuer != nil && notAfter.After(issuer.NotAfter) {
+		notAfter = issuer.NotAfter
+	}
+	if !notAfter.After(notBefore) {
+		return nil, fmt.Errorf("Certificate NotAfter %s is not after NotBefore %s", notAfter, notBefore)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               opts.Subject,
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		BasicConstraintsValid: true,
+	}
+	if len(opts.PermittedPaths) > 0 {
+		ext, err := PathConstraintsExtension(opts.PermittedPaths)
+		if err != nil {
+			return nil, err
+		}
+		template.ExtraExtensions = append(template.ExtraExtensions, ext)
+	}
+	return template, nil
+}
+
+func setCATemplate(template *x509.Certificate, opts CertificateOptions) {
+	template.IsCA = true
+	// A CA may also sign records under its own IPRS key
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
+	template.MaxPathLen = opts.MaxPathLen
+	template.MaxPathLenZero = opts.MaxPathLenZero
+}
+
+func createCertificate(template, parent *x509.Certificate, pub crypto.PublicKey, signer crypto.Signer) (*x509.Certificate, error) {
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, pub, signer)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+func publicKeysEqual(a, b crypto.PublicKey) bool {
+	ak, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && ak.Equal(b)
+}
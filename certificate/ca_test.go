@@ -0,0 +1,184 @@
+package iprs_cert
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+
+	clock "github.com/dirkmc/go-iprs/clock"
+	mockrouting "github.com/ipfs/go-ipfs/routing/mock"
+	ds "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore"
+	dssync "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore/sync"
+	testutil "gx/ipfs/QmeDA8gNhvRTsbrjEieay5wezupJDiky8xvCzDABbsGzmp/go-testutil"
+)
+
+func TestCertificateAuthority(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	r := mockrouting.NewServer().ClientWithDatastore(ctx, testutil.RandIdentityOrFatal(t), dstore)
+	now := time.Now()
+
+	root, err := NewRootCA(CertificateOptions{Subject: pkix.Name{Organization: []string{"root"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(root.Intermediates()) != 0 {
+		t.Fatal("Expected root CA to have no intermediates")
+	}
+
+	team, err := root.NewIntermediateCA(CertificateOptions{
+		Subject:        pkix.Name{Organization: []string{"team"}},
+		KeyType:        KeyTypeRSA,
+		PermittedPaths: []string{"/team"},
+		MaxPathLenZero: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if team.Root() != root.Cert {
+		t.Fatal("Expected intermediate CA to chain up to root")
+	}
+	if len(team.Intermediates()) != 1 || team.Intermediates()[0] != team.Cert {
+		t.Fatal("Expected intermediate CA certificate to be the only intermediate")
+	}
+
+	// Issued certificates pass the chain policy for each key type
+	for _, kt := range []KeyType{KeyTypeECDSA, KeyTypeRSA, KeyTypeEd25519} {
+		leaf, _, err := team.IssueCertificate(CertificateOptions{
+			Subject:        pkix.Name{Organization: []string{"member"}},
+			KeyType:        kt,
+			PermittedPaths: []string{"/team/member"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if leaf.NotAfter.After(team.Cert.NotAfter) {
+			t.Fatal("Expected certificate to expire no later than its issuer")
+		}
+
+		chain := append([]*x509.Certificate{leaf}, team.Chain()...)
+		for i := 0; i < len(chain)-1; i++ {
+			err = CheckSignatureFrom(chain[i], chain[i+1])
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err = CheckChainPolicy(chain, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = CheckPathConstraints(chain, "/team/member/foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = CheckPathConstraints(chain, "/other")
+		if err != CertificatePathConstraintError {
+			t.Fatal("Expected path constraint error")
+		}
+	}
+
+	// An intermediate CA with zero path length cannot issue CAs that
+	// issue signing certificates
+	sub, err := team.NewIntermediateCA(CertificateOptions{Subject: pkix.Name{Organization: []string{"sub"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _, err := sub.IssueCertificate(CertificateOptions{Subject: pkix.Name{Organization: []string{"sub member"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = CheckChainPolicy(append([]*x509.Certificate{leaf}, sub.Chain()...), now)
+	if err != CertificatePathLengthError {
+		t.Fatal("Expected path length error")
+	}
+
+	// Round trip through PEM
+	certPEM, err := team.MarshalChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM, err := team.MarshalKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadCertificateAuthority(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Cert.Equal(team.Cert) || !loaded.Root().Equal(root.Cert) {
+		t.Fatal("Expected loaded CA to have the same chain")
+	}
+	_, _, err = loaded.IssueCertificate(CertificateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Key must match the certificate
+	_, err = LoadCertificateAuthority(certPEM, mustMarshalKey(t, root))
+	if err != CAKeyMismatchError {
+		t.Fatal("Expected key mismatch error")
+	}
+
+	// Publishing puts the whole chain to the network
+	err = team.Publish(ctx, NewCertificateManager(r, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewCertificateManager(r, nil)
+	for _, cert := range team.Chain() {
+		h, err := GetCertificateHash(cert)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fetched, err := m.GetCertificate(ctx, h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !fetched.Equal(cert) {
+			t.Fatal("Expected published certificate")
+		}
+	}
+}
+
+func mustMarshalKey(t *testing.T, ca *CertificateAuthority) []byte {
+	b, err := ca.MarshalKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestCertificateAuthorityClock(t *testing.T) {
+	// Certificates are valid from the clock's time rather than the
+	// system time
+	clk := clock.NewMockClock(time.Now().Add(-time.Hour * 24 * 365 * 2))
+	root, err := NewRootCA(CertificateOptions{
+		Subject: pkix.Name{Organization: []string{"root"}},
+		Clock:   clk,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	notBefore := clk.Now().Add(-certificateBackdate)
+	if !root.Cert.NotBefore.Equal(notBefore.Truncate(time.Second)) {
+		t.Fatalf("Expected NotBefore %s, got %s", notBefore, root.Cert.NotBefore)
+	}
+
+	// The default lifetime starts from the clock's time, so a certificate
+	// issued by a clock two years ago has already expired
+	leaf, _, err := root.IssueCertificate(CertificateOptions{
+		Subject: pkix.Name{Organization: []string{"member"}},
+		Clock:   clk,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !leaf.NotAfter.Equal(notBefore.Add(DefaultCertificateLifetime).Truncate(time.Second)) {
+		t.Fatalf("Unexpected NotAfter %s", leaf.NotAfter)
+	}
+	if !leaf.NotAfter.Before(time.Now()) {
+		t.Fatal("Expected certificate to have expired according to the system clock")
+	}
+}
@@ -0,0 +1,67 @@
+package iprs_cert
+
+import (
+	"time"
+
+	clock "github.com/dirkmc/go-iprs/clock"
+	lru "gx/ipfs/QmVYxfoJQiZijTgPNHCHgHELvQpbsJNTg6Crmc3dQkj3yy/golang-lru"
+)
+
+// ttlCache caches values fetched from routing by issuer cert hash,
+// until they expire. When the cache is full, the least recently used
+// value is evicted.
+type ttlCache struct {
+	clock clock.Clock
+	ttl   time.Duration
+	cache *lru.Cache
+}
+
+type ttlCacheEntry struct {
+	val interface{}
+	eol time.Time
+}
+
+func newTtlCache(size int, ttl time.Duration, clk clock.Clock) *ttlCache {
+	cache, _ := lru.New(size)
+	return &ttlCache{
+		clock: clock.OrRealClock(clk),
+		ttl:   ttl,
+		cache: cache,
+	}
+}
+
+func (c *ttlCache) get(k string) (interface{}, bool) {
+	centry, ok := c.cache.Get(k)
+	if !ok {
+		return nil, false
+	}
+
+	// If it's not expired, return it
+	entry := centry.(ttlCacheEntry)
+	if c.clock.Now().Before(entry.eol) {
+		return entry.val, true
+	}
+
+	// It's expired, so remove it
+	c.cache.Remove(k)
+	return nil, false
+}
+
+// set caches the value for the cache TTL, or until the given time
+// if it is sooner (a zero time is ignored). If the given time has
+// already passed the value is not cached.
+func (c *ttlCache) set(k string, val interface{}, until time.Time) {
+	now := c.clock.Now()
+	eol := now.Add(c.ttl)
+	if !until.IsZero() {
+		if !until.After(now) {
+			c.cache.Remove(k)
+			return
+		}
+		if until.Before(eol) {
+			eol = until
+		}
+	}
+
+	c.cache.Add(k, ttlCacheEntry{val, eol})
+}
@@ -0,0 +1,51 @@
+package iprs_cert
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	clock "github.com/dirkmc/go-iprs/clock"
+)
+
+func TestTtlCache(t *testing.T) {
+	clk := clock.NewMockClock(time.Unix(1000000, 0))
+	c := newTtlCache(2, time.Minute, clk)
+
+	// Values are cached for the TTL
+	c.set("a", 1, time.Time{})
+	if v, ok := c.get("a"); !ok || v != 1 {
+		t.Fatal("Expected value to be cached")
+	}
+	clk.Add(time.Minute)
+	if _, ok := c.get("a"); ok {
+		t.Fatal("Expected value to expire after TTL")
+	}
+
+	// Values are cached until the given time if it is sooner
+	c.set("a", 1, clk.Now().Add(time.Second))
+	clk.Add(time.Second)
+	if _, ok := c.get("a"); ok {
+		t.Fatal("Expected value to expire at given time")
+	}
+
+	// Values are not cached if the given time has passed, eg a stale CRL
+	c.set("a", 1, time.Time{})
+	c.set("a", 2, clk.Now().Add(-time.Second))
+	if _, ok := c.get("a"); ok {
+		t.Fatal("Expected stale value not to be cached")
+	}
+
+	// The least recently used values are evicted when the cache is full
+	for i := 0; i < 3; i++ {
+		c.set(fmt.Sprintf("k%d", i), i, time.Time{})
+	}
+	if _, ok := c.get("k0"); ok {
+		t.Fatal("Expected least recently used value to be evicted")
+	}
+	for i := 1; i < 3; i++ {
+		if _, ok := c.get(fmt.Sprintf("k%d", i)); !ok {
+			t.Fatal("Expected recently used values to be cached")
+		}
+	}
+}
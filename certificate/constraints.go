@@ -0,0 +1,89 @@
+package iprs_cert
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	gopath "path"
+	"strings"
+)
+
+// IprsPathConstraintsOID is the OID of the certificate extension that
+// lists the relative paths under the root certificate's IPRS key at which
+// a certificate (and any certificate it issues) may sign records.
+// The extension value is an ASN.1 SEQUENCE of strings.
+var IprsPathConstraintsOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 53620, 1, 2}
+
+var CertificatePathConstraintError = errors.New("Record path is not permitted by certificate path constraints")
+
+// PathConstraintsExtension creates a certificate extension that restricts
+// the certificate to signing records at the given relative paths (and
+// their sub-paths), eg "/teamname". Add it to the template's
+// ExtraExtensions when creating the certificate.
+func PathConstraintsExtension(permitted []string) (pkix.Extension, error) {
+	var paths []string
+	for _, p := range permitted {
+		paths = append(paths, cleanRelativePath(p))
+	}
+	val, err := asn1.Marshal(paths)
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	// The extension is critical so that verifiers that don't understand
+	// it don't ignore the restriction
+	return pkix.Extension{Id: IprsPathConstraintsOID, Critical: true, Value: val}, nil
+}
+
+// GetPathConstraints returns the relative paths permitted by the
+// certificate's path constraints extension. If the certificate has no
+// path constraints it returns false.
+func GetPathConstraints(cert *x509.Certificate) ([]string, bool, error) {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(IprsPathConstraintsOID) {
+			continue
+		}
+		var paths []string
+		rest, err := asn1.Unmarshal(ext.Value, &paths)
+		if err != nil {
+			return nil, true, err
+		}
+		if len(rest) > 0 {
+			return nil, true, errors.New("Trailing data in certificate path constraints")
+		}
+		return paths, true, nil
+	}
+	return nil, false, nil
+}
+
+// CheckPathConstraints checks that the relative path is permitted by the
+// path constraints of every certificate in the chain
+func CheckPathConstraints(chain []*x509.Certificate, relativePath string) error {
+	p := cleanRelativePath(relativePath)
+	for _, cert := range chain {
+		permitted, ok, err := GetPathConstraints(cert)
+		if err != nil {
+			return err
+		}
+		if ok && !isPathPermitted(p, permitted) {
+			return CertificatePathConstraintError
+		}
+	}
+	return nil
+}
+
+func isPathPermitted(p string, permitted []string) bool {
+	for _, prefix := range permitted {
+		prefix = cleanRelativePath(prefix)
+		if prefix == "/" || p == prefix || strings.HasPrefix(p, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanRelativePath converts a relative path to the form /a/b
+// (the empty relative path is /)
+func cleanRelativePath(p string) string {
+	return gopath.Clean("/" + p)
+}
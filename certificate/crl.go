@@ -0,0 +1,167 @@
+package iprs_cert
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	mh "github.com/multiformats/go-multihash"
+	record "gx/ipfs/QmWGtsyPYEoiqTtWLpeUA2jpW4YSZgarKDD2zivYAFz7sR/go-libp2p-record"
+)
+
+const CRLType = "crl"
+const crlPrefix = "/" + CRLType + "/"
+const crlPrefixLen = len(crlPrefix)
+
+// RevocationList is a certificate revocation list signed by the CA
+// certificate that issued the revoked certificates
+type RevocationList struct {
+	Issuer *x509.Certificate
+	CRL    *pkix.CertificateList
+}
+
+func getCRLPath(issuerHash string) string {
+	return crlPrefix + issuerHash
+}
+
+// CreateRevocationList creates a revocation list of the given certificates,
+// signed with the issuer certificate's private key. It returns the list
+// marshalled as a routing record value, ie the CRL followed by the issuer
+// certificate (so that the signature can be checked by validators).
+func CreateRevocationList(issuer *x509.Certificate, pk crypto.Signer, revoked []*x509.Certificate, thisUpdate, nextUpdate time.Time) ([]byte, error) {
+	var revokedCerts []pkix.RevokedCertificate
+	for _, cert := range revoked {
+		if err := CheckSignatureFrom(cert, issuer); err != nil {
+			return nil, err
+		}
+		revokedCerts = append(revokedCerts, pkix.RevokedCertificate{
+			SerialNumber:   cert.SerialNumber,
+			RevocationTime: thisUpdate,
+		})
+	}
+
+	crlBytes, err := issuer.CreateCRL(rand.Reader, pk, revokedCerts, thisUpdate, nextUpdate)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := new(bytes.Buffer)
+	err = pem.Encode(buf, &pem.Block{Type: "X509 CRL", Bytes: crlBytes})
+	if err != nil {
+		return nil, err
+	}
+	err = pem.Encode(buf, &pem.Block{Type: "CERTIFICATE", Bytes: issuer.Raw})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalRevocationList parses a revocation list created with
+// CreateRevocationList and checks that it was signed by the issuer
+func UnmarshalRevocationList(data []byte) (*RevocationList, error) {
+	crlBlock, rest := pem.Decode(data)
+	if crlBlock == nil || crlBlock.Type != "X509 CRL" {
+		return nil, errors.New("Could not decode certificate revocation list")
+	}
+	crl, err := x509.ParseDERCRL(crlBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse certificate revocation list: %s", err)
+	}
+
+	issuer, err := UnmarshalCertificate(rest)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse certificate revocation list issuer: %s", err)
+	}
+
+	if err = issuer.CheckCRLSignature(crl); err != nil {
+		return nil, fmt.Errorf("Certificate revocation list signature check failed: %s", err)
+	}
+
+	return &RevocationList{issuer, crl}, nil
+}
+
+// IsRevoked indicates whether the given certificate is on the list
+func (l *RevocationList) IsRevoked(cert *x509.Certificate) bool {
+	if !bytes.Equal(cert.RawIssuer, l.Issuer.RawSubject) {
+		return false
+	}
+	for _, rc := range l.CRL.TBSCertList.RevokedCertificates {
+		if rc.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateRevocationListRecord implements ValidatorFunc and verifies
+// that the passed in record value is a revocation list signed by the
+// Certificate whose hash is in the passed in key
+func ValidateRevocationListRecord(k string, val []byte) error {
+	if len(k) < crlPrefixLen {
+		return errors.New("invalid certificate revocation list record key")
+	}
+
+	if k[:crlPrefixLen] != crlPrefix {
+		return errors.New("certificate revocation list record key was not prefixed with " + crlPrefix)
+	}
+
+	issuerHash := k[crlPrefixLen:]
+	if _, err := mh.FromB58String(issuerHash); err != nil {
+		return errors.New("certificate revocation list record key did not contain valid multihash: " + err.Error())
+	}
+
+	l, err := UnmarshalRevocationList(val)
+	if err != nil {
+		return err
+	}
+
+	h, err := GetCertificateHash(l.Issuer)
+	if err != nil {
+		return err
+	}
+	if h != issuerHash {
+		return errors.New("certificate revocation list record key does not match hash of issuer certificate")
+	}
+	return nil
+}
+
+var RevocationListValidator = &record.ValidChecker{
+	Func: ValidateRevocationListRecord,
+	Sign: false,
+}
+
+// RevocationListSelector selects the most recently issued revocation list
+func RevocationListSelector(k string, vals [][]byte) (int, error) {
+	best_i := -1
+	var best_t time.Time
+
+	for i, val := range vals {
+		l, err := UnmarshalRevocationList(val)
+		if err != nil {
+			continue
+		}
+
+		t := l.CRL.TBSCertList.ThisUpdate
+		if best_i == -1 || t.After(best_t) {
+			best_i = i
+			best_t = t
+		} else if t.Equal(best_t) {
+			// This is just to make sure the selection is deterministic
+			if bytes.Compare(val, vals[best_i]) > 0 {
+				best_i = i
+			}
+		}
+	}
+	if best_i == -1 {
+		return 0, errors.New("no usable revocation lists in given set")
+	}
+
+	return best_i, nil
+}
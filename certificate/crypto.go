@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
@@ -14,6 +16,7 @@ import (
 )
 
 var CertificateIssuerError = errors.New("Signing certificate was not issued by specified issuing certificate")
+var UnsupportedKeyTypeError = errors.New("Unsupported certificate key type")
 
 func CheckSignatureFrom(cert, issuer *x509.Certificate) error {
 	if err := cert.CheckSignatureFrom(issuer); err != nil {
@@ -22,13 +25,48 @@ func CheckSignatureFrom(cert, issuer *x509.Certificate) error {
 	return nil
 }
 
-func Sign(pk *rsa.PrivateKey, data []byte) ([]byte, error) {
+// SignatureAlgorithm returns the algorithm used to sign records with
+// the private key corresponding to the given public key:
+// RSA keys use PKCS #1 v1.5 with SHA-256, ECDSA keys use SHA-256 and
+// Ed25519 keys sign the data directly
+func SignatureAlgorithm(pub crypto.PublicKey) (x509.SignatureAlgorithm, error) {
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		return x509.SHA256WithRSA, nil
+	case *ecdsa.PublicKey:
+		return x509.ECDSAWithSHA256, nil
+	case ed25519.PublicKey:
+		return x509.PureEd25519, nil
+	}
+	return x509.UnknownSignatureAlgorithm, UnsupportedKeyTypeError
+}
+
+// Sign signs the data with the signer, choosing the algorithm from the
+// signer's public key
+func Sign(signer crypto.Signer, data []byte) ([]byte, error) {
+	alg, err := SignatureAlgorithm(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	// Ed25519 hashes the data itself
+	if alg == x509.PureEd25519 {
+		return signer.Sign(rand.Reader, data, crypto.Hash(0))
+	}
+
 	hashed := sha256.Sum256(data)
-	return rsa.SignPKCS1v15(rand.Reader, pk, crypto.SHA256, hashed[:])
+	return signer.Sign(rand.Reader, hashed[:], crypto.SHA256)
 }
 
+// CheckSignature checks that the signature over the data was made with
+// the certificate's private key, choosing the algorithm from the
+// certificate's public key
 func CheckSignature(cert *x509.Certificate, data, signedData []byte) error {
-	return cert.CheckSignature(x509.SHA256WithRSA, data, signedData)
+	alg, err := SignatureAlgorithm(cert.PublicKey)
+	if err != nil {
+		return err
+	}
+	return cert.CheckSignature(alg, data, signedData)
 }
 
 func GetCertificateHash(cert *x509.Certificate) (string, error) {
@@ -7,6 +7,8 @@ import (
 	u "github.com/ipfs/go-ipfs-util"
 	logging "github.com/ipfs/go-log"
 	routing "gx/ipfs/QmPCGUjMRuBcPybZFpjhzpifwPP9wPRoiy5geTQKU4vqWA/go-libp2p-routing"
+	lru "gx/ipfs/QmVYxfoJQiZijTgPNHCHgHELvQpbsJNTg6Crmc3dQkj3yy/golang-lru"
+	ds "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore"
 	"time"
 )
 
@@ -15,16 +17,30 @@ const certPrefix = "/" + CertType + "/"
 const certPrefixLen = len(certPrefix)
 const CertFetchTimeout = time.Second * 10
 const CertPutTimeout = time.Second * 10
+const DefaultCertificateCacheSize = 256
+
+// Certificates are stored in the local datastore under this prefix
+var certCacheKeyPrefix = ds.NewKey("/iprs/certcache")
 
 var log = logging.Logger("iprs.cert")
 
+// CertificateManager puts certificates to routing and fetches them from
+// routing. Certificates are content-addressed, so fetched certificates
+// are cached indefinitely: in memory (up to DefaultCertificateCacheSize
+// certificates) and in the local datastore.
 type CertificateManager struct {
 	routing routing.ValueStore
+	cache *lru.Cache
+	dstore ds.Datastore
 }
 
-func NewCertificateManager(r routing.ValueStore) *CertificateManager {
+// If dstore is nil certificates are only cached in memory
+func NewCertificateManager(r routing.ValueStore, dstore ds.Datastore) *CertificateManager {
+	cache, _ := lru.New(DefaultCertificateCacheSize)
 	return &CertificateManager{
 		routing: r,
+		cache: cache,
+		dstore: dstore,
 	}
 }
 
@@ -50,15 +66,52 @@ func (m *CertificateManager) PutCertificate(ctx context.Context, cert *x509.Cert
 		log.Warningf("Failed to put certificate at %s: %s", certKey, err)
 		return "", err
 	}
+
+	m.cacheSet(certHash, cert, pemBytes)
 	return certHash, nil
 }
 
+// PutCertificateChain puts each of the certificates (in parallel), and
+// returns their hashes in the same order as the certificates
+func (m *CertificateManager) PutCertificateChain(ctx context.Context, certs []*x509.Certificate) ([]string, error) {
+	type putResp struct {
+		i    int
+		hash string
+		err  error
+	}
+	resp := make(chan putResp, len(certs))
+	for i, cert := range certs {
+		go func(i int, cert *x509.Certificate) {
+			h, err := m.PutCertificate(ctx, cert)
+			resp <- putResp{i, h, err}
+		}(i, cert)
+	}
+
+	hashes := make([]string, len(certs))
+	var err error
+	for range certs {
+		r := <-resp
+		if r.err != nil {
+			err = r.err
+		}
+		hashes[r.i] = r.hash
+	}
+	if err != nil {
+		return nil, err
+	}
+	return hashes, nil
+}
+
 func (m *CertificateManager) GetCertificate(ctx context.Context, certHash string) (*x509.Certificate, error) {
 	log.Debugf("CertificateManager get certificate [%s]", certHash)
 	if !u.IsValidHash(certHash) {
 		return nil, fmt.Errorf("Bad certificate hash: [%s]", certHash)
 	}
 
+	if cert, ok := m.cacheGet(certHash); ok {
+		return cert, nil
+	}
+
 	certKey := getCertPath(certHash)
 	log.Debugf("Fetching certificate at %s", certKey)
 
@@ -71,11 +124,55 @@ func (m *CertificateManager) GetCertificate(ctx context.Context, certHash string
 		return nil, err
 	}
 
+	// Make sure the certificate is the one with the requested hash
+	// before it's cached
+	if getCertificateHashFromBytes(val) != certHash {
+		log.Warningf("Certificate at %s does not match hash", certKey)
+		return nil, fmt.Errorf("Certificate at %s does not match hash", certKey)
+	}
+
 	cert, err := UnmarshalCertificate(val)
 	if err != nil {
 		log.Warningf("Failed to unmarshal certificate at %s: %s", certKey, err)
 		return nil, err
 	}
 
+	m.cacheSet(certHash, cert, val)
 	return cert, nil
 }
+
+func (m *CertificateManager) cacheGet(certHash string) (*x509.Certificate, bool) {
+	if c, ok := m.cache.Get(certHash); ok {
+		return c.(*x509.Certificate), true
+	}
+
+	if m.dstore == nil {
+		return nil, false
+	}
+	v, err := m.dstore.Get(certCacheKeyPrefix.ChildString(certHash))
+	if err != nil {
+		return nil, false
+	}
+	pemBytes, ok := v.([]byte)
+	if !ok || getCertificateHashFromBytes(pemBytes) != certHash {
+		return nil, false
+	}
+	cert, err := UnmarshalCertificate(pemBytes)
+	if err != nil {
+		return nil, false
+	}
+
+	m.cache.Add(certHash, cert)
+	return cert, true
+}
+
+func (m *CertificateManager) cacheSet(certHash string, cert *x509.Certificate, pemBytes []byte) {
+	m.cache.Add(certHash, cert)
+
+	if m.dstore == nil {
+		return
+	}
+	if err := m.dstore.Put(certCacheKeyPrefix.ChildString(certHash), pemBytes); err != nil {
+		log.Warningf("Failed to store certificate [%s] in datastore: %s", certHash, err)
+	}
+}
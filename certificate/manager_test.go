@@ -0,0 +1,122 @@
+package iprs_cert
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	mockrouting "github.com/ipfs/go-ipfs/routing/mock"
+	dshelp "github.com/ipfs/go-ipfs/thirdparty/ds-help"
+	ds "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore"
+	dssync "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore/sync"
+	testutil "gx/ipfs/QmeDA8gNhvRTsbrjEieay5wezupJDiky8xvCzDABbsGzmp/go-testutil"
+)
+
+func TestCertificateManagerCache(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	r := mockrouting.NewServer().ClientWithDatastore(ctx, testutil.RandIdentityOrFatal(t), dstore)
+	cacheStore := dssync.MutexWrap(ds.NewMapDatastore())
+
+	var chain []*x509.Certificate
+	for i := 0; i < 3; i++ {
+		chain = append(chain, generateTestCertificate(t, i))
+	}
+
+	m := NewCertificateManager(r, nil)
+	hashes, err := m.PutCertificateChain(ctx, chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, cert := range chain {
+		h, err := GetCertificateHash(cert)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hashes[i] != h {
+			t.Fatal("Expected hashes in the same order as the certificates")
+		}
+	}
+
+	// Fetch the certificate from routing, so that it's cached
+	// in the datastore
+	dsm := NewCertificateManager(r, cacheStore)
+	_, err = dsm.GetCertificate(ctx, hashes[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Remove the certificate from routing
+	err = dstore.Delete(dshelp.NewKeyFromBinary([]byte(getCertPath(hashes[0]))))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Certificate is not in routing FAIL
+	_, err = NewCertificateManager(r, nil).GetCertificate(ctx, hashes[0])
+	if err == nil {
+		t.Fatal("Expected certificate not to be found")
+	}
+
+	// Certificate is cached in memory SUCCESS
+	cert, err := m.GetCertificate(ctx, hashes[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cert.Equal(chain[0]) {
+		t.Fatal("Expected cached certificate to equal certificate")
+	}
+
+	// Certificate is cached in the datastore SUCCESS
+	cert, err = NewCertificateManager(r, cacheStore).GetCertificate(ctx, hashes[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cert.Equal(chain[0]) {
+		t.Fatal("Expected cached certificate to equal certificate")
+	}
+
+	// Certificate that doesn't match its hash FAIL
+	pemBytes, err := MarshalCertificate(chain[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.PutValue(ctx, getCertPath(hashes[1]), pemBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewCertificateManager(r, nil).GetCertificate(ctx, hashes[1])
+	if err == nil {
+		t.Fatal("Expected certificate hash mismatch error")
+	}
+}
+
+func generateTestCertificate(t *testing.T, serial int) *x509.Certificate {
+	pk, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(int64(serial)),
+		Subject: pkix.Name{
+			Organization: []string{"test cert"},
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+	}
+	derBytes, err := x509.CreateCertificate(rand.Reader, template, template, &pk.PublicKey, pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(derBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
@@ -0,0 +1,96 @@
+package iprs_cert
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"time"
+)
+
+// ExtKeyUsageIprsRecordSigning is the extended key usage OID that marks
+// a certificate as allowed to sign IPRS records. Deployments that issue
+// certificates under their own OID arc can replace it before verifying
+// records.
+var ExtKeyUsageIprsRecordSigning = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 53620, 1, 1}
+
+var CertificateExpiredError = errors.New("Certificate has expired")
+var CertificateNotYetValidError = errors.New("Certificate is not yet valid")
+var CertificateNotCAError = errors.New("Issuing certificate is not a CA certificate")
+var CertificatePathLengthError = errors.New("Certificate chain exceeds issuer's maximum path length")
+var CertificateKeyUsageError = errors.New("Certificate key usage does not allow signing IPRS records")
+
+// CheckValidityPeriod checks that the certificate is valid at the
+// given time
+func CheckValidityPeriod(cert *x509.Certificate, now time.Time) error {
+	if now.Before(cert.NotBefore) {
+		return CertificateNotYetValidError
+	}
+	if now.After(cert.NotAfter) {
+		return CertificateExpiredError
+	}
+	return nil
+}
+
+// CheckRecordSigningUsage checks that the certificate's key usage allows
+// it to sign records. If the certificate has no key usage extensions
+// it may be used for anything. If it has extended key usages they must
+// include ExtKeyUsageIprsRecordSigning (or any usage).
+func CheckRecordSigningUsage(cert *x509.Certificate) error {
+	if cert.KeyUsage != 0 && cert.KeyUsage&x509.KeyUsageDigitalSignature == 0 {
+		return CertificateKeyUsageError
+	}
+	if len(cert.ExtKeyUsage) == 0 && len(cert.UnknownExtKeyUsage) == 0 {
+		return nil
+	}
+	for _, eku := range cert.ExtKeyUsage {
+		if eku == x509.ExtKeyUsageAny {
+			return nil
+		}
+	}
+	for _, oid := range cert.UnknownExtKeyUsage {
+		if oid.Equal(ExtKeyUsageIprsRecordSigning) {
+			return nil
+		}
+	}
+	return CertificateKeyUsageError
+}
+
+// CheckIssuer checks that the certificate may issue certificates, where
+// depth is the number of intermediate certificates between the issuer
+// and the certificate that signs the record
+func CheckIssuer(issuer *x509.Certificate, depth int) error {
+	if !issuer.BasicConstraintsValid || !issuer.IsCA {
+		return CertificateNotCAError
+	}
+	if issuer.KeyUsage != 0 && issuer.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return CertificateNotCAError
+	}
+	if (issuer.MaxPathLen > 0 || issuer.MaxPathLenZero) && depth > issuer.MaxPathLen {
+		return CertificatePathLengthError
+	}
+	return nil
+}
+
+// CheckChainPolicy checks the X.509 policy of a certificate chain, ordered
+// from the certificate that signs the record up to the root certificate:
+// every certificate must be valid at the given time, the signing
+// certificate must be allowed to sign records, and every issuer must be
+// a CA that allows a path of that length below it.
+// Note that the signatures of the chain are checked separately with
+// CheckSignatureFrom.
+func CheckChainPolicy(chain []*x509.Certificate, now time.Time) error {
+	for _, cert := range chain {
+		if err := CheckValidityPeriod(cert, now); err != nil {
+			return err
+		}
+	}
+	if err := CheckRecordSigningUsage(chain[0]); err != nil {
+		return err
+	}
+	for i := 1; i < len(chain); i++ {
+		if err := CheckIssuer(chain[i], i-1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
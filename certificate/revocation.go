@@ -0,0 +1,167 @@
+package iprs_cert
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+
+	clock "github.com/dirkmc/go-iprs/clock"
+	u "github.com/ipfs/go-ipfs-util"
+	routing "gx/ipfs/QmPCGUjMRuBcPybZFpjhzpifwPP9wPRoiy5geTQKU4vqWA/go-libp2p-routing"
+	ds "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore"
+)
+
+const CRLFetchTimeout = time.Second * 10
+const CRLPutTimeout = time.Second * 10
+const DefaultRevocationListCacheTTL = time.Minute
+const DefaultRevocationListCacheSize = 256
+
+var RevocationListStaleError = errors.New("Revocation list is past its next update")
+
+// RevocationManager publishes and fetches certificate revocation lists.
+// Fetched lists (and the absence of a list) are cached for the cache TTL,
+// or until the list's next update if that is sooner. Up to
+// DefaultRevocationListCacheSize lists are cached.
+// A list whose next update has passed may be missing revocations, so by
+// default GetRevocationList returns RevocationListStaleError rather than
+// use it (see SetAllowStale).
+type RevocationManager struct {
+	routing    routing.ValueStore
+	cache      *ttlCache
+	allowStale bool
+}
+
+// If ttlp is nil, DefaultRevocationListCacheTTL is used.
+// If clk is nil the system clock is used to expire cached lists.
+func NewRevocationManager(r routing.ValueStore, ttlp *time.Duration, clk clock.Clock) *RevocationManager {
+	ttl := DefaultRevocationListCacheTTL
+	if ttlp != nil {
+		ttl = *ttlp
+	}
+
+	return &RevocationManager{
+		routing: r,
+		cache:   newTtlCache(DefaultRevocationListCacheSize, ttl, clk),
+	}
+}
+
+// SetAllowStale sets whether revocation lists whose next update has
+// passed are used (with a warning) rather than rejected. It should be
+// called before the manager is used.
+func (m *RevocationManager) SetAllowStale(allow bool) {
+	m.allowStale = allow
+}
+
+// PutRevocationList publishes a revocation list created with
+// CreateRevocationList at /crl/<issuer cert hash>, and returns the
+// issuer cert hash
+func (m *RevocationManager) PutRevocationList(ctx context.Context, data []byte) (string, error) {
+	l, err := UnmarshalRevocationList(data)
+	if err != nil {
+		log.Warningf("Failed to unmarshal revocation list: %s", err)
+		return "", err
+	}
+
+	issuerHash, err := GetCertificateHash(l.Issuer)
+	if err != nil {
+		return "", err
+	}
+	crlKey := getCRLPath(issuerHash)
+	log.Debugf("Putting revocation list at %s", crlKey)
+
+	timectx, cancel := context.WithTimeout(ctx, CRLPutTimeout)
+	defer cancel()
+
+	if err := m.routing.PutValue(timectx, crlKey, data); err != nil {
+		log.Warningf("Failed to put revocation list at %s: %s", crlKey, err)
+		return "", err
+	}
+
+	m.cacheSet(issuerHash, l)
+	return issuerHash, nil
+}
+
+// GetRevocationList gets the revocation list published by the issuer
+// with the given cert hash. If the issuer has not published a list it
+// returns nil.
+func (m *RevocationManager) GetRevocationList(ctx context.Context, issuerHash string) (*RevocationList, error) {
+	log.Debugf("RevocationManager get revocation list [%s]", issuerHash)
+	if !u.IsValidHash(issuerHash) {
+		return nil, fmt.Errorf("Bad certificate hash: [%s]", issuerHash)
+	}
+
+	if l, ok := m.cacheGet(issuerHash); ok {
+		return l, nil
+	}
+
+	crlKey := getCRLPath(issuerHash)
+	log.Debugf("Fetching revocation list at %s", crlKey)
+
+	timectx, cancel := context.WithTimeout(ctx, CRLFetchTimeout)
+	defer cancel()
+
+	val, err := m.routing.GetValue(timectx, crlKey)
+	if err == routing.ErrNotFound || err == ds.ErrNotFound {
+		log.Debugf("No revocation list at %s", crlKey)
+		m.cacheSet(issuerHash, nil)
+		return nil, nil
+	}
+	if err != nil {
+		log.Warningf("Failed to fetch revocation list at %s: %s", crlKey, err)
+		return nil, err
+	}
+
+	// Make sure the list was signed by the issuer
+	err = ValidateRevocationListRecord(crlKey, val)
+	if err != nil {
+		log.Warningf("Invalid revocation list at %s: %s", crlKey, err)
+		return nil, err
+	}
+	l, err := UnmarshalRevocationList(val)
+	if err != nil {
+		return nil, err
+	}
+
+	// The issuer should have published a newer list by now
+	next := l.CRL.TBSCertList.NextUpdate
+	if !next.IsZero() && !next.After(m.cache.clock.Now()) {
+		if !m.allowStale {
+			log.Warningf("Revocation list at %s is stale: next update was due at %s", crlKey, next)
+			return nil, RevocationListStaleError
+		}
+		log.Warningf("Using stale revocation list at %s: next update was due at %s", crlKey, next)
+	}
+
+	m.cacheSet(issuerHash, l)
+	return l, nil
+}
+
+// IsRevoked indicates whether the certificate is on the revocation list
+// published by the issuer with the given cert hash
+func (m *RevocationManager) IsRevoked(ctx context.Context, cert *x509.Certificate, issuerHash string) (bool, error) {
+	l, err := m.GetRevocationList(ctx, issuerHash)
+	if err != nil || l == nil {
+		return false, err
+	}
+	return l.IsRevoked(cert), nil
+}
+
+func (m *RevocationManager) cacheGet(issuerHash string) (*RevocationList, bool) {
+	l, ok := m.cache.get(issuerHash)
+	if !ok {
+		return nil, false
+	}
+	return l.(*RevocationList), true
+}
+
+func (m *RevocationManager) cacheSet(issuerHash string, l *RevocationList) {
+	// Don't cache the list past the time it is due to be updated (so
+	// stale lists are not cached)
+	var next time.Time
+	if l != nil {
+		next = l.CRL.TBSCertList.NextUpdate
+	}
+	m.cache.set(issuerHash, l, next)
+}
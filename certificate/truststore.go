@@ -0,0 +1,131 @@
+package iprs_cert
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// TrustStore is a set of pinned root certificates, indexed by certificate
+// hash (ie the hash in the IPRS keys of the names that they sign for)
+type TrustStore struct {
+	lk    sync.RWMutex
+	roots map[string]*x509.Certificate
+}
+
+func NewTrustStore() *TrustStore {
+	return &TrustStore{roots: make(map[string]*x509.Certificate)}
+}
+
+// LoadTrustStore creates a trust store from PEM files. If a path is a
+// directory, all the .pem, .crt and .cert files in it are loaded.
+func LoadTrustStore(paths ...string) (*TrustStore, error) {
+	s := NewTrustStore()
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if fi.IsDir() {
+			err = s.LoadDir(p)
+		} else {
+			err = s.LoadFile(p)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// AddCertificate pins the certificate, and returns its hash
+func (s *TrustStore) AddCertificate(cert *x509.Certificate) (string, error) {
+	h, err := GetCertificateHash(cert)
+	if err != nil {
+		return "", err
+	}
+
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.roots[h] = cert
+	return h, nil
+}
+
+// LoadPEM pins all the certificates in the PEM encoded data
+func (s *TrustStore) LoadPEM(data []byte) error {
+	found := false
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("Could not parse certificate: %s", err)
+		}
+		if _, err = s.AddCertificate(cert); err != nil {
+			return err
+		}
+		found = true
+	}
+
+	if !found {
+		return fmt.Errorf("No certificates found in PEM data")
+	}
+	return nil
+}
+
+// LoadFile pins all the certificates in the PEM file
+func (s *TrustStore) LoadFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err = s.LoadPEM(data); err != nil {
+		return fmt.Errorf("Could not load %s: %s", path, err)
+	}
+	return nil
+}
+
+// LoadDir pins all the certificates in the .pem, .crt and .cert files
+// in the directory
+func (s *TrustStore) LoadDir(dir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range files {
+		ext := strings.ToLower(filepath.Ext(fi.Name()))
+		if fi.IsDir() || (ext != ".pem" && ext != ".crt" && ext != ".cert") {
+			continue
+		}
+		if err = s.LoadFile(filepath.Join(dir, fi.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetCertificate returns the pinned certificate with the given hash
+func (s *TrustStore) GetCertificate(certHash string) (*x509.Certificate, bool) {
+	s.lk.RLock()
+	defer s.lk.RUnlock()
+	cert, ok := s.roots[certHash]
+	return cert, ok
+}
+
+// IsTrusted indicates whether the certificate with the given hash is pinned
+func (s *TrustStore) IsTrusted(certHash string) bool {
+	_, ok := s.GetCertificate(certHash)
+	return ok
+}
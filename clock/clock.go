@@ -0,0 +1,37 @@
+package iprs_clock
+
+import (
+	"time"
+)
+
+// Clock provides the current time. Components that make time-dependent
+// decisions (validity checks, caching etc) accept a Clock so that they
+// can be tested deterministically with a MockClock.
+type Clock interface {
+	Now() time.Time
+	// After returns a channel that receives the current time once the
+	// duration has elapsed
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (c *realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (c *realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// RealClock returns the system time
+var RealClock Clock = &realClock{}
+
+// OrRealClock returns the given clock, or RealClock if the given
+// clock is nil
+func OrRealClock(clk Clock) Clock {
+	if clk == nil {
+		return RealClock
+	}
+	return clk
+}
@@ -0,0 +1,69 @@
+package iprs_clock
+
+import (
+	"sync"
+	"time"
+)
+
+// MockClock is a Clock whose time only changes when it is explicitly
+// set or advanced
+type MockClock struct {
+	lk     sync.Mutex
+	now    time.Time
+	timers []*mockTimer
+}
+
+type mockTimer struct {
+	at time.Time
+	c  chan time.Time
+}
+
+func NewMockClock(now time.Time) *MockClock {
+	return &MockClock{now: now}
+}
+
+func (c *MockClock) Now() time.Time {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	return c.now
+}
+
+// After returns a channel that receives the current time once the clock
+// has been set or advanced past the duration
+func (c *MockClock) After(d time.Duration) <-chan time.Time {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	t := &mockTimer{c.now.Add(d), make(chan time.Time, 1)}
+	c.timers = append(c.timers, t)
+	c.fire()
+	return t.c
+}
+
+// Set the current time
+func (c *MockClock) Set(now time.Time) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	c.now = now
+	c.fire()
+}
+
+// Add advances (or with a negative duration, rewinds) the current time
+func (c *MockClock) Add(d time.Duration) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	c.now = c.now.Add(d)
+	c.fire()
+}
+
+// fire sends the current time to timers that are due, and removes them
+func (c *MockClock) fire() {
+	var pending []*mockTimer
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			pending = append(pending, t)
+			continue
+		}
+		t.c <- c.now
+	}
+	c.timers = pending
+}
@@ -32,8 +32,10 @@ package iprs
 import (
 	context "context"
 	rsp "github.com/dirkmc/go-iprs/path"
+	pb "github.com/dirkmc/go-iprs/pb"
 	r "github.com/dirkmc/go-iprs/record"
 	path "github.com/ipfs/go-ipfs/path"
+	"time"
 )
 
 // RecordSystem represents a cohesive record publishing and resolving system.
@@ -75,12 +77,34 @@ type Resolver interface {
 	// Most users should use Resolve, since the default limit works well
 	// in most real-world situations.
 	ResolveN(ctx context.Context, name string, depth int) (value path.Path, err error)
+
+	// ResolveWithTtl is like Resolve but also returns how long the
+	// result may be cached for. This is the shortest TTL of any record
+	// along the way (records may specify their own TTL).
+	ResolveWithTtl(ctx context.Context, name string) (value path.Path, ttl time.Duration, err error)
 }
 
 // Publisher is an object capable of publishing a Record
 type Publisher interface {
 	// Publish establishes a name-value mapping.
 	Publish(ctx context.Context, iprsKey rsp.IprsPath, record *r.Record) error
+
+	// PublishEntry publishes an entry for the record that has already
+	// been signed, eg with an offline key. The entry's sequence number
+	// must be higher than that of the previously published entry.
+	PublishEntry(ctx context.Context, iprsKey rsp.IprsPath, record *r.Record, entry *pb.IprsEntry) error
+
+	// PublishIfSeq is like Publish, but fails with a
+	// *publisher.SeqConflictError if the sequence number of the current
+	// entry is not expectedSeq. It allows callers that read the current
+	// entry, then publish a new one, to detect concurrent updates.
+	PublishIfSeq(ctx context.Context, iprsKey rsp.IprsPath, record *r.Record, expectedSeq uint64) error
+
+	// DryRun signs an entry for the record with the sequence number that
+	// Publish would use, and checks that the network would accept it,
+	// without publishing anything. If a check fails it returns a
+	// *record.SelfCheckError.
+	DryRun(ctx context.Context, iprsKey rsp.IprsPath, record *r.Record) (*pb.IprsEntry, error)
 	/*
 		// Publish establishes a name-value mapping.
 		// TODO make this not PrivKey specific.
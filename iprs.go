@@ -5,7 +5,9 @@ import (
 	"strings"
 	"time"
 
+	clock "github.com/dirkmc/go-iprs/clock"
 	rsp "github.com/dirkmc/go-iprs/path"
+	pb "github.com/dirkmc/go-iprs/pb"
 	psh "github.com/dirkmc/go-iprs/publisher"
 	r "github.com/dirkmc/go-iprs/record"
 	rec "github.com/dirkmc/go-iprs/record"
@@ -15,6 +17,7 @@ import (
 	logging "github.com/ipfs/go-log"
 	mh "gx/ipfs/QmYeKnKpubCMRiq3PGZcTREErthbb5Q9cXsCoSkD9bjEBd/go-multihash"
 	isd "gx/ipfs/QmZmmuAXgX73UQmX1jRKjTGmjzq24Jinqkq8vzkBtno4uX/go-is-domain"
+	ds "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore"
 )
 
 var log = logging.Logger("iprs")
@@ -37,10 +40,22 @@ type mprs struct {
 	publishers map[string]Publisher
 }
 
-func NewRecordSystem(vstore vs.ValueStore, cachesize int) RecordSystem {
-	factory := rec.NewRecordFactory(vstore)
-	seqm := psh.NewSeqManager(vstore)
-	cachedvs := vs.NewCachedValueStore(vstore, cachesize, nil)
+// NewRecordSystem creates a RecordSystem that checks record validity
+// and cache expiry against the given clock. If clk is nil the system
+// clock is used.
+func NewRecordSystem(vstore vs.ValueStore, cachesize int, clk clock.Clock) RecordSystem {
+	return NewRecordSystemWithDatastore(vstore, nil, cachesize, clk)
+}
+
+// NewRecordSystemWithDatastore creates a RecordSystem that stores the
+// sequence numbers of published records, and caches fetched
+// certificates, in the given datastore. If dstore is nil sequence
+// numbers are read from the network and certificates are only cached
+// in memory.
+func NewRecordSystemWithDatastore(vstore vs.ValueStore, dstore ds.Datastore, cachesize int, clk clock.Clock) RecordSystem {
+	factory := rec.NewRecordFactoryWithDatastore(vstore, dstore, clk)
+	seqm := psh.NewPersistentSeqManager(vstore, dstore)
+	cachedvs := vs.NewCachedValueStore(vstore, cachesize, nil, clk)
 	return &mprs{
 		resolvers: map[string]rsv.Lookup{
 			"dns":      rsv.NewDNSResolver(),
@@ -48,7 +63,7 @@ func NewRecordSystem(vstore vs.ValueStore, cachesize int) RecordSystem {
 			"dht":      rsv.NewDHTResolver(cachedvs, factory),
 		},
 		publishers: map[string]Publisher{
-			"/iprs/": psh.NewDHTPublisher(seqm),
+			"/iprs/": psh.NewDHTPublisher(seqm, factory),
 		},
 	}
 }
@@ -71,8 +86,19 @@ func (ns *mprs) ResolveN(ctx context.Context, name string, depth int) (path.Path
 	return rsv.Resolve(ctx, ns, name, depth, "/iprs/", "/ipns/")
 }
 
+// ResolveWithTtl implements Resolver.
+func (ns *mprs) ResolveWithTtl(ctx context.Context, name string) (path.Path, time.Duration, error) {
+	if strings.HasPrefix(name, "/ipfs/") || !strings.HasPrefix(name, "/") {
+		// IPFS paths are immutable
+		p, err := ns.Resolve(ctx, name)
+		return p, DefaultRecordTTL, err
+	}
+
+	return rsv.ResolveWithTtl(ctx, ns, name, rsv.DefaultDepthLimit, "/iprs/", "/ipns/")
+}
+
 // ResolveOnce implements Lookup.
-func (ns *mprs) ResolveOnce(ctx context.Context, name string) (string, error) {
+func (ns *mprs) ResolveOnce(ctx context.Context, name string) (string, time.Duration, error) {
 	log.Debugf("RecordSystem ResolveOnce %s", name)
 
 	if !strings.HasPrefix(name, "/iprs/") && !strings.HasPrefix(name, "/ipns/") {
@@ -82,25 +108,28 @@ func (ns *mprs) ResolveOnce(ctx context.Context, name string) (string, error) {
 	segments := strings.SplitN(name, "/", 4)
 	if len(segments) < 3 || segments[0] != "" {
 		log.Warningf("Invalid name syntax for %s", name)
-		return "", rsv.ErrResolveFailed
+		return "", 0, rsv.ErrResolveFailed
 	}
 
-	resolveOnce := func(rname string, key string) (string, error) {
+	resolveOnce := func(rname string, key string) (string, time.Duration, error) {
 		res, ok := ns.resolvers[rname]
 		if !ok {
 			log.Warningf("Could not find resolver with name %s", rname)
-			return "", rsv.ErrResolveFailed
+			return "", 0, rsv.ErrResolveFailed
+		}
+		p, ttl, err := res.ResolveOnce(ctx, key)
+		if err == rsv.ErrNameDeleted {
+			return "", ttl, err
 		}
-		p, err := res.ResolveOnce(ctx, key)
 		if err != nil {
 			log.Warningf("Could not resolve with %s resolver: %s", rname, err)
-			return "", rsv.ErrResolveFailed
+			return "", 0, rsv.ErrResolveFailed
 		}
 
 		if len(segments) > 3 {
-			return strings.TrimRight(p, "/") + "/" + segments[3], nil
+			return strings.TrimRight(p, "/") + "/" + segments[3], ttl, nil
 		}
-		return p, nil
+		return p, ttl, nil
 	}
 
 	// Resolver selection:
@@ -128,3 +157,18 @@ func (ns *mprs) ResolveOnce(ctx context.Context, name string) (string, error) {
 func (ns *mprs) Publish(ctx context.Context, iprsKey rsp.IprsPath, record *r.Record) error {
 	return ns.publishers["/iprs/"].Publish(ctx, iprsKey, record)
 }
+
+// PublishEntry implements Publisher
+func (ns *mprs) PublishEntry(ctx context.Context, iprsKey rsp.IprsPath, record *r.Record, entry *pb.IprsEntry) error {
+	return ns.publishers["/iprs/"].PublishEntry(ctx, iprsKey, record, entry)
+}
+
+// PublishIfSeq implements Publisher
+func (ns *mprs) PublishIfSeq(ctx context.Context, iprsKey rsp.IprsPath, record *r.Record, expectedSeq uint64) error {
+	return ns.publishers["/iprs/"].PublishIfSeq(ctx, iprsKey, record, expectedSeq)
+}
+
+// DryRun implements Publisher
+func (ns *mprs) DryRun(ctx context.Context, iprsKey rsp.IprsPath, record *r.Record) (*pb.IprsEntry, error) {
+	return ns.publishers["/iprs/"].DryRun(ctx, iprsKey, record)
+}
@@ -3,6 +3,7 @@ package iprs
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	context "context"
 
@@ -19,6 +20,7 @@ import (
 
 type mockResolver struct {
 	entries map[string]string
+	ttl     time.Duration
 }
 
 func testResolution(t *testing.T, resolver Resolver, name string, depth int, expected string, expError error) {
@@ -35,8 +37,8 @@ func testResolution(t *testing.T, resolver Resolver, name string, depth int, exp
 	}
 }
 
-func (r *mockResolver) ResolveOnce(ctx context.Context, name string) (string, error) {
-	return r.entries[name], nil
+func (r *mockResolver) ResolveOnce(ctx context.Context, name string) (string, time.Duration, error) {
+	return r.entries[name], r.ttl, nil
 }
 
 func mockResolverOne() *mockResolver {
@@ -85,6 +87,40 @@ func TestNamesysResolution(t *testing.T) {
 	testResolution(t, r, "/ipns/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD", 3, "/ipns/QmatmE9msSfkKxoffpHwNLNKgwZG8eT9Bud6YoPab52vpy", ErrRecursion)
 }
 
+func TestNamesysResolutionTtl(t *testing.T) {
+	dht := mockResolverOne()
+	dht.ttl = time.Second * 30
+	dns := mockResolverTwo()
+	dns.ttl = time.Minute
+	r := &mprs{
+		resolvers: map[string]rsv.Lookup{
+			"dht": dht,
+			"dns": dns,
+		},
+	}
+
+	// The TTL is the shortest TTL of any name along the way
+	p, ttl, err := r.ResolveWithTtl(context.Background(), "/ipns/ipfs.io")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.String() != "/ipfs/Qmcqtw8FfrVSBaRmbWwHxt3AuySBhJLcvmFYi3Lbc4xnwj" {
+		t.Fatalf("Resolved to %s", p)
+	}
+	if ttl != time.Second*30 {
+		t.Fatalf("Expected TTL of %s but got %s", time.Second*30, ttl)
+	}
+
+	dns.ttl = time.Second * 10
+	_, ttl, err = r.ResolveWithTtl(context.Background(), "/ipns/ipfs.io")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl != time.Second*10 {
+		t.Fatalf("Expected TTL of %s but got %s", time.Second*10, ttl)
+	}
+}
+
 /*
 func TestPublishWithCache0(t *testing.T) {
 	dst := dssync.MutexWrap(ds.NewMapDatastore())
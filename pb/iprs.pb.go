@@ -11,6 +11,11 @@ It is generated from these files:
 
 It has these top-level messages:
 	IprsEntry
+	CompositeValidity
+	MultiKeyVerification
+	MultiKeySignature
+	AllowList
+	SignedAllowList
 */
 package iprs_pb
 
@@ -36,15 +41,30 @@ const (
 	IprsEntry_EOL IprsEntry_ValidityType = 0
 	// Setting a time range says "this record is valid between x and y"
 	IprsEntry_TimeRange IprsEntry_ValidityType = 1
+	// Setting a composite says "this record is valid when all / any of
+	// these other validity clauses are valid"
+	IprsEntry_Composite IprsEntry_ValidityType = 2
+	// Setting a recurring window says "this record is valid during
+	// these hours on these days, between x and y"
+	IprsEntry_Recurring IprsEntry_ValidityType = 3
+	// Setting multiple time ranges says "this record is valid between
+	// x and y, or between z and w, ..."
+	IprsEntry_MultiRange IprsEntry_ValidityType = 4
 )
 
 var IprsEntry_ValidityType_name = map[int32]string{
 	0: "EOL",
 	1: "TimeRange",
+	2: "Composite",
+	3: "Recurring",
+	4: "MultiRange",
 }
 var IprsEntry_ValidityType_value = map[string]int32{
-	"EOL":       0,
-	"TimeRange": 1,
+	"EOL":        0,
+	"TimeRange":  1,
+	"Composite":  2,
+	"Recurring":  3,
+	"MultiRange": 4,
 }
 
 func (x IprsEntry_ValidityType) Enum() *IprsEntry_ValidityType {
@@ -72,15 +92,20 @@ const (
 	IprsEntry_Key IprsEntry_VerificationType = 0
 	// Cert verification verifies a record is signed by a certificate issued by a CA
 	IprsEntry_Cert IprsEntry_VerificationType = 1
+	// MultiKey verification verifies a record is signed by at least
+	// k of a set of n private keys
+	IprsEntry_MultiKey IprsEntry_VerificationType = 2
 )
 
 var IprsEntry_VerificationType_name = map[int32]string{
 	0: "Key",
 	1: "Cert",
+	2: "MultiKey",
 }
 var IprsEntry_VerificationType_value = map[string]int32{
-	"Key":  0,
-	"Cert": 1,
+	"Key":      0,
+	"Cert":     1,
+	"MultiKey": 2,
 }
 
 func (x IprsEntry_VerificationType) Enum() *IprsEntry_VerificationType {
@@ -103,6 +128,80 @@ func (IprsEntry_VerificationType) EnumDescriptor() ([]byte, []int) {
 	return fileDescriptor0, []int{0, 1}
 }
 
+type IprsEntry_ValueType int32
+
+const (
+	// The value is a path that the name resolves to
+	IprsEntry_ValueType_Path IprsEntry_ValueType = 0
+	// A tombstone says "this name has been deleted". Its value is empty.
+	IprsEntry_ValueType_Tombstone IprsEntry_ValueType = 1
+)
+
+var IprsEntry_ValueType_name = map[int32]string{
+	0: "Path",
+	1: "Tombstone",
+}
+var IprsEntry_ValueType_value = map[string]int32{
+	"Path":      0,
+	"Tombstone": 1,
+}
+
+func (x IprsEntry_ValueType) Enum() *IprsEntry_ValueType {
+	p := new(IprsEntry_ValueType)
+	*p = x
+	return p
+}
+func (x IprsEntry_ValueType) String() string {
+	return proto.EnumName(IprsEntry_ValueType_name, int32(x))
+}
+func (x *IprsEntry_ValueType) UnmarshalJSON(data []byte) error {
+	value, err := proto.UnmarshalJSONEnum(IprsEntry_ValueType_value, data, "IprsEntry_ValueType")
+	if err != nil {
+		return err
+	}
+	*x = IprsEntry_ValueType(value)
+	return nil
+}
+func (IprsEntry_ValueType) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{0, 2} }
+
+type CompositeValidity_Operator int32
+
+const (
+	// All clauses must be valid
+	CompositeValidity_And CompositeValidity_Operator = 0
+	// At least one clause must be valid
+	CompositeValidity_Or CompositeValidity_Operator = 1
+)
+
+var CompositeValidity_Operator_name = map[int32]string{
+	0: "And",
+	1: "Or",
+}
+var CompositeValidity_Operator_value = map[string]int32{
+	"And": 0,
+	"Or":  1,
+}
+
+func (x CompositeValidity_Operator) Enum() *CompositeValidity_Operator {
+	p := new(CompositeValidity_Operator)
+	*p = x
+	return p
+}
+func (x CompositeValidity_Operator) String() string {
+	return proto.EnumName(CompositeValidity_Operator_name, int32(x))
+}
+func (x *CompositeValidity_Operator) UnmarshalJSON(data []byte) error {
+	value, err := proto.UnmarshalJSONEnum(CompositeValidity_Operator_value, data, "CompositeValidity_Operator")
+	if err != nil {
+		return err
+	}
+	*x = CompositeValidity_Operator(value)
+	return nil
+}
+func (CompositeValidity_Operator) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor0, []int{1, 0}
+}
+
 type IprsEntry struct {
 	Value            []byte                      `protobuf:"bytes,1,req,name=value" json:"value,omitempty"`
 	Signature        []byte                      `protobuf:"bytes,2,req,name=signature" json:"signature,omitempty"`
@@ -111,6 +210,9 @@ type IprsEntry struct {
 	ValidityType     *IprsEntry_ValidityType     `protobuf:"varint,5,opt,name=validityType,enum=iprs.pb.IprsEntry_ValidityType" json:"validityType,omitempty"`
 	Validity         []byte                      `protobuf:"bytes,6,opt,name=validity" json:"validity,omitempty"`
 	Sequence         *uint64                     `protobuf:"varint,7,opt,name=sequence" json:"sequence,omitempty"`
+	SignatureVersion *uint32                     `protobuf:"varint,8,opt,name=signatureVersion" json:"signatureVersion,omitempty"`
+	Ttl              *uint64                     `protobuf:"varint,9,opt,name=ttl" json:"ttl,omitempty"`
+	ValueType        *IprsEntry_ValueType        `protobuf:"varint,10,opt,name=valueType,enum=iprs.pb.IprsEntry_ValueType" json:"valueType,omitempty"`
 	XXX_unrecognized []byte                      `json:"-"`
 }
 
@@ -168,31 +270,265 @@ func (m *IprsEntry) GetSequence() uint64 {
 	return 0
 }
 
+func (m *IprsEntry) GetSignatureVersion() uint32 {
+	if m != nil && m.SignatureVersion != nil {
+		return *m.SignatureVersion
+	}
+	return 0
+}
+
+func (m *IprsEntry) GetTtl() uint64 {
+	if m != nil && m.Ttl != nil {
+		return *m.Ttl
+	}
+	return 0
+}
+
+func (m *IprsEntry) GetValueType() IprsEntry_ValueType {
+	if m != nil && m.ValueType != nil {
+		return *m.ValueType
+	}
+	return IprsEntry_Path
+}
+
+type CompositeValidity struct {
+	Operator         *CompositeValidity_Operator `protobuf:"varint,1,req,name=operator,enum=iprs.pb.CompositeValidity_Operator" json:"operator,omitempty"`
+	Clauses          []*CompositeValidity_Clause `protobuf:"bytes,2,rep,name=clauses" json:"clauses,omitempty"`
+	XXX_unrecognized []byte                      `json:"-"`
+}
+
+func (m *CompositeValidity) Reset()                    { *m = CompositeValidity{} }
+func (m *CompositeValidity) String() string            { return proto.CompactTextString(m) }
+func (*CompositeValidity) ProtoMessage()               {}
+func (*CompositeValidity) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+
+func (m *CompositeValidity) GetOperator() CompositeValidity_Operator {
+	if m != nil && m.Operator != nil {
+		return *m.Operator
+	}
+	return CompositeValidity_And
+}
+
+func (m *CompositeValidity) GetClauses() []*CompositeValidity_Clause {
+	if m != nil {
+		return m.Clauses
+	}
+	return nil
+}
+
+type CompositeValidity_Clause struct {
+	ValidityType     *IprsEntry_ValidityType `protobuf:"varint,1,req,name=validityType,enum=iprs.pb.IprsEntry_ValidityType" json:"validityType,omitempty"`
+	Validity         []byte                  `protobuf:"bytes,2,req,name=validity" json:"validity,omitempty"`
+	XXX_unrecognized []byte                  `json:"-"`
+}
+
+func (m *CompositeValidity_Clause) Reset()                    { *m = CompositeValidity_Clause{} }
+func (m *CompositeValidity_Clause) String() string            { return proto.CompactTextString(m) }
+func (*CompositeValidity_Clause) ProtoMessage()               {}
+func (*CompositeValidity_Clause) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1, 0} }
+
+func (m *CompositeValidity_Clause) GetValidityType() IprsEntry_ValidityType {
+	if m != nil && m.ValidityType != nil {
+		return *m.ValidityType
+	}
+	return IprsEntry_EOL
+}
+
+func (m *CompositeValidity_Clause) GetValidity() []byte {
+	if m != nil {
+		return m.Validity
+	}
+	return nil
+}
+
+type MultiKeyVerification struct {
+	Threshold        *uint32  `protobuf:"varint,1,req,name=threshold" json:"threshold,omitempty"`
+	KeyHashes        []string `protobuf:"bytes,2,rep,name=keyHashes" json:"keyHashes,omitempty"`
+	XXX_unrecognized []byte   `json:"-"`
+}
+
+func (m *MultiKeyVerification) Reset()                    { *m = MultiKeyVerification{} }
+func (m *MultiKeyVerification) String() string            { return proto.CompactTextString(m) }
+func (*MultiKeyVerification) ProtoMessage()               {}
+func (*MultiKeyVerification) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+
+func (m *MultiKeyVerification) GetThreshold() uint32 {
+	if m != nil && m.Threshold != nil {
+		return *m.Threshold
+	}
+	return 0
+}
+
+func (m *MultiKeyVerification) GetKeyHashes() []string {
+	if m != nil {
+		return m.KeyHashes
+	}
+	return nil
+}
+
+type MultiKeySignature struct {
+	Signatures       []*MultiKeySignature_Signature `protobuf:"bytes,1,rep,name=signatures" json:"signatures,omitempty"`
+	XXX_unrecognized []byte                         `json:"-"`
+}
+
+func (m *MultiKeySignature) Reset()                    { *m = MultiKeySignature{} }
+func (m *MultiKeySignature) String() string            { return proto.CompactTextString(m) }
+func (*MultiKeySignature) ProtoMessage()               {}
+func (*MultiKeySignature) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+
+func (m *MultiKeySignature) GetSignatures() []*MultiKeySignature_Signature {
+	if m != nil {
+		return m.Signatures
+	}
+	return nil
+}
+
+type MultiKeySignature_Signature struct {
+	KeyIndex         *uint32 `protobuf:"varint,1,req,name=keyIndex" json:"keyIndex,omitempty"`
+	Signature        []byte  `protobuf:"bytes,2,req,name=signature" json:"signature,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *MultiKeySignature_Signature) Reset()                    { *m = MultiKeySignature_Signature{} }
+func (m *MultiKeySignature_Signature) String() string            { return proto.CompactTextString(m) }
+func (*MultiKeySignature_Signature) ProtoMessage()               {}
+func (*MultiKeySignature_Signature) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3, 0} }
+
+func (m *MultiKeySignature_Signature) GetKeyIndex() uint32 {
+	if m != nil && m.KeyIndex != nil {
+		return *m.KeyIndex
+	}
+	return 0
+}
+
+func (m *MultiKeySignature_Signature) GetSignature() []byte {
+	if m != nil {
+		return m.Signature
+	}
+	return nil
+}
+
+type AllowList struct {
+	Sequence         *uint64  `protobuf:"varint,1,req,name=sequence" json:"sequence,omitempty"`
+	CertHashes       []string `protobuf:"bytes,2,rep,name=certHashes" json:"certHashes,omitempty"`
+	Subjects         []string `protobuf:"bytes,3,rep,name=subjects" json:"subjects,omitempty"`
+	XXX_unrecognized []byte   `json:"-"`
+}
+
+func (m *AllowList) Reset()                    { *m = AllowList{} }
+func (m *AllowList) String() string            { return proto.CompactTextString(m) }
+func (*AllowList) ProtoMessage()               {}
+func (*AllowList) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+
+func (m *AllowList) GetSequence() uint64 {
+	if m != nil && m.Sequence != nil {
+		return *m.Sequence
+	}
+	return 0
+}
+
+func (m *AllowList) GetCertHashes() []string {
+	if m != nil {
+		return m.CertHashes
+	}
+	return nil
+}
+
+func (m *AllowList) GetSubjects() []string {
+	if m != nil {
+		return m.Subjects
+	}
+	return nil
+}
+
+type SignedAllowList struct {
+	AllowList        []byte `protobuf:"bytes,1,req,name=allowList" json:"allowList,omitempty"`
+	Signature        []byte `protobuf:"bytes,2,req,name=signature" json:"signature,omitempty"`
+	Issuer           []byte `protobuf:"bytes,3,req,name=issuer" json:"issuer,omitempty"`
+	XXX_unrecognized []byte `json:"-"`
+}
+
+func (m *SignedAllowList) Reset()                    { *m = SignedAllowList{} }
+func (m *SignedAllowList) String() string            { return proto.CompactTextString(m) }
+func (*SignedAllowList) ProtoMessage()               {}
+func (*SignedAllowList) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+
+func (m *SignedAllowList) GetAllowList() []byte {
+	if m != nil {
+		return m.AllowList
+	}
+	return nil
+}
+
+func (m *SignedAllowList) GetSignature() []byte {
+	if m != nil {
+		return m.Signature
+	}
+	return nil
+}
+
+func (m *SignedAllowList) GetIssuer() []byte {
+	if m != nil {
+		return m.Issuer
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterType((*IprsEntry)(nil), "iprs.pb.IprsEntry")
+	proto.RegisterType((*CompositeValidity)(nil), "iprs.pb.CompositeValidity")
+	proto.RegisterType((*CompositeValidity_Clause)(nil), "iprs.pb.CompositeValidity.Clause")
+	proto.RegisterType((*MultiKeyVerification)(nil), "iprs.pb.MultiKeyVerification")
+	proto.RegisterType((*MultiKeySignature)(nil), "iprs.pb.MultiKeySignature")
+	proto.RegisterType((*MultiKeySignature_Signature)(nil), "iprs.pb.MultiKeySignature.Signature")
+	proto.RegisterType((*AllowList)(nil), "iprs.pb.AllowList")
+	proto.RegisterType((*SignedAllowList)(nil), "iprs.pb.SignedAllowList")
 	proto.RegisterEnum("iprs.pb.IprsEntry_ValidityType", IprsEntry_ValidityType_name, IprsEntry_ValidityType_value)
 	proto.RegisterEnum("iprs.pb.IprsEntry_VerificationType", IprsEntry_VerificationType_name, IprsEntry_VerificationType_value)
+	proto.RegisterEnum("iprs.pb.IprsEntry_ValueType", IprsEntry_ValueType_name, IprsEntry_ValueType_value)
+	proto.RegisterEnum("iprs.pb.CompositeValidity_Operator", CompositeValidity_Operator_name, CompositeValidity_Operator_value)
 }
 
 func init() { proto.RegisterFile("iprs.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 261 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x7c, 0x8f, 0xcf, 0x6a, 0x83, 0x40,
-	0x10, 0xc6, 0xb3, 0x6a, 0x9a, 0x38, 0x58, 0x59, 0x86, 0x1e, 0x96, 0xd2, 0x83, 0x08, 0x0d, 0xd2,
-	0x83, 0x87, 0xbc, 0x42, 0xc9, 0xa1, 0x7f, 0xa0, 0xb0, 0x84, 0xdc, 0xad, 0x99, 0x86, 0x85, 0x54,
-	0xed, 0xb8, 0x0a, 0xbe, 0x44, 0x9f, 0xb9, 0xc4, 0x52, 0xab, 0x29, 0xf4, 0xf8, 0xfb, 0x66, 0xe6,
-	0xfb, 0x31, 0x00, 0xa6, 0xe2, 0x3a, 0xad, 0xb8, 0xb4, 0x25, 0x86, 0x4c, 0x79, 0xc9, 0xfb, 0xda,
-	0x96, 0x4c, 0x69, 0xf5, 0x1a, 0x7f, 0xba, 0xe0, 0x3f, 0x54, 0x5c, 0x6f, 0x0a, 0xcb, 0x1d, 0x5e,
-	0xc1, 0xbc, 0xcd, 0x8e, 0x0d, 0x29, 0x11, 0x39, 0x49, 0xa0, 0xbf, 0x01, 0x6f, 0xc0, 0xaf, 0xcd,
-	0xa1, 0xc8, 0x6c, 0xc3, 0xa4, 0x9c, 0x7e, 0xf2, 0x1b, 0xe0, 0x0e, 0x64, 0x4b, 0x6c, 0xde, 0x4c,
-	0x9e, 0x59, 0x53, 0x16, 0xdb, 0xae, 0x22, 0xe5, 0x46, 0x4e, 0x12, 0xae, 0xef, 0xd2, 0xa9, 0x2c,
-	0x1d, 0x44, 0xe9, 0xee, 0xec, 0x42, 0xff, 0xe9, 0xc0, 0x18, 0x82, 0x71, 0xa6, 0xbc, 0x5e, 0x3c,
-	0xc9, 0xf0, 0x11, 0x82, 0x36, 0x3b, 0x9a, 0xbd, 0xb1, 0x5d, 0xef, 0x9d, 0x47, 0x22, 0x09, 0xd7,
-	0xab, 0x7f, 0xbc, 0xa3, 0x6d, 0x3d, 0xb9, 0xc5, 0x6b, 0x58, 0xfe, 0xb0, 0xba, 0x88, 0x44, 0x12,
-	0xe8, 0x81, 0x4f, 0xb3, 0x9a, 0x3e, 0x1a, 0x2a, 0x72, 0x52, 0x8b, 0x48, 0x24, 0x9e, 0x1e, 0x38,
-	0x5e, 0x41, 0x30, 0x6e, 0xc5, 0x05, 0xb8, 0x9b, 0x97, 0x67, 0x39, 0xc3, 0x4b, 0xf0, 0xb7, 0xe6,
-	0x9d, 0x74, 0x56, 0x1c, 0x48, 0x8a, 0xf8, 0x16, 0xe4, 0xf9, 0xd7, 0xa7, 0xdd, 0x27, 0xea, 0xe4,
-	0x0c, 0x97, 0xe0, 0xdd, 0x13, 0x5b, 0x29, 0xbe, 0x02, 0x00, 0x00, 0xff, 0xff, 0xfd, 0xf7, 0xb1,
-	0x8a, 0xad, 0x01, 0x00, 0x00,
+	// 600 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xa4, 0x54, 0x41, 0x6f, 0xd3, 0x4c,
+	0x10, 0xad, 0x9d, 0x34, 0xb1, 0xe7, 0x73, 0xfb, 0x6d, 0x57, 0x15, 0xb2, 0x42, 0x05, 0xc6, 0xf4,
+	0x60, 0x71, 0xc8, 0xa1, 0xdc, 0xe0, 0x80, 0xaa, 0x50, 0x89, 0x8a, 0xa2, 0xa0, 0xa5, 0xea, 0xdd,
+	0x75, 0x86, 0x66, 0xa9, 0x6b, 0x87, 0xdd, 0x75, 0x21, 0x3f, 0x85, 0x1b, 0x3f, 0x8c, 0x1f, 0x83,
+	0x76, 0x6d, 0x6f, 0x9c, 0xa6, 0xca, 0x85, 0x9b, 0xdf, 0xdb, 0x37, 0x33, 0x6f, 0xc6, 0x3b, 0x0b,
+	0xc0, 0x17, 0x42, 0x8e, 0x17, 0xa2, 0x54, 0x25, 0x1d, 0xd6, 0xdf, 0xd7, 0xf1, 0x9f, 0x3e, 0xf8,
+	0xe7, 0x0b, 0x21, 0xcf, 0x0a, 0x25, 0x96, 0xf4, 0x10, 0x76, 0xef, 0xd3, 0xbc, 0xc2, 0xd0, 0x89,
+	0xdc, 0x24, 0x60, 0x35, 0xa0, 0x47, 0xe0, 0x4b, 0x7e, 0x53, 0xa4, 0xaa, 0x12, 0x18, 0xba, 0xe6,
+	0x64, 0x45, 0xd0, 0x29, 0x90, 0x7b, 0x14, 0xfc, 0x2b, 0xcf, 0x52, 0xc5, 0xcb, 0xe2, 0x72, 0xb9,
+	0xc0, 0xb0, 0x17, 0xb9, 0xc9, 0xfe, 0xc9, 0xcb, 0x71, 0x53, 0x65, 0x6c, 0x2b, 0x8c, 0xaf, 0x1e,
+	0x48, 0xd9, 0x46, 0x30, 0x8d, 0x21, 0xe8, 0x72, 0x61, 0xdf, 0x54, 0x5c, 0xe3, 0xe8, 0x04, 0x82,
+	0xfb, 0x34, 0xe7, 0x33, 0xae, 0x96, 0xa6, 0xe0, 0x6e, 0xe4, 0x24, 0xfb, 0x27, 0xcf, 0x1f, 0x2b,
+	0xd8, 0x91, 0xb1, 0xb5, 0x20, 0x3a, 0x02, 0xaf, 0xc5, 0xe1, 0x20, 0x72, 0x92, 0x80, 0x59, 0xac,
+	0xcf, 0x24, 0x7e, 0xaf, 0xb0, 0xc8, 0x30, 0x1c, 0x46, 0x4e, 0xd2, 0x67, 0x16, 0xd3, 0x57, 0x40,
+	0x6c, 0xfb, 0x57, 0x28, 0xa4, 0x36, 0xe9, 0x45, 0x4e, 0xb2, 0xc7, 0x36, 0x78, 0x4a, 0xa0, 0xa7,
+	0x54, 0x1e, 0xfa, 0x26, 0x85, 0xfe, 0xa4, 0x6f, 0xc0, 0x37, 0x63, 0x35, 0xbe, 0xc1, 0xf8, 0x3e,
+	0x7a, 0xdc, 0x77, 0xad, 0x61, 0x2b, 0x79, 0x7c, 0x09, 0x41, 0xb7, 0x1f, 0x3a, 0x84, 0xde, 0xd9,
+	0xf4, 0x82, 0xec, 0xd0, 0x3d, 0xf0, 0x2f, 0xf9, 0x1d, 0xb2, 0xb4, 0xb8, 0x41, 0xe2, 0x68, 0x38,
+	0x29, 0xef, 0x16, 0xa5, 0xe4, 0x0a, 0x89, 0xab, 0x21, 0xc3, 0xac, 0x12, 0x82, 0x17, 0x37, 0xa4,
+	0x47, 0xf7, 0x01, 0x3e, 0x55, 0xb9, 0xe2, 0xb5, 0xba, 0x1f, 0xbf, 0x06, 0xf2, 0xf0, 0xb7, 0xe8,
+	0xcc, 0x1f, 0x71, 0x49, 0x76, 0xa8, 0x07, 0xfd, 0x09, 0x0a, 0x45, 0x1c, 0x1a, 0x80, 0x67, 0xc2,
+	0x34, 0xef, 0xc6, 0xc7, 0xe0, 0x5b, 0x8b, 0x5a, 0xf4, 0x39, 0x55, 0xf3, 0xc6, 0x48, 0x79, 0x77,
+	0x2d, 0x55, 0x59, 0x20, 0x71, 0xe2, 0x5f, 0x2e, 0x1c, 0x58, 0x27, 0xad, 0x75, 0xfa, 0x0e, 0xbc,
+	0x72, 0x81, 0x22, 0x55, 0xa5, 0x08, 0x9d, 0x07, 0x57, 0x65, 0x43, 0x3d, 0x9e, 0x36, 0x52, 0x66,
+	0x83, 0xe8, 0x5b, 0x18, 0x66, 0x79, 0x5a, 0x49, 0x94, 0xa1, 0x1b, 0xf5, 0x92, 0xff, 0x4e, 0x5e,
+	0x6c, 0x89, 0x9f, 0x18, 0x25, 0x6b, 0x23, 0x46, 0x1c, 0x06, 0x35, 0xb5, 0x71, 0x8b, 0x6a, 0x2f,
+	0xff, 0x70, 0x8b, 0xea, 0xe5, 0xb0, 0x38, 0x7e, 0x0a, 0x5e, 0xeb, 0x5e, 0x4f, 0xf4, 0xb4, 0x98,
+	0x91, 0x1d, 0x3a, 0x00, 0x77, 0x2a, 0x88, 0x13, 0x33, 0x38, 0x6c, 0xe7, 0xd9, 0x1d, 0xbf, 0x5e,
+	0x37, 0x35, 0x17, 0x28, 0xe7, 0x65, 0x3e, 0x33, 0x96, 0xf6, 0xd8, 0x8a, 0xd0, 0xa7, 0xb7, 0xb8,
+	0xfc, 0x90, 0xca, 0x79, 0xd3, 0xbc, 0xcf, 0x56, 0x44, 0xfc, 0xdb, 0x81, 0x83, 0x36, 0xe9, 0x17,
+	0xbb, 0xa2, 0xef, 0x01, 0xec, 0xc5, 0x94, 0xa1, 0x63, 0x26, 0x76, 0x6c, 0xbb, 0xdc, 0xd0, 0x8f,
+	0xed, 0x17, 0xeb, 0xc4, 0x8d, 0xce, 0xc0, 0x5f, 0xa5, 0x1c, 0x81, 0x77, 0x8b, 0xcb, 0xf3, 0x62,
+	0x86, 0x3f, 0x1b, 0x8f, 0x16, 0x6f, 0x7f, 0x2f, 0xe2, 0x0c, 0xfc, 0xd3, 0x3c, 0x2f, 0x7f, 0x5c,
+	0x70, 0xa9, 0xd6, 0xd6, 0x4c, 0xa7, 0xe9, 0xae, 0xd9, 0x33, 0x80, 0x0c, 0x85, 0x5a, 0x6b, 0xb5,
+	0xc3, 0x98, 0xd8, 0xea, 0xfa, 0x1b, 0x66, 0x4a, 0x86, 0x3d, 0x73, 0x6a, 0x71, 0x8c, 0xf0, 0xbf,
+	0xf6, 0x8a, 0xb3, 0x55, 0xa9, 0x23, 0xf0, 0xd3, 0x16, 0x34, 0xef, 0x9b, 0x9f, 0x76, 0x4f, 0xb7,
+	0xbc, 0x71, 0x4f, 0x60, 0xc0, 0xa5, 0xac, 0x50, 0x98, 0x97, 0x2d, 0x60, 0x0d, 0xfa, 0x3b, 0x00,
+	0x29, 0x98, 0xce, 0x2e, 0x53, 0x05, 0x00, 0x00,
 }
@@ -2,38 +2,138 @@ package iprs_publisher
 
 import (
 	"context"
+	"errors"
+	"fmt"
 
 	rsp "github.com/dirkmc/go-iprs/path"
+	pb "github.com/dirkmc/go-iprs/pb"
 	r "github.com/dirkmc/go-iprs/record"
 	logging "github.com/ipfs/go-log"
 )
 
 var log = logging.Logger("iprs_publisher")
 
+// ErrStaleSequence is returned when an attempt is made to publish an
+// entry whose sequence number is not higher than that of the
+// previously published entry
+var ErrStaleSequence = errors.New("entry sequence number not higher than previous sequence number")
+
+// SeqConflictError is returned by PublishIfSeq when the sequence number
+// of the current entry is not the expected sequence number, ie someone
+// else has published to the key since the caller last read it
+type SeqConflictError struct {
+	IprsKey  rsp.IprsPath
+	Expected uint64
+	Actual   uint64
+}
+
+func (e *SeqConflictError) Error() string {
+	return fmt.Sprintf("sequence number conflict for %s: expected %d but current is %d", e.IprsKey, e.Expected, e.Actual)
+}
+
 type iprsPublisher struct {
-	seqm *SeqManager
+	seqm    *SeqManager
+	factory *r.RecordFactory
 }
 
 // NewDHTPublisher constructs a publisher for the IPFS Routing name system.
-func NewDHTPublisher(s *SeqManager) *iprsPublisher {
-	return &iprsPublisher{ s }
+// The factory is used to check pre-signed entries before they are
+// published.
+func NewDHTPublisher(s *SeqManager, f *r.RecordFactory) *iprsPublisher {
+	return &iprsPublisher{s, f}
 }
 
 // Publish implements Publisher. Accepts an IPRS path and a record,
 // and publishes it out to the routing system
 func (p *iprsPublisher) Publish(ctx context.Context, iprsKey rsp.IprsPath, record *r.Record) error {
 	log.Debugf("Publish %s", iprsKey)
+	return p.publish(ctx, iprsKey, record, nil)
+}
 
-	// get previous records sequence number
-	seqnum, err := p.seqm.GetPreviousSeqNo(ctx, iprsKey)
+// PublishIfSeq implements Publisher. Like Publish, but only publishes the
+// record if the sequence number of the current entry is expectedSeq,
+// otherwise it returns a *SeqConflictError
+func (p *iprsPublisher) PublishIfSeq(ctx context.Context, iprsKey rsp.IprsPath, record *r.Record, expectedSeq uint64) error {
+	log.Debugf("PublishIfSeq %s (expected seq no %d)", iprsKey, expectedSeq)
+	return p.publish(ctx, iprsKey, record, &expectedSeq)
+}
+
+func (p *iprsPublisher) publish(ctx context.Context, iprsKey rsp.IprsPath, record *r.Record, expectedSeq *uint64) error {
+	// Make sure concurrent publishes to the same key don't pick the
+	// same sequence number
+	unlock := p.seqm.Lock(iprsKey)
+	defer unlock()
+
+	// get the next sequence number, after any reserved for scheduled
+	// entries
+	seqnum, err := p.seqm.NextSeqNo(ctx, iprsKey)
 	if err != nil {
 		return err
 	}
 
-	// increment it
-	seqnum++
+	// Another publisher may have published to the key, so compare
+	// against the current entry in routing rather than local state
+	if expectedSeq != nil {
+		current, err := p.seqm.GetNetworkSeqNo(ctx, iprsKey)
+		if err != nil {
+			return err
+		}
+		if current != *expectedSeq {
+			return &SeqConflictError{IprsKey: iprsKey, Expected: *expectedSeq, Actual: current}
+		}
+		if current >= seqnum {
+			seqnum = current + 1
+		}
+	}
 
 	log.Debugf("Putting record with new seq no %d for %s", seqnum, iprsKey)
 
-	return record.Publish(ctx, iprsKey, seqnum)
+	err = record.Publish(ctx, iprsKey, seqnum)
+	if err != nil {
+		return err
+	}
+	return p.seqm.SetSeqNo(iprsKey, seqnum)
+}
+
+// DryRun implements Publisher. Signs an entry for the record with the
+// next sequence number and checks it, without publishing anything
+func (p *iprsPublisher) DryRun(ctx context.Context, iprsKey rsp.IprsPath, record *r.Record) (*pb.IprsEntry, error) {
+	log.Debugf("DryRun %s", iprsKey)
+
+	unlock := p.seqm.Lock(iprsKey)
+	defer unlock()
+
+	seqnum, err := p.seqm.NextSeqNo(ctx, iprsKey)
+	if err != nil {
+		return nil, err
+	}
+	return record.DryRun(ctx, iprsKey, seqnum)
+}
+
+// PublishEntry implements Publisher. Accepts an IPRS path, a record
+// and an entry for the record that was signed elsewhere (eg offline),
+// and publishes the entry out to the routing system
+func (p *iprsPublisher) PublishEntry(ctx context.Context, iprsKey rsp.IprsPath, record *r.Record, entry *pb.IprsEntry) error {
+	log.Debugf("PublishEntry %s", iprsKey)
+
+	unlock := p.seqm.Lock(iprsKey)
+	defer unlock()
+
+	// The entry was signed with a fixed sequence number, so make sure
+	// it will replace the previous entry
+	seqnum, err := p.seqm.GetPreviousSeqNo(ctx, iprsKey)
+	if err != nil {
+		return err
+	}
+	if entry.GetSequence() <= seqnum {
+		return ErrStaleSequence
+	}
+
+	log.Debugf("Putting signed entry with seq no %d for %s", entry.GetSequence(), iprsKey)
+
+	err = record.PublishEntry(ctx, iprsKey, entry, p.factory)
+	if err != nil {
+		return err
+	}
+	return p.seqm.SetSeqNo(iprsKey, entry.GetSequence())
 }
@@ -0,0 +1,141 @@
+package iprs_publisher
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	rec "github.com/dirkmc/go-iprs/record"
+	vs "github.com/dirkmc/go-iprs/vs"
+	ds "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore"
+	dssync "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore/sync"
+	testutil "gx/ipfs/QmeDA8gNhvRTsbrjEieay5wezupJDiky8xvCzDABbsGzmp/go-testutil"
+)
+
+func TestPublishIfSeq(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	id := testutil.RandIdentityOrFatal(t)
+	r := vs.NewMockValueStore(context.Background(), id, dstore)
+	kvs := vs.NewKadValueStore(dstore, r)
+	seqm := NewSeqManager(kvs)
+	p := NewDHTPublisher(seqm, rec.NewRecordFactory(r, nil))
+
+	iprsKey, eolRecord := getEolRecord(t, time.Now().Add(time.Hour), r)
+
+	// Nothing published yet, so the current sequence number is 0
+	err := p.PublishIfSeq(ctx, iprsKey, eolRecord, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another writer publishes in the meantime
+	err = p.Publish(ctx, iprsKey, eolRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Expected sequence number is out of date FAIL
+	err = p.PublishIfSeq(ctx, iprsKey, eolRecord, 1)
+	conflict, ok := err.(*SeqConflictError)
+	if !ok {
+		t.Fatal("Expected sequence number conflict error")
+	}
+	if conflict.Expected != 1 || conflict.Actual != 2 {
+		t.Fatalf("Unexpected conflict %s", conflict)
+	}
+
+	// Current sequence number SUCCESS
+	err = p.PublishIfSeq(ctx, iprsKey, eolRecord, conflict.Actual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seq, err := seqm.GetPreviousSeqNo(ctx, iprsKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq != 3 {
+		t.Fatalf("Unexpected sequence number %d", seq)
+	}
+}
+
+func TestPublishIfSeqSharedValueStore(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	id := testutil.RandIdentityOrFatal(t)
+	r := vs.NewMockValueStore(context.Background(), id, dstore)
+	kvs := vs.NewKadValueStore(dstore, r)
+	f := rec.NewRecordFactory(r, nil)
+
+	// Two publishers that keep their own sequence numbers but publish
+	// to the same value store
+	p1 := NewDHTPublisher(NewPersistentSeqManager(kvs, dssync.MutexWrap(ds.NewMapDatastore())), f)
+	p2 := NewDHTPublisher(NewPersistentSeqManager(kvs, dssync.MutexWrap(ds.NewMapDatastore())), f)
+
+	iprsKey, eolRecord := getEolRecord(t, time.Now().Add(time.Hour), r)
+
+	err := p1.Publish(ctx, iprsKey, eolRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p2.PublishIfSeq(ctx, iprsKey, eolRecord, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first publisher's local sequence number is out of date, but
+	// the current entry's sequence number is checked FAIL
+	err = p1.PublishIfSeq(ctx, iprsKey, eolRecord, 1)
+	conflict, ok := err.(*SeqConflictError)
+	if !ok {
+		t.Fatal("Expected sequence number conflict error")
+	}
+	if conflict.Expected != 1 || conflict.Actual != 2 {
+		t.Fatalf("Unexpected conflict %s", conflict)
+	}
+
+	// Current sequence number SUCCESS
+	err = p1.PublishIfSeq(ctx, iprsKey, eolRecord, conflict.Actual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seq, err := p2.seqm.GetNetworkSeqNo(ctx, iprsKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq != 3 {
+		t.Fatalf("Unexpected sequence number %d", seq)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	id := testutil.RandIdentityOrFatal(t)
+	r := vs.NewMockValueStore(context.Background(), id, dstore)
+	kvs := vs.NewKadValueStore(dstore, r)
+	seqm := NewSeqManager(kvs)
+	p := NewDHTPublisher(seqm, rec.NewRecordFactory(r, nil))
+
+	iprsKey, eolRecord := getEolRecord(t, time.Now().Add(time.Hour), r)
+	err := p.Publish(ctx, iprsKey, eolRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Entry is signed with the next sequence number but not published
+	entry, err := p.DryRun(ctx, iprsKey, eolRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.GetSequence() != 2 {
+		t.Fatalf("Unexpected sequence number %d", entry.GetSequence())
+	}
+	seq, err := seqm.GetPreviousSeqNo(ctx, iprsKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq != 1 {
+		t.Fatal("Expected dry run not to publish entry")
+	}
+}
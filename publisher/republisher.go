@@ -0,0 +1,339 @@
+package iprs_publisher
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	clock "github.com/dirkmc/go-iprs/clock"
+	rsp "github.com/dirkmc/go-iprs/path"
+	pb "github.com/dirkmc/go-iprs/pb"
+	r "github.com/dirkmc/go-iprs/record"
+	path "github.com/ipfs/go-ipfs/path"
+	goprocess "gx/ipfs/QmSF8fPo3jgVBAy8fpdjjYqgG87dkJgUprRBHRd2tmfgpP/goprocess"
+)
+
+// DefaultRepublishInterval is how often records are put to routing again,
+// so that they don't fall out of peers' stores
+var DefaultRepublishInterval = time.Hour * 4
+
+// DefaultRenewBefore is how long before a record expires it is renewed
+var DefaultRenewBefore = time.Hour * 12
+
+// Records that need renewing are not renewed more often than this, so
+// that a record that fails to renew is not retried in a tight loop
+var minRenewDelay = time.Minute
+
+// ErrNotRepublished is returned when removing a key that the republisher
+// does not have a record for
+var ErrNotRepublished = errors.New("key is not being republished")
+
+// ErrLifetimeTooShort is returned when adding a record whose validity
+// policy creates records that expire within RenewBefore, as the record
+// would need renewing again as soon as it was renewed
+var ErrLifetimeTooShort = errors.New("record lifetime must be longer than RenewBefore")
+
+// ValidityPolicy returns the validity of a record that is renewed at the
+// given time
+type ValidityPolicy func(now time.Time) (r.RecordValidity, error)
+
+// EolValidityPolicy renews records with an EOL of lifetime after the
+// time they are renewed
+func EolValidityPolicy(lifetime time.Duration) ValidityPolicy {
+	return func(now time.Time) (r.RecordValidity, error) {
+		return r.NewEolRecordValidity(now.Add(lifetime)), nil
+	}
+}
+
+// RangeValidityPolicy renews records with a time range that starts when
+// they are renewed and ends lifetime later
+func RangeValidityPolicy(lifetime time.Duration) ValidityPolicy {
+	return func(now time.Time) (r.RecordValidity, error) {
+		end := now.Add(lifetime)
+		return r.NewRangeRecordValidity(&now, &end)
+	}
+}
+
+// Republisher keeps the records that we own alive. Periodically it puts
+// each record to routing again, and when a record is about to expire it
+// publishes a new entry with the next sequence number and a validity
+// extended according to the record's ValidityPolicy.
+type Republisher struct {
+	seqm    *SeqManager
+	factory *r.RecordFactory
+	clock   clock.Clock
+
+	// How often records are put to routing again
+	Interval time.Duration
+	// How long before a record expires it is renewed
+	RenewBefore time.Duration
+
+	lk      sync.Mutex
+	records map[string]*ownedRecord
+	wake    chan struct{}
+}
+
+type ownedRecord struct {
+	iprsKey rsp.IprsPath
+	s       r.RecordSigner
+	val     path.Path
+	policy  ValidityPolicy
+	record  *r.Record
+	entry   *pb.IprsEntry
+}
+
+// NewRepublisher creates a republisher that publishes records created
+// with the factory. If clk is nil the system clock is used.
+func NewRepublisher(s *SeqManager, f *r.RecordFactory, clk clock.Clock) *Republisher {
+	return &Republisher{
+		seqm:        s,
+		factory:     f,
+		clock:       clock.OrRealClock(clk),
+		Interval:    DefaultRepublishInterval,
+		RenewBefore: DefaultRenewBefore,
+		records:     make(map[string]*ownedRecord),
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// Add publishes a record with the value at the IPRS key, and keeps it
+// alive until it is removed. It returns ErrLifetimeTooShort if the
+// records created by the policy expire within RenewBefore.
+func (rp *Republisher) Add(ctx context.Context, iprsKey rsp.IprsPath, s r.RecordSigner, val path.Path, policy ValidityPolicy) error {
+	o := &ownedRecord{
+		iprsKey: iprsKey,
+		s:       s,
+		val:     val,
+		policy:  policy,
+	}
+	err := rp.checkPolicy(o)
+	if err != nil {
+		return err
+	}
+	err = rp.renew(ctx, o)
+	if err != nil {
+		return err
+	}
+
+	rp.lk.Lock()
+	defer rp.lk.Unlock()
+	rp.records[iprsKey.String()] = o
+	rp.notify()
+	return nil
+}
+
+// checkPolicy checks that a record created by the policy now would not
+// need renewing straight away
+func (rp *Republisher) checkPolicy(o *ownedRecord) error {
+	now := rp.clock.Now()
+	vl, err := o.policy(now)
+	if err != nil {
+		return err
+	}
+	entry, err := rp.factory.NewRecord(vl, o.s, o.val).UnsignedEntry(0)
+	if err != nil {
+		return err
+	}
+	bounds, err := r.CompositeTimeBounds(entry)
+	if err != nil {
+		return err
+	}
+	if bounds[1] != nil && !now.Add(rp.RenewBefore).Before(*bounds[1]) {
+		return ErrLifetimeTooShort
+	}
+	return nil
+}
+
+// Remove stops republishing the record at the IPRS key. The record
+// remains published until it expires.
+func (rp *Republisher) Remove(iprsKey rsp.IprsPath) error {
+	rp.lk.Lock()
+	defer rp.lk.Unlock()
+
+	k := iprsKey.String()
+	if _, ok := rp.records[k]; !ok {
+		return ErrNotRepublished
+	}
+	delete(rp.records, k)
+	rp.notify()
+	return nil
+}
+
+// Keys returns the IPRS keys of the records that are being republished
+func (rp *Republisher) Keys() []rsp.IprsPath {
+	rp.lk.Lock()
+	defer rp.lk.Unlock()
+
+	var keys []rsp.IprsPath
+	for _, o := range rp.records {
+		keys = append(keys, o.iprsKey)
+	}
+	return keys
+}
+
+// Run republishes records until the process is closed
+func (rp *Republisher) Run(proc goprocess.Process) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-proc.Closing()
+		cancel()
+	}()
+
+	for {
+		select {
+		case <-rp.clock.After(rp.nextRepublish()):
+			err := rp.Republish(ctx)
+			if err != nil {
+				log.Warningf("Failed to republish records: %s", err)
+			}
+		case <-rp.wake:
+			// The records changed, so work out again when the next one
+			// needs republishing
+		case <-proc.Closing():
+			return
+		}
+	}
+}
+
+// Start runs the republisher in the background until the context is
+// cancelled or the returned process is closed
+func (rp *Republisher) Start(ctx context.Context) goprocess.Process {
+	proc := goprocess.Go(rp.Run)
+	go func() {
+		select {
+		case <-ctx.Done():
+			proc.Close()
+		case <-proc.Closing():
+		}
+	}()
+	return proc
+}
+
+// Republish renews the records that are about to expire, and puts the
+// other records to routing again. It returns the first error
+// encountered, after trying every record.
+func (rp *Republisher) Republish(ctx context.Context) error {
+	rp.lk.Lock()
+	var owned []*ownedRecord
+	for _, o := range rp.records {
+		owned = append(owned, o)
+	}
+	rp.lk.Unlock()
+
+	var firstErr error
+	for _, o := range owned {
+		var err error
+		if rp.needsRenewal(o) {
+			log.Debugf("Renewing record at %s", o.iprsKey)
+			err = rp.renew(ctx, o)
+		} else {
+			log.Debugf("Republishing record at %s", o.iprsKey)
+			rp.lk.Lock()
+			record, entry := o.record, o.entry
+			rp.lk.Unlock()
+			err = record.PublishEntry(ctx, o.iprsKey, entry, rp.factory)
+		}
+		if err != nil {
+			log.Warningf("Failed to republish record at %s: %s", o.iprsKey, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// renew publishes a new entry for the record with the next sequence
+// number and a new validity. The sequence number is only stored once
+// the entry has been published, so a failed renewal doesn't use it up.
+func (rp *Republisher) renew(ctx context.Context, o *ownedRecord) error {
+	vl, err := o.policy(rp.clock.Now())
+	if err != nil {
+		return err
+	}
+	record := rp.factory.NewRecord(vl, o.s, o.val)
+
+	unlock := rp.seqm.Lock(o.iprsKey)
+	defer unlock()
+
+	seq, err := rp.seqm.NextSeqNo(ctx, o.iprsKey)
+	if err != nil {
+		return err
+	}
+	entry, err := record.Entry(o.iprsKey, seq)
+	if err != nil {
+		return err
+	}
+	err = record.PublishEntry(ctx, o.iprsKey, entry, rp.factory)
+	if err != nil {
+		return err
+	}
+	err = rp.seqm.SetSeqNo(o.iprsKey, seq)
+	if err != nil {
+		return err
+	}
+
+	rp.lk.Lock()
+	defer rp.lk.Unlock()
+	o.record = record
+	o.entry = entry
+	return nil
+}
+
+func (rp *Republisher) needsRenewal(o *ownedRecord) bool {
+	end, ok := rp.expiry(o)
+	return ok && !rp.clock.Now().Add(rp.RenewBefore).Before(end)
+}
+
+// expiry returns the latest time at which the record's entry could be
+// valid, or false if it never expires
+func (rp *Republisher) expiry(o *ownedRecord) (time.Time, bool) {
+	rp.lk.Lock()
+	entry := o.entry
+	rp.lk.Unlock()
+
+	bounds, err := r.CompositeTimeBounds(entry)
+	if err != nil || bounds[1] == nil {
+		return time.Time{}, false
+	}
+	return *bounds[1], true
+}
+
+// nextRepublish returns how long to wait until the next time records
+// should be republished, ie the interval, or sooner if a record needs
+// to be renewed before then
+func (rp *Republisher) nextRepublish() time.Duration {
+	rp.lk.Lock()
+	var owned []*ownedRecord
+	for _, o := range rp.records {
+		owned = append(owned, o)
+	}
+	rp.lk.Unlock()
+
+	now := rp.clock.Now()
+	next := rp.Interval
+	for _, o := range owned {
+		end, ok := rp.expiry(o)
+		if !ok {
+			continue
+		}
+		d := end.Add(-rp.RenewBefore).Sub(now)
+		if d < minRenewDelay {
+			d = minRenewDelay
+		}
+		if d < next {
+			next = d
+		}
+	}
+	return next
+}
+
+// notify wakes the Run loop so that it picks up changes to the records
+func (rp *Republisher) notify() {
+	select {
+	case rp.wake <- struct{}{}:
+	default:
+	}
+}
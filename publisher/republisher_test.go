@@ -0,0 +1,377 @@
+package iprs_publisher
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	clock "github.com/dirkmc/go-iprs/clock"
+	pb "github.com/dirkmc/go-iprs/pb"
+	rec "github.com/dirkmc/go-iprs/record"
+	vs "github.com/dirkmc/go-iprs/vs"
+	path "github.com/ipfs/go-ipfs/path"
+	proto "gx/ipfs/QmZ4Qi3GaRbjcx28Sme5eMH7RQjGkt8wHxt2a65oLaeFEV/gogo-protobuf/proto"
+	ds "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore"
+	dssync "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore/sync"
+	testutil "gx/ipfs/QmeDA8gNhvRTsbrjEieay5wezupJDiky8xvCzDABbsGzmp/go-testutil"
+)
+
+func TestRepublisher(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	id := testutil.RandIdentityOrFatal(t)
+	r := vs.NewMockValueStore(context.Background(), id, dstore)
+	kvs := vs.NewKadValueStore(dstore, r)
+	now := time.Now()
+	clk := clock.NewMockClock(now)
+	f := rec.NewRecordFactory(r, clk)
+	rp := NewRepublisher(NewSeqManager(kvs), f, clk)
+	rp.RenewBefore = time.Minute * 10
+
+	pk, _, err := testutil.RandTestKeyPair(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := f.NewKeyRecordSigner(pk)
+	iprsKey, err := s.BasePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := path.FromString("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+
+	getEntry := func() *pb.IprsEntry {
+		b, err := r.GetValue(ctx, iprsKey.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := new(pb.IprsEntry)
+		err = proto.Unmarshal(b, e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+	checkEntry := func(seq uint64, eol time.Time) {
+		e := getEntry()
+		if e.GetSequence() != seq {
+			t.Fatalf("Expected sequence number %d, got %d", seq, e.GetSequence())
+		}
+		entryEol, err := rec.EolParseValidity(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !entryEol.Equal(eol) {
+			t.Fatalf("Expected EOL %s, got %s", eol, entryEol)
+		}
+	}
+
+	// Adding the record publishes it
+	err = rp.Add(ctx, iprsKey, s, p, EolValidityPolicy(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEntry(1, now.Add(time.Hour))
+	if len(rp.Keys()) != 1 || rp.Keys()[0] != iprsKey {
+		t.Fatal("Expected key to be republished")
+	}
+
+	// Record that is not about to expire is put again as it is
+	clk.Add(time.Minute * 30)
+	err = r.DeleteValue(iprsKey.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rp.Republish(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEntry(1, now.Add(time.Hour))
+
+	// Record that is about to expire is renewed with the next sequence
+	// number and an extended validity
+	clk.Add(time.Minute * 25)
+	err = rp.Republish(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEntry(2, now.Add(time.Minute*55+time.Hour))
+
+	// The republisher waits until the next record needs renewing
+	next := rp.nextRepublish()
+	if next != time.Minute*50 {
+		t.Fatalf("Unexpected time until next republish %s", next)
+	}
+
+	// Removed record is no longer republished
+	err = rp.Remove(iprsKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rp.Remove(iprsKey)
+	if err != ErrNotRepublished {
+		t.Fatal("Expected not republished error")
+	}
+	clk.Add(time.Hour)
+	err = rp.Republish(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEntry(2, now.Add(time.Minute*55+time.Hour))
+
+	// Republisher stops when its context is cancelled
+	runctx, cancel := context.WithCancel(ctx)
+	proc := rp.Start(runctx)
+	cancel()
+	select {
+	case <-proc.Closed():
+	case <-time.After(time.Second * 5):
+		t.Fatal("Expected republisher to stop")
+	}
+}
+
+func TestRepublisherRenewsExpiredRange(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	id := testutil.RandIdentityOrFatal(t)
+	r := vs.NewMockValueStore(context.Background(), id, dstore)
+	kvs := vs.NewKadValueStore(dstore, r)
+	now := time.Now()
+	clk := clock.NewMockClock(now)
+	f := rec.NewRecordFactory(r, clk)
+	seqm := NewSeqManager(kvs)
+	rp := NewRepublisher(seqm, f, clk)
+
+	pk, _, err := testutil.RandTestKeyPair(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := f.NewKeyRecordSigner(pk)
+	iprsKey, err := s.BasePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := path.FromString("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+
+	err = rp.Add(ctx, iprsKey, s, p, RangeValidityPolicy(time.Hour*24))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Within DefaultRenewBefore of the end of the range
+	clk.Add(time.Hour * 13)
+	err = rp.Republish(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seq, err := seqm.GetPreviousSeqNo(ctx, iprsKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq != 2 {
+		t.Fatalf("Expected record to be renewed, got sequence number %d", seq)
+	}
+}
+
+func TestRepublisherRun(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	id := testutil.RandIdentityOrFatal(t)
+	r := vs.NewMockValueStore(context.Background(), id, dstore)
+	kvs := vs.NewKadValueStore(dstore, r)
+	clk := clock.NewMockClock(time.Now())
+	f := rec.NewRecordFactory(r, clk)
+	seqm := NewSeqManager(kvs)
+	rp := NewRepublisher(seqm, f, clk)
+
+	pk, _, err := testutil.RandTestKeyPair(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := f.NewKeyRecordSigner(pk)
+	iprsKey, err := s.BasePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := path.FromString("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+
+	err = rp.Add(ctx, iprsKey, s, p, EolValidityPolicy(time.Hour*24))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	rp.Start(runctx)
+
+	// Advancing the clock past the time the record needs renewing wakes
+	// the republisher
+	for i := 0; ; i++ {
+		seq, err := seqm.GetPreviousSeqNo(ctx, iprsKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seq == 2 {
+			break
+		}
+		if i == 500 {
+			t.Fatal("Expected record to be renewed")
+		}
+		clk.Add(time.Hour)
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestRepublisherFailedRenewal(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	id := testutil.RandIdentityOrFatal(t)
+	r := vs.NewMockValueStore(context.Background(), id, dstore)
+	kvs := vs.NewKadValueStore(dstore, r)
+	now := time.Now()
+	clk := clock.NewMockClock(now)
+	f := rec.NewRecordFactory(r, clk)
+	seqm := NewPersistentSeqManager(kvs, dssync.MutexWrap(ds.NewMapDatastore()))
+	rp := NewRepublisher(seqm, f, clk)
+	rp.RenewBefore = time.Minute * 10
+
+	pk, _, err := testutil.RandTestKeyPair(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := f.NewKeyRecordSigner(pk)
+	iprsKey, err := s.BasePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := path.FromString("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+
+	// The policy creates records that have already expired once the
+	// record has been added, so renewing fails
+	expired := false
+	policy := func(now time.Time) (rec.RecordValidity, error) {
+		if expired {
+			return rec.NewEolRecordValidity(now.Add(-time.Hour)), nil
+		}
+		return rec.NewEolRecordValidity(now.Add(time.Hour)), nil
+	}
+	err = rp.Add(ctx, iprsKey, s, p, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired = true
+	clk.Add(time.Minute * 55)
+	err = rp.Republish(ctx)
+	if err == nil {
+		t.Fatal("Expected renewal to fail")
+	}
+
+	// The failed renewal did not use up a sequence number
+	seq, err := seqm.GetPreviousSeqNo(ctx, iprsKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq != 1 {
+		t.Fatalf("Expected sequence number 1, got %d", seq)
+	}
+}
+
+func TestRepublisherShortLifetime(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	id := testutil.RandIdentityOrFatal(t)
+	r := vs.NewMockValueStore(context.Background(), id, dstore)
+	kvs := vs.NewKadValueStore(dstore, r)
+	clk := clock.NewMockClock(time.Now())
+	f := rec.NewRecordFactory(r, clk)
+	seqm := NewSeqManager(kvs)
+	rp := NewRepublisher(seqm, f, clk)
+
+	pk, _, err := testutil.RandTestKeyPair(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := f.NewKeyRecordSigner(pk)
+	iprsKey, err := s.BasePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := path.FromString("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+
+	// Record that would need renewing as soon as it was renewed FAIL
+	err = rp.Add(ctx, iprsKey, s, p, EolValidityPolicy(rp.RenewBefore))
+	if err != ErrLifetimeTooShort {
+		t.Fatal("Expected lifetime too short error")
+	}
+	err = rp.Add(ctx, iprsKey, s, p, RangeValidityPolicy(time.Hour))
+	if err != ErrLifetimeTooShort {
+		t.Fatal("Expected lifetime too short error")
+	}
+	if len(rp.Keys()) != 0 {
+		t.Fatal("Expected record not to be added")
+	}
+	seq, err := seqm.GetPreviousSeqNo(ctx, iprsKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq != 0 {
+		t.Fatal("Expected record not to be published")
+	}
+
+	// Lifetime longer than RenewBefore
+	err = rp.Add(ctx, iprsKey, s, p, EolValidityPolicy(rp.RenewBefore+time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRepublisherRunAdd(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	id := testutil.RandIdentityOrFatal(t)
+	r := vs.NewMockValueStore(context.Background(), id, dstore)
+	kvs := vs.NewKadValueStore(dstore, r)
+	clk := clock.NewMockClock(time.Now())
+	f := rec.NewRecordFactory(r, clk)
+	seqm := NewSeqManager(kvs)
+	rp := NewRepublisher(seqm, f, clk)
+
+	runctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	rp.Start(runctx)
+	time.Sleep(time.Millisecond * 10)
+
+	pk, _, err := testutil.RandTestKeyPair(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := f.NewKeyRecordSigner(pk)
+	iprsKey, err := s.BasePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := path.FromString("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+
+	// Record added while running needs renewing an hour from now, which
+	// is before the republish interval
+	err = rp.Add(ctx, iprsKey, s, p, EolValidityPolicy(rp.RenewBefore+time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; ; i++ {
+		seq, err := seqm.GetPreviousSeqNo(ctx, iprsKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seq == 2 {
+			break
+		}
+		// Stop well before the republish interval
+		if i == 180 {
+			t.Fatal("Expected record added while running to be renewed")
+		}
+		clk.Add(time.Minute)
+		time.Sleep(time.Millisecond * 10)
+	}
+}
@@ -0,0 +1,442 @@
+package iprs_publisher
+
+import (
+	"bytes"
+	"context"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	clock "github.com/dirkmc/go-iprs/clock"
+	rsp "github.com/dirkmc/go-iprs/path"
+	pb "github.com/dirkmc/go-iprs/pb"
+	r "github.com/dirkmc/go-iprs/record"
+	u "github.com/ipfs/go-ipfs-util"
+	goprocess "gx/ipfs/QmSF8fPo3jgVBAy8fpdjjYqgG87dkJgUprRBHRd2tmfgpP/goprocess"
+	proto "gx/ipfs/QmZ4Qi3GaRbjcx28Sme5eMH7RQjGkt8wHxt2a65oLaeFEV/gogo-protobuf/proto"
+	ds "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore"
+	dsq "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore/query"
+)
+
+// Scheduled entries are stored in the local datastore under this prefix
+var scheduleKeyPrefix = ds.NewKey("/iprs/schedule")
+
+const scheduledEntryPemType = "IPRS SCHEDULED ENTRY"
+const scheduledEntryKeyHeader = "IPRS-Key"
+const scheduledEntryTimeHeader = "Publish-At"
+
+// If publishing a scheduled entry fails, it is retried after this long
+var ScheduleRetryDelay = time.Minute
+
+// ErrSeqManagerNotPersistent is returned when creating a scheduler with
+// a SeqManager that does not store sequence numbers in a datastore
+var ErrSeqManagerNotPersistent = errors.New("scheduler requires a SeqManager created with NewPersistentSeqManager")
+
+// ErrScheduledEntryNotFound is returned when cancelling a scheduled
+// entry that does not exist (or has already been published)
+var ErrScheduledEntryNotFound = errors.New("scheduled entry not found")
+
+// ScheduledEntry is a signed entry that will be published at the IPRS
+// key at a later time
+type ScheduledEntry struct {
+	ID        string
+	IprsKey   rsp.IprsPath
+	Entry     *pb.IprsEntry
+	PublishAt time.Time
+}
+
+// Scheduler publishes entries at a scheduled time, eg so that a name can
+// be switched over to a new value ahead of time. Entries are signed when
+// they are scheduled, and stored in the datastore until they are
+// published, so that they survive restarts.
+// Entries are signed with the next sequence number at the time they are
+// scheduled, and the sequence number is reserved so that later entries
+// get a higher one. If an entry with a higher sequence number is
+// published to the key before the scheduled entry is due, the scheduled
+// entry can no longer be published, so it is dropped (see PublishDue).
+// The SeqManager must be created with NewPersistentSeqManager so that
+// entries published in the meantime (or after a restart) don't reuse
+// the reserved sequence number.
+type Scheduler struct {
+	seqm    *SeqManager
+	factory *r.RecordFactory
+	dstore  ds.Datastore
+	clock   clock.Clock
+
+	// ScheduleAtStart publishes entries this long before their validity
+	// starts. Validators reject entries that are not yet valid, so it
+	// should be no longer than the validators' SkewTolerance.Pending.
+	Lead time.Duration
+
+	// If set, Run calls OnDrop for each scheduled entry that is dropped
+	// because it was superseded
+	OnDrop func(*ScheduledEntry)
+
+	lk   sync.Mutex
+	wake chan struct{}
+}
+
+// NewScheduler creates a scheduler that stores scheduled entries in the
+// datastore. It returns ErrSeqManagerNotPersistent if the SeqManager
+// has no datastore. If clk is nil the system clock is used.
+func NewScheduler(s *SeqManager, f *r.RecordFactory, dstore ds.Datastore, clk clock.Clock) (*Scheduler, error) {
+	if s.dstore == nil {
+		return nil, ErrSeqManagerNotPersistent
+	}
+	return &Scheduler{
+		seqm:    s,
+		factory: f,
+		dstore:  dstore,
+		clock:   clock.OrRealClock(clk),
+		wake:    make(chan struct{}, 1),
+	}, nil
+}
+
+// Schedule signs an entry for the record and publishes it at the given
+// time. The data required to verify the entry (eg public key) is
+// published immediately.
+func (s *Scheduler) Schedule(ctx context.Context, iprsKey rsp.IprsPath, record *r.Record, at time.Time) (*ScheduledEntry, error) {
+	unlock := s.seqm.Lock(iprsKey)
+	defer unlock()
+
+	seq, err := s.seqm.NextSeqNo(ctx, iprsKey)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := record.Entry(iprsKey, seq)
+	if err != nil {
+		return nil, err
+	}
+	err = record.PublishVerification(ctx, iprsKey, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	se, err := s.add(iprsKey, entry, at)
+	if err != nil {
+		return nil, err
+	}
+	// Reserve the sequence number for the scheduled entry
+	err = s.seqm.ReserveSeqNo(iprsKey, seq)
+	if err != nil {
+		return nil, err
+	}
+	return se, nil
+}
+
+// ScheduleAtStart is like Schedule, but publishes the entry Lead before
+// the record's validity starts (or immediately if it has already
+// started)
+func (s *Scheduler) ScheduleAtStart(ctx context.Context, iprsKey rsp.IprsPath, record *r.Record) (*ScheduledEntry, error) {
+	entry, err := record.UnsignedEntry(0)
+	if err != nil {
+		return nil, err
+	}
+	bounds, err := r.CompositeTimeBounds(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	at := s.clock.Now()
+	if bounds[0] != nil {
+		at = bounds[0].Add(-s.Lead)
+	}
+	return s.Schedule(ctx, iprsKey, record, at)
+}
+
+// ScheduleEntry publishes an entry that has already been signed (eg with
+// an offline key) at the given time. The data required to verify the
+// entry must already be on the network.
+func (s *Scheduler) ScheduleEntry(ctx context.Context, iprsKey rsp.IprsPath, entry *pb.IprsEntry, at time.Time) (*ScheduledEntry, error) {
+	unlock := s.seqm.Lock(iprsKey)
+	defer unlock()
+
+	seq, err := s.seqm.GetPreviousSeqNo(ctx, iprsKey)
+	if err != nil {
+		return nil, err
+	}
+	if entry.GetSequence() <= seq {
+		return nil, ErrStaleSequence
+	}
+	err = s.seqm.ReserveSeqNo(iprsKey, entry.GetSequence())
+	if err != nil {
+		return nil, err
+	}
+
+	return s.add(iprsKey, entry, at)
+}
+
+func (s *Scheduler) add(iprsKey rsp.IprsPath, entry *pb.IprsEntry, at time.Time) (*ScheduledEntry, error) {
+	se := &ScheduledEntry{IprsKey: iprsKey, Entry: entry, PublishAt: at}
+	data, err := marshalScheduledEntry(se)
+	if err != nil {
+		return nil, err
+	}
+	se.ID = u.Hash(data).B58String()
+
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	err = s.dstore.Put(scheduleKeyPrefix.ChildString(se.ID), data)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("Scheduled entry %s with seq no %d for %s at %s", se.ID, entry.GetSequence(), iprsKey, at)
+
+	s.notify()
+	return se, nil
+}
+
+// Cancel removes a scheduled entry so that it is not published
+func (s *Scheduler) Cancel(id string) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	k := scheduleKeyPrefix.ChildString(id)
+	has, err := s.dstore.Has(k)
+	if err != nil {
+		return err
+	}
+	if !has {
+		return ErrScheduledEntryNotFound
+	}
+	err = s.dstore.Delete(k)
+	if err != nil {
+		return err
+	}
+
+	s.notify()
+	return nil
+}
+
+// List returns the scheduled entries, ordered by the time they will be
+// published
+func (s *Scheduler) List() ([]*ScheduledEntry, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	return s.list()
+}
+
+func (s *Scheduler) list() ([]*ScheduledEntry, error) {
+	res, err := s.dstore.Query(dsq.Query{Prefix: scheduleKeyPrefix.String()})
+	if err != nil {
+		return nil, err
+	}
+	results, err := res.Rest()
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*ScheduledEntry
+	for _, e := range results {
+		data, ok := e.Value.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("Unexpected type returned from datastore: %#v", e.Value)
+		}
+		se, err := unmarshalScheduledEntry(data)
+		if err != nil {
+			log.Warningf("Could not parse scheduled entry at %s: %s", e.Key, err)
+			continue
+		}
+		se.ID = ds.RawKey(e.Key).BaseNamespace()
+		entries = append(entries, se)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].PublishAt.Before(entries[j].PublishAt)
+	})
+	return entries, nil
+}
+
+// PublishDue publishes the scheduled entries whose time has come. Entries
+// that fail to publish are retried later. Entries whose sequence number
+// has been superseded by an entry published in the meantime can never
+// be published, so they are removed from the schedule and returned, eg
+// so that the caller can schedule the record again. It returns the first
+// error encountered, after trying every entry.
+func (s *Scheduler) PublishDue(ctx context.Context) ([]*ScheduledEntry, error) {
+	entries, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.clock.Now()
+	var dropped []*ScheduledEntry
+	var firstErr error
+	for _, se := range entries {
+		if se.PublishAt.After(now) {
+			break
+		}
+
+		err := s.publish(ctx, se)
+		if err == ErrStaleSequence {
+			log.Warningf("Dropping scheduled entry %s for %s: %s", se.ID, se.IprsKey, err)
+			dropped = append(dropped, se)
+		} else if err != nil {
+			log.Warningf("Failed to publish scheduled entry %s for %s: %s", se.ID, se.IprsKey, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		err = s.Cancel(se.ID)
+		if err != nil && err != ErrScheduledEntryNotFound && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return dropped, firstErr
+}
+
+func (s *Scheduler) publish(ctx context.Context, se *ScheduledEntry) error {
+	unlock := s.seqm.Lock(se.IprsKey)
+	defer unlock()
+
+	// Another entry may have been published to the key since this entry
+	// was scheduled, by us or by another publisher
+	seq, err := s.seqm.GetPreviousSeqNo(ctx, se.IprsKey)
+	if err != nil {
+		return err
+	}
+	current, err := s.seqm.GetNetworkSeqNo(ctx, se.IprsKey)
+	if err != nil {
+		return err
+	}
+	if current > seq {
+		seq = current
+	}
+	if se.Entry.GetSequence() <= seq {
+		return ErrStaleSequence
+	}
+
+	log.Debugf("Publishing scheduled entry %s with seq no %d for %s", se.ID, se.Entry.GetSequence(), se.IprsKey)
+	err = s.factory.PublishEntry(ctx, se.IprsKey, se.Entry)
+	if err != nil {
+		return err
+	}
+	return s.seqm.SetSeqNo(se.IprsKey, se.Entry.GetSequence())
+}
+
+// Run publishes scheduled entries when they are due, until the process
+// is closed
+func (s *Scheduler) Run(proc goprocess.Process) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-proc.Closing()
+		cancel()
+	}()
+
+	var wait time.Duration
+	for {
+		select {
+		case <-s.clock.After(wait):
+			dropped, err := s.PublishDue(ctx)
+			if err != nil {
+				log.Warningf("Failed to publish scheduled entries: %s", err)
+			}
+			if s.OnDrop != nil {
+				for _, se := range dropped {
+					s.OnDrop(se)
+				}
+			}
+			wait = s.nextPublish()
+		case <-s.wake:
+			// The schedule changed, so publish anything that is due now
+			// and then wait for the next entry
+			wait = 0
+		case <-proc.Closing():
+			return
+		}
+	}
+}
+
+// Start runs the scheduler in the background until the context is
+// cancelled or the returned process is closed
+func (s *Scheduler) Start(ctx context.Context) goprocess.Process {
+	proc := goprocess.Go(s.Run)
+	go func() {
+		select {
+		case <-ctx.Done():
+			proc.Close()
+		case <-proc.Closing():
+		}
+	}()
+	return proc
+}
+
+// nextPublish returns how long to wait until the next scheduled entry
+// is due
+func (s *Scheduler) nextPublish() time.Duration {
+	entries, err := s.List()
+	if err != nil {
+		log.Warningf("Failed to list scheduled entries: %s", err)
+		return ScheduleRetryDelay
+	}
+	if len(entries) == 0 {
+		return time.Hour * 24
+	}
+
+	d := entries[0].PublishAt.Sub(s.clock.Now())
+	if d <= 0 {
+		// The entry is overdue, so publishing it failed
+		return ScheduleRetryDelay
+	}
+	return d
+}
+
+// notify wakes the Run loop so that it picks up changes to the schedule
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func marshalScheduledEntry(se *ScheduledEntry) ([]byte, error) {
+	data, err := proto.Marshal(se.Entry)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := new(bytes.Buffer)
+	err = pem.Encode(buf, &pem.Block{
+		Type: scheduledEntryPemType,
+		Headers: map[string]string{
+			scheduledEntryKeyHeader:  se.IprsKey.String(),
+			scheduledEntryTimeHeader: u.FormatRFC3339(se.PublishAt),
+		},
+		Bytes: data,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func unmarshalScheduledEntry(data []byte) (*ScheduledEntry, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != scheduledEntryPemType {
+		return nil, errors.New("Could not decode scheduled IPRS entry")
+	}
+
+	iprsKey, err := rsp.FromString(block.Headers[scheduledEntryKeyHeader])
+	if err != nil {
+		return nil, err
+	}
+	at, err := u.ParseRFC3339(block.Headers[scheduledEntryTimeHeader])
+	if err != nil {
+		return nil, err
+	}
+
+	entry := new(pb.IprsEntry)
+	err = proto.Unmarshal(block.Bytes, entry)
+	if err != nil {
+		return nil, fmt.Errorf("Could not unmarshal scheduled IPRS entry: %s", err)
+	}
+
+	return &ScheduledEntry{IprsKey: iprsKey, Entry: entry, PublishAt: at}, nil
+}
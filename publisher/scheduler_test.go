@@ -0,0 +1,291 @@
+package iprs_publisher
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	clock "github.com/dirkmc/go-iprs/clock"
+	pb "github.com/dirkmc/go-iprs/pb"
+	rec "github.com/dirkmc/go-iprs/record"
+	vs "github.com/dirkmc/go-iprs/vs"
+	path "github.com/ipfs/go-ipfs/path"
+	proto "gx/ipfs/QmZ4Qi3GaRbjcx28Sme5eMH7RQjGkt8wHxt2a65oLaeFEV/gogo-protobuf/proto"
+	ds "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore"
+	dssync "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore/sync"
+	testutil "gx/ipfs/QmeDA8gNhvRTsbrjEieay5wezupJDiky8xvCzDABbsGzmp/go-testutil"
+)
+
+func TestScheduler(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	id := testutil.RandIdentityOrFatal(t)
+	r := vs.NewMockValueStore(context.Background(), id, dstore)
+	kvs := vs.NewKadValueStore(dstore, r)
+	now := time.Now()
+	clk := clock.NewMockClock(now)
+	f := rec.NewRecordFactory(r, clk)
+	localstore := dssync.MutexWrap(ds.NewMapDatastore())
+
+	// SeqManager that doesn't store sequence numbers FAIL
+	_, err := NewScheduler(NewSeqManager(kvs), f, localstore, clk)
+	if err != ErrSeqManagerNotPersistent {
+		t.Fatal("Expected SeqManager not persistent error")
+	}
+
+	seqm := NewPersistentSeqManager(kvs, localstore)
+	s, err := NewScheduler(seqm, f, localstore, clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pk, _, err := testutil.RandTestKeyPair(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iprsKey, err := f.NewKeyRecordSigner(pk).BasePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := path.FromString("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+
+	getSeq := func() uint64 {
+		b, err := r.GetValue(ctx, iprsKey.String())
+		if err != nil {
+			return 0
+		}
+		e := new(pb.IprsEntry)
+		err = proto.Unmarshal(b, e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e.GetSequence()
+	}
+
+	// Schedule a record to be published in an hour
+	at := now.Add(time.Hour)
+	se, err := s.Schedule(ctx, iprsKey, f.NewEolKeyRecord(p, pk, now.Add(time.Hour*2)), at)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Not published before it is due
+	_, err = s.PublishDue(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if getSeq() != 0 {
+		t.Fatal("Expected entry not to be published before it is due")
+	}
+
+	// Schedule survives a restart
+	s, err = NewScheduler(seqm, f, localstore, clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].ID != se.ID || !entries[0].PublishAt.Equal(at) || entries[0].IprsKey != iprsKey {
+		t.Fatal("Expected scheduled entry to be listed")
+	}
+
+	// Published when it is due
+	clk.Add(time.Hour)
+	_, err = s.PublishDue(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if getSeq() != 1 {
+		t.Fatal("Expected scheduled entry to be published")
+	}
+	entries, err = s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatal("Expected published entry to be removed from schedule")
+	}
+
+	// Record is scheduled shortly before its range starts
+	s.Lead = time.Second * 30
+	start := now.Add(time.Hour * 3)
+	end := now.Add(time.Hour * 4)
+	rangeRecord, err := f.NewRangeKeyRecord(p, pk, &start, &end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	se, err = s.ScheduleAtStart(ctx, iprsKey, rangeRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !se.PublishAt.Equal(start.Add(-s.Lead)) {
+		t.Fatalf("Unexpected publish time %s", se.PublishAt)
+	}
+
+	// Cancelled entry is not published
+	err = s.Cancel(se.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Cancel(se.ID)
+	if err != ErrScheduledEntryNotFound {
+		t.Fatal("Expected scheduled entry not found error")
+	}
+	clk.Set(start)
+	_, err = s.PublishDue(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if getSeq() != 1 {
+		t.Fatal("Expected cancelled entry not to be published")
+	}
+
+	// Entry that is superseded before it is due is dropped
+	se, err = s.Schedule(ctx, iprsKey, f.NewEolKeyRecord(p, pk, start.Add(time.Hour*2)), start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = NewDHTPublisher(seqm, f).Publish(ctx, iprsKey, f.NewEolKeyRecord(p, pk, start.Add(time.Hour*2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clk.Add(time.Hour)
+	dropped, err := s.PublishDue(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dropped) != 1 || dropped[0].ID != se.ID {
+		t.Fatal("Expected superseded entry to be returned as dropped")
+	}
+	entries, err = s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatal("Expected superseded entry to be dropped")
+	}
+	if getSeq() != 4 {
+		t.Fatalf("Unexpected sequence number %d", getSeq())
+	}
+}
+
+func TestSchedulerSameKey(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	id := testutil.RandIdentityOrFatal(t)
+	r := vs.NewMockValueStore(context.Background(), id, dstore)
+	kvs := vs.NewKadValueStore(dstore, r)
+	now := time.Now()
+	clk := clock.NewMockClock(now)
+	f := rec.NewRecordFactory(r, clk)
+	localstore := dssync.MutexWrap(ds.NewMapDatastore())
+	seqm := NewPersistentSeqManager(kvs, localstore)
+	s, err := NewScheduler(seqm, f, localstore, clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pk, _, err := testutil.RandTestKeyPair(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iprsKey, err := f.NewKeyRecordSigner(pk).BasePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p1 := path.FromString("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+	p2 := path.FromString("/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD")
+
+	getEntry := func() *pb.IprsEntry {
+		b, err := r.GetValue(ctx, iprsKey.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := new(pb.IprsEntry)
+		err = proto.Unmarshal(b, e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+
+	// Schedule two entries for the same key, one after the other
+	first, err := s.Schedule(ctx, iprsKey, f.NewEolKeyRecord(p1, pk, now.Add(time.Hour*4)), now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.Schedule(ctx, iprsKey, f.NewEolKeyRecord(p2, pk, now.Add(time.Hour*4)), now.Add(time.Hour*2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Entry.GetSequence() != 1 || second.Entry.GetSequence() != 2 {
+		t.Fatal("Expected scheduled entries to reserve consecutive sequence numbers")
+	}
+
+	// The first entry is published when it is due, even though a higher
+	// sequence number has been reserved for the second
+	clk.Add(time.Hour)
+	dropped, err := s.PublishDue(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dropped) != 0 {
+		t.Fatal("Expected first entry not to be dropped")
+	}
+	e := getEntry()
+	if e.GetSequence() != 1 || string(e.GetValue()) != p1.String() {
+		t.Fatal("Expected first scheduled entry to be published")
+	}
+
+	// A record published in between gets a sequence number after the
+	// reserved one, so it doesn't clash with the second entry
+	err = NewDHTPublisher(seqm, f).Publish(ctx, iprsKey, f.NewEolKeyRecord(p1, pk, now.Add(time.Hour*4)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if getEntry().GetSequence() != 3 {
+		t.Fatalf("Unexpected sequence number %d", getEntry().GetSequence())
+	}
+
+	// The second entry has been superseded by the published record, so it
+	// is dropped and returned to the caller
+	clk.Add(time.Hour)
+	dropped, err = s.PublishDue(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dropped) != 1 || dropped[0].ID != second.ID {
+		t.Fatal("Expected second entry to be returned as dropped")
+	}
+	e = getEntry()
+	if e.GetSequence() != 3 || string(e.GetValue()) != p1.String() {
+		t.Fatal("Expected superseded entry not to be published")
+	}
+	entries, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatal("Expected dropped entry to be removed from schedule")
+	}
+
+	// The second entry is published if nothing is published in between
+	third, err := s.Schedule(ctx, iprsKey, f.NewEolKeyRecord(p2, pk, now.Add(time.Hour*4)), now.Add(time.Hour*3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clk.Add(time.Hour)
+	dropped, err = s.PublishDue(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dropped) != 0 {
+		t.Fatal("Expected entry not to be dropped")
+	}
+	e = getEntry()
+	if e.GetSequence() != third.Entry.GetSequence() || string(e.GetValue()) != p2.String() {
+		t.Fatal("Expected scheduled entry to be published")
+	}
+}
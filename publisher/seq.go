@@ -2,37 +2,153 @@ package iprs_publisher
 
 import (
 	"context"
+	"fmt"
+	"strconv"
+	"sync"
 	"time"
 
-	pb "github.com/dirkmc/go-iprs/pb"
 	rsp "github.com/dirkmc/go-iprs/path"
-	proto "gx/ipfs/QmZ4Qi3GaRbjcx28Sme5eMH7RQjGkt8wHxt2a65oLaeFEV/gogo-protobuf/proto"
+	pb "github.com/dirkmc/go-iprs/pb"
 	vs "github.com/dirkmc/go-iprs/vs"
+	dshelp "github.com/ipfs/go-ipfs/thirdparty/ds-help"
+	routing "gx/ipfs/QmPCGUjMRuBcPybZFpjhzpifwPP9wPRoiy5geTQKU4vqWA/go-libp2p-routing"
+	proto "gx/ipfs/QmZ4Qi3GaRbjcx28Sme5eMH7RQjGkt8wHxt2a65oLaeFEV/gogo-protobuf/proto"
+	ds "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore"
 )
 
+// Sequence numbers are stored in the local datastore under this prefix
+var seqKeyPrefix = ds.NewKey("/iprs/seq")
+
+// Sequence numbers reserved for entries that have been signed but not
+// yet published (eg scheduled entries) are stored under this prefix
+var reservedSeqKeyPrefix = ds.NewKey("/iprs/reserved-seq")
+
+// SeqManager keeps track of the sequence numbers of the records we
+// publish. If it has a datastore, the last sequence number published for
+// each key is stored in it, and the network is only queried for keys
+// that have no local state.
 type SeqManager struct {
 	vstore vs.ValueStore
+	dstore ds.Datastore
+
+	lk    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+	refs int
 }
 
 func NewSeqManager(vstore vs.ValueStore) *SeqManager {
-	return &SeqManager{ vstore }
+	return NewPersistentSeqManager(vstore, nil)
+}
+
+// NewPersistentSeqManager creates a SeqManager that stores sequence
+// numbers in the datastore. If dstore is nil sequence numbers are
+// always read from the value store.
+func NewPersistentSeqManager(vstore vs.ValueStore, dstore ds.Datastore) *SeqManager {
+	return &SeqManager{
+		vstore: vstore,
+		dstore: dstore,
+		locks:  make(map[string]*keyLock),
+	}
+}
+
+// Lock acquires an exclusive lock on the key, so that concurrent
+// publishes to the same key don't pick the same sequence number. Call
+// the returned function to release the lock.
+func (s *SeqManager) Lock(iprsKey rsp.IprsPath) func() {
+	k := iprsKey.String()
+
+	s.lk.Lock()
+	l, ok := s.locks[k]
+	if !ok {
+		l = new(keyLock)
+		s.locks[k] = l
+	}
+	l.refs++
+	s.lk.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+
+		s.lk.Lock()
+		defer s.lk.Unlock()
+		l.refs--
+		if l.refs == 0 {
+			delete(s.locks, k)
+		}
+	}
+}
+
+// NextSeqNo returns the sequence number to publish the next entry for
+// the key with, after both the previous entry's and any reserved
+// sequence number. It is not stored, so that a failed publish doesn't
+// use it up: call SetSeqNo once the entry has been published. The
+// caller should hold the key's lock until then.
+func (s *SeqManager) NextSeqNo(ctx context.Context, iprsKey rsp.IprsPath) (uint64, error) {
+	seq, err := s.GetPreviousSeqNo(ctx, iprsKey)
+	if err != nil {
+		return 0, err
+	}
+	reserved, ok, err := s.getStoredSeqNo(getReservedSeqKey(iprsKey))
+	if err != nil {
+		return 0, err
+	}
+	if ok && reserved > seq {
+		seq = reserved
+	}
+	return seq + 1, nil
+}
+
+// ReserveSeqNo records that an entry with the given sequence number was
+// signed for the key and will be published later, so that NextSeqNo
+// doesn't return it again. Unlike SetSeqNo it doesn't change the
+// previous sequence number, so entries with lower sequence numbers can
+// still be published in the meantime. If the SeqManager has no
+// datastore it does nothing.
+func (s *SeqManager) ReserveSeqNo(iprsKey rsp.IprsPath, seq uint64) error {
+	return s.putStoredSeqNo(getReservedSeqKey(iprsKey), seq)
+}
+
+// SetSeqNo records that an entry with the given sequence number was
+// published for the key. The stored sequence number never goes
+// backwards. If the SeqManager has no datastore it does nothing.
+func (s *SeqManager) SetSeqNo(iprsKey rsp.IprsPath, seq uint64) error {
+	return s.putStoredSeqNo(getSeqKey(iprsKey), seq)
 }
 
 func (s *SeqManager) GetPreviousSeqNo(ctx context.Context, iprsKey rsp.IprsPath) (uint64, error) {
 	log.Debugf("GetPreviousSeqNo %s", iprsKey)
 
+	// If we have published to this key before, we know the sequence number
+	seq, ok, err := s.getLocalSeqNo(iprsKey)
+	if err != nil {
+		return 0, err
+	}
+	if ok {
+		return seq, nil
+	}
+
 	var val []byte
-	val, err := s.vstore.GetLocalValue(ctx, iprsKey.String())
+	val, err = s.vstore.GetLocalValue(ctx, iprsKey.String())
 	if err != nil {
 		ctxt, cancel := context.WithTimeout(ctx, time.Second*30)
 		defer cancel()
 
 		v, err := s.vstore.GetValue(ctxt, iprsKey.String())
-		if err != nil {
+		if err == routing.ErrNotFound || err == ds.ErrNotFound {
 			// No record found in the DHT either
 			log.Debugf("No previous seq num found in DHT for %s, start at 0", iprsKey)
 			return 0, nil
 		}
+		if err != nil {
+			// Starting at 0 could publish below the current entry
+			log.Warningf("Failed to get previous seq num for %s: %s", iprsKey, err)
+			return 0, err
+		}
 		val = v
 	}
 
@@ -45,3 +161,79 @@ func (s *SeqManager) GetPreviousSeqNo(ctx context.Context, iprsKey rsp.IprsPath)
 
 	return e.GetSequence(), nil
 }
+
+// GetNetworkSeqNo fetches the sequence number of the key's current entry
+// from routing, ignoring local state, eg to detect entries published by
+// other publishers. If there is no entry it returns 0.
+func (s *SeqManager) GetNetworkSeqNo(ctx context.Context, iprsKey rsp.IprsPath) (uint64, error) {
+	ctxt, cancel := context.WithTimeout(ctx, time.Second*30)
+	defer cancel()
+
+	val, err := s.vstore.GetValue(ctxt, iprsKey.String())
+	if err == routing.ErrNotFound || err == ds.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	e := new(pb.IprsEntry)
+	err = proto.Unmarshal(val, e)
+	if err != nil {
+		return 0, err
+	}
+	return e.GetSequence(), nil
+}
+
+func (s *SeqManager) getLocalSeqNo(iprsKey rsp.IprsPath) (uint64, bool, error) {
+	return s.getStoredSeqNo(getSeqKey(iprsKey))
+}
+
+func (s *SeqManager) getStoredSeqNo(k ds.Key) (uint64, bool, error) {
+	if s.dstore == nil {
+		return 0, false, nil
+	}
+
+	v, err := s.dstore.Get(k)
+	if err == ds.ErrNotFound {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	b, ok := v.([]byte)
+	if !ok {
+		return 0, false, fmt.Errorf("Unexpected type returned from datastore: %#v", v)
+	}
+	seq, err := strconv.ParseUint(string(b), 10, 64)
+	if err != nil {
+		return 0, false, err
+	}
+	return seq, true, nil
+}
+
+// putStoredSeqNo stores the sequence number at the key, unless a higher
+// one is already stored there
+func (s *SeqManager) putStoredSeqNo(k ds.Key, seq uint64) error {
+	if s.dstore == nil {
+		return nil
+	}
+
+	prev, ok, err := s.getStoredSeqNo(k)
+	if err != nil {
+		return err
+	}
+	if ok && prev >= seq {
+		return nil
+	}
+	return s.dstore.Put(k, []byte(strconv.FormatUint(seq, 10)))
+}
+
+func getSeqKey(iprsKey rsp.IprsPath) ds.Key {
+	return seqKeyPrefix.Child(dshelp.NewKeyFromBinary([]byte(iprsKey.String())))
+}
+
+func getReservedSeqKey(iprsKey rsp.IprsPath) ds.Key {
+	return reservedSeqKeyPrefix.Child(dshelp.NewKeyFromBinary([]byte(iprsKey.String())))
+}
@@ -2,6 +2,7 @@ package iprs_publisher
 
 import (
 	"context"
+	"sync"
 	"testing"
 	"time"
 
@@ -31,7 +32,7 @@ func getEolRecord(t *testing.T, ts time.Time, r vs.ValueStore) (rsp.IprsPath, *r
 	if err != nil {
 		t.Fatal(err)
 	}
-	factory := rec.NewRecordFactory(r)
+	factory := rec.NewRecordFactory(r, nil)
 	return iprsKey, factory.NewEolKeyRecord(p, pk, ts)
 }
 
@@ -105,3 +106,141 @@ func TestSeqFetch(t *testing.T) {
 		t.Fatal("Unexpected sequence number %d", seq)
 	}
 }
+
+func TestPersistentSeq(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	id := testutil.RandIdentityOrFatal(t)
+	r := vs.NewMockValueStore(context.Background(), id, dstore)
+	kvs := vs.NewKadValueStore(dstore, r)
+	seqstore := dssync.MutexWrap(ds.NewMapDatastore())
+	seqm := NewPersistentSeqManager(kvs, seqstore)
+
+	ts := time.Now().Add(time.Hour)
+	iprsKey, eolRecord := getEolRecord(t, ts, r)
+
+	// Existing entry on the network is used when there is no local state
+	err := eolRecord.Publish(ctx, iprsKey, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seq, err := seqm.NextSeqNo(ctx, iprsKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq != 4 {
+		t.Fatalf("Unexpected sequence number %d", seq)
+	}
+
+	// The next sequence number is not stored until it is set
+	seq, ok, err := seqm.getLocalSeqNo(iprsKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatalf("Unexpected stored sequence number %d", seq)
+	}
+	err = seqm.SetSeqNo(iprsKey, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Once there is local state the network is not queried, so the
+	// sequence number doesn't go backwards
+	err = r.DeleteValue(iprsKey.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	seqm = NewPersistentSeqManager(kvs, seqstore)
+	seq, err = seqm.GetPreviousSeqNo(ctx, iprsKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq != 4 {
+		t.Fatalf("Unexpected sequence number %d", seq)
+	}
+
+	// Setting a lower sequence number has no effect
+	err = seqm.SetSeqNo(iprsKey, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seq, err = seqm.GetPreviousSeqNo(ctx, iprsKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq != 4 {
+		t.Fatalf("Unexpected sequence number %d", seq)
+	}
+
+	// Concurrent publishes each get a different sequence number
+	p := NewDHTPublisher(seqm, rec.NewRecordFactory(r, nil))
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- p.Publish(ctx, iprsKey, eolRecord)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	seq, err = seqm.GetPreviousSeqNo(ctx, iprsKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq != 14 {
+		t.Fatalf("Unexpected sequence number %d", seq)
+	}
+	if len(seqm.locks) != 0 {
+		t.Fatal("Expected key locks to be released")
+	}
+}
+
+type failingValueStore struct {
+	vs.ValueStore
+	err error
+}
+
+func (f *failingValueStore) GetValue(ctx context.Context, k string) ([]byte, error) {
+	return nil, f.err
+}
+
+func TestSeqFetchError(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	id := testutil.RandIdentityOrFatal(t)
+	r := vs.NewMockValueStore(context.Background(), id, dstore)
+	kvs := vs.NewKadValueStore(dstore, r)
+
+	ts := time.Now().Add(time.Hour)
+	iprsKey, _ := getEolRecord(t, ts, r)
+
+	// A network failure is not the same as there being no record
+	fvs := &failingValueStore{kvs, context.DeadlineExceeded}
+	seqm := NewSeqManager(fvs)
+	_, err := seqm.GetPreviousSeqNo(ctx, iprsKey)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Expected network error, got %v", err)
+	}
+	_, err = seqm.NextSeqNo(ctx, iprsKey)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Expected network error, got %v", err)
+	}
+
+	// Not found means there is no previous record
+	fvs.err = ds.ErrNotFound
+	seq, err := seqm.GetPreviousSeqNo(ctx, iprsKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq != 0 {
+		t.Fatalf("Unexpected sequence number %d", seq)
+	}
+}
@@ -2,29 +2,91 @@ package iprs_record
 
 import (
 	"context"
-	"fmt"
+	"crypto"
 	"crypto/x509"
-	"crypto/rsa"
+	"errors"
+	"fmt"
+	"strings"
+
 	c "github.com/dirkmc/go-iprs/certificate"
-	pb "github.com/dirkmc/go-iprs/pb"
+	clock "github.com/dirkmc/go-iprs/clock"
 	rsp "github.com/dirkmc/go-iprs/path"
+	pb "github.com/dirkmc/go-iprs/pb"
+	u "github.com/ipfs/go-ipfs-util"
+	routing "gx/ipfs/QmPCGUjMRuBcPybZFpjhzpifwPP9wPRoiy5geTQKU4vqWA/go-libp2p-routing"
+	proto "gx/ipfs/QmZ4Qi3GaRbjcx28Sme5eMH7RQjGkt8wHxt2a65oLaeFEV/gogo-protobuf/proto"
+)
+
+// MaxCertChainLength is the maximum number of intermediate certificates
+// between the signing certificate and the root certificate
+const MaxCertChainLength = 8
+
+// ErrCertChainLength should be returned when a Cert record has more
+// than MaxCertChainLength intermediate certificates
+var ErrCertChainLength = errors.New("certificate chain too long")
+
+// ErrCertRevoked should be returned when a certificate in the chain of a
+// Cert record is on its issuer's revocation list
+var ErrCertRevoked = errors.New("certificate has been revoked")
+
+// ErrCertNotAllowed should be returned when the certificate that signed
+// a Cert record is not on the allow list published by the root certificate
+var ErrCertNotAllowed = errors.New("certificate is not on the allow list")
+
+// ErrUntrustedRoot should be returned when the trust policy only accepts
+// records that chain to pinned root certificates, and the record's root
+// certificate is not pinned
+var ErrUntrustedRoot = errors.New("root certificate is not in trust store")
+
+// TrustPolicy determines which root certificates Cert records may chain to
+type TrustPolicy int
+
+const (
+	// TrustAnyRoot accepts records that chain to any root certificate.
+	// Pinned root certificates are still used instead of fetching them.
+	TrustAnyRoot TrustPolicy = iota
+	// TrustPinnedRoots only accepts records that chain to a root
+	// certificate in the trust store
+	TrustPinnedRoots
 )
 
+// ErrValidityPastCertExpiry should be returned when a Cert record could
+// be valid after the signing certificate expires, and the verifier is
+// set to reject such records
+var ErrValidityPastCertExpiry = errors.New("record valid past signing certificate expiry")
+
 type CertRecordSigner struct {
-	m *c.CertificateManager
-	cert *x509.Certificate
-	pk *rsa.PrivateKey
+	m             *c.CertificateManager
+	cert          *x509.Certificate
+	intermediates []*x509.Certificate
+	pk            crypto.Signer
 }
 
-// TODO: Whitelist
-func NewCertRecordSigner(m *c.CertificateManager, cert *x509.Certificate, pk *rsa.PrivateKey) *CertRecordSigner {
+// pk is the private key of the certificate, which may be an RSA, ECDSA
+// or Ed25519 key (or eg a hardware key that implements crypto.Signer).
+// If pk is nil the signer can create unsigned entries and publish the
+// certificate, but cannot sign entries itself (eg because the private
+// key is kept offline)
+func NewCertRecordSigner(m *c.CertificateManager, cert *x509.Certificate, pk crypto.Signer) *CertRecordSigner {
+	return NewCertChainRecordSigner(m, cert, nil, pk)
+}
+
+// NewCertChainRecordSigner creates a signer for a certificate that was
+// not issued directly by the root certificate (whose hash is the IPRS
+// key). The intermediate certificates are ordered from the one that
+// issued cert up to the one that was issued by the root certificate.
+func NewCertChainRecordSigner(m *c.CertificateManager, cert *x509.Certificate, intermediates []*x509.Certificate, pk crypto.Signer) *CertRecordSigner {
 	return &CertRecordSigner{
-		m: m,
-		cert: cert,
-		pk: pk,
+		m:             m,
+		cert:          cert,
+		intermediates: intermediates,
+		pk:            pk,
 	}
 }
 
+// BasePath is the IPRS key of the signing certificate's own name. Records
+// signed by a certificate issued by a root certificate are published
+// under the root certificate's IPRS key instead.
 func (s *CertRecordSigner) BasePath() (rsp.IprsPath, error) {
 	h, err := c.GetCertificateHash(s.cert)
 	if err != nil {
@@ -38,22 +100,79 @@ func (s *CertRecordSigner) VerificationType() *pb.IprsEntry_VerificationType {
 	return &t
 }
 
+// Verification is the hash of the signing certificate followed by the
+// hashes of any intermediate certificates, separated by commas
 func (s *CertRecordSigner) Verification() ([]byte, error) {
-	h, err := c.GetCertificateHash(s.cert)
-	if err != nil {
-		return nil, err
+	var hashes []string
+	for _, cert := range append([]*x509.Certificate{s.cert}, s.intermediates...) {
+		h, err := c.GetCertificateHash(cert)
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, h)
 	}
-	return []byte(h), nil
+	return []byte(strings.Join(hashes, ",")), nil
 }
 
 func (s *CertRecordSigner) PublishVerification(ctx context.Context, iprsKey rsp.IprsPath, entry *pb.IprsEntry) error {
 	// TODO: Check iprsKey is valid for this type of RecordSigner
-	_, err := s.m.PutCertificate(ctx, s.cert)
+	_, err := s.m.PutCertificateChain(ctx, append([]*x509.Certificate{s.cert}, s.intermediates...))
 	return err
 }
 
-func (s *CertRecordSigner) SignRecord(entry *pb.IprsEntry) error {
-	sig, err := c.Sign(s.pk, RecordDataForSig(entry))
+func (s *CertRecordSigner) stageVerification(ctx context.Context, vs routing.ValueStore) error {
+	_, err := c.NewCertificateManager(vs, nil).PutCertificateChain(ctx, append([]*x509.Certificate{s.cert}, s.intermediates...))
+	return err
+}
+
+// checkKey checks that the IPRS key is the name of a certificate in the
+// chain: either the root at the top of the chain, or the root that
+// issued the top of the chain, which is fetched with getCert. If getCert
+// is nil the root is fetched with the signer's certificate manager.
+func (s *CertRecordSigner) checkKey(ctx context.Context, iprsKey rsp.IprsPath, getCert certGetter) error {
+	chain := append([]*x509.Certificate{s.cert}, s.intermediates...)
+	top := chain[len(chain)-1]
+	selfSigned := c.CheckSignatureFrom(top, top) == nil
+	hash := iprsKey.GetHashString()
+	for i, cert := range chain {
+		h, err := c.GetCertificateHash(cert)
+		if err != nil {
+			return err
+		}
+		if h == hash {
+			if i == len(chain)-1 && selfSigned {
+				return nil
+			}
+			return ErrKeyMismatch
+		}
+	}
+	if selfSigned {
+		return ErrKeyMismatch
+	}
+
+	if getCert == nil {
+		getCert = s.m.GetCertificate
+	}
+	root, err := getCert(ctx, hash)
+	if err != nil {
+		return err
+	}
+	if c.CheckSignatureFrom(top, root) != nil {
+		return ErrKeyMismatch
+	}
+	return nil
+}
+
+func (s *CertRecordSigner) SignRecord(iprsKey rsp.IprsPath, entry *pb.IprsEntry) error {
+	if s.pk == nil {
+		return ErrNoPrivateKey
+	}
+	entry.SignatureVersion = proto.Uint32(SignatureV2)
+	data, err := RecordDataForSig(iprsKey, entry)
+	if err != nil {
+		return err
+	}
+	sig, err := c.Sign(s.pk, data)
 	if err != nil {
 		return err
 	}
@@ -62,32 +181,133 @@ func (s *CertRecordSigner) SignRecord(entry *pb.IprsEntry) error {
 	return nil
 }
 
+// CertParseVerification returns the hash of the certificate that signed
+// the entry, and the hashes of the intermediate certificates ordered from
+// the one that issued the signing certificate up to the one that was
+// issued by the root certificate
+func CertParseVerification(entry *pb.IprsEntry) (string, []string, error) {
+	hashes := strings.Split(string(entry.GetVerification()), ",")
+	if len(hashes)-1 > MaxCertChainLength {
+		return "", nil, ErrCertChainLength
+	}
+	for _, h := range hashes {
+		if !u.IsValidHash(h) {
+			return "", nil, fmt.Errorf("Bad certificate hash: [%s]", h)
+		}
+	}
+	return hashes[0], hashes[1:], nil
+}
+
 type CertRecordVerifier struct {
-	m *c.CertificateManager
+	m             *c.CertificateManager
+	rm            *c.RevocationManager
+	am            *c.AllowListManager
+	clock         clock.Clock
+	limitValidity bool
+	trust         *c.TrustStore
+	policy        TrustPolicy
 }
 
-func NewCertRecordVerifier(m *c.CertificateManager) *CertRecordVerifier {
-	return &CertRecordVerifier{ m }
+// Certificates in the chain are checked against the revocation lists
+// published by their issuers, fetched through rm. If rm is nil
+// revocation lists are not checked.
+// If the root certificate has published an allow list (fetched through
+// am) the signing certificate must be on it. If am is nil allow lists
+// are not checked.
+// The certificate chain is checked against the given clock.
+// If clk is nil the system clock is used.
+func NewCertRecordVerifier(m *c.CertificateManager, rm *c.RevocationManager, am *c.AllowListManager, clk clock.Clock) *CertRecordVerifier {
+	return &CertRecordVerifier{m: m, rm: rm, am: am, clock: clock.OrRealClock(clk)}
+}
+
+// SetLimitValidityToCert sets whether to reject records that could be
+// valid after the signing certificate's NotAfter time (including records
+// that never expire)
+func (v *CertRecordVerifier) SetLimitValidityToCert(limit bool) {
+	v.limitValidity = limit
+}
+
+// SetTrustStore sets the pinned root certificates, and whether records
+// must chain to one of them
+func (v *CertRecordVerifier) SetTrustStore(trust *c.TrustStore, policy TrustPolicy) {
+	v.trust = trust
+	v.policy = policy
 }
 
 func (v *CertRecordVerifier) VerifyRecord(ctx context.Context, iprsKey rsp.IprsPath, entry *pb.IprsEntry) error {
-	certHash := string(entry.GetVerification())
-	issuerCertHash := iprsKey.GetHashString()
+	certHash, intermediateHashes, err := CertParseVerification(entry)
+	if err != nil {
+		return err
+	}
+	rootCertHash := iprsKey.GetHashString()
+
+	// Check the root before anything else, so that a record that chains
+	// to an untrusted root is rejected even if it's otherwise valid
+	if v.policy == TrustPinnedRoots && (v.trust == nil || !v.trust.IsTrusted(rootCertHash)) {
+		log.Warningf("Root cert [%s] of %s is not in trust store", rootCertHash, iprsKey)
+		return ErrUntrustedRoot
+	}
 
-	// Hashes should be X509 certificates retrievable from ipfs
-	cert, issuerCert, err := v.getCerts(ctx, certHash, issuerCertHash)
+	// Hashes should be X509 certificates retrievable from ipfs.
+	// The chain runs from the signing cert up to the root cert.
+	chainHashes := append([]string{certHash}, intermediateHashes...)
+	chainHashes = append(chainHashes, rootCertHash)
+	chain, err := v.getCerts(ctx, chainHashes)
 	if err != nil {
 		return err
 	}
 
-	// Check that issuer issued the certificate
-	if err = c.CheckSignatureFrom(cert, issuerCert); err != nil {
-		log.Warningf("Check signature parent failed for cert [%s] issued by cert [%s]: %v", certHash, issuerCertHash, err)
+	// Check the validity period, key usage and basic constraints
+	// of the certificates in the chain
+	if err = c.CheckChainPolicy(chain, v.clock.Now()); err != nil {
+		log.Warningf("Certificate policy check failed for cert [%s]: %v", certHash, err)
+		return err
+	}
+
+	// Check that the certs in the chain permit signing records at
+	// this path
+	if err = c.CheckPathConstraints(chain, iprsKey.GetRelativePath()); err != nil {
+		log.Warningf("Path constraints of cert chain for cert [%s] do not permit path %s", certHash, iprsKey)
+		return err
+	}
+
+	// Check that each cert in the chain issued the cert below it
+	// (the root can use her own cert to sign records, in which case
+	// the root cert must be self-signed)
+	for i := 0; i < len(chain)-1; i++ {
+		if err = c.CheckSignatureFrom(chain[i], chain[i+1]); err != nil {
+			log.Warningf("Check signature parent failed for cert [%s] issued by cert [%s]: %v", chainHashes[i], chainHashes[i+1], err)
+			return err
+		}
+	}
+
+	// Check that none of the certs in the chain have been revoked
+	if err = v.checkRevocation(ctx, chain, chainHashes); err != nil {
+		return err
+	}
+
+	// Check that the signing cert is currently allowed to sign
+	// records for the root cert's name
+	if err = v.checkAllowList(ctx, chain, certHash, rootCertHash); err != nil {
 		return err
 	}
 
+	if v.limitValidity {
+		bounds, err := CompositeTimeBounds(entry)
+		if err != nil {
+			return err
+		}
+		if bounds[1] == nil || bounds[1].After(chain[0].NotAfter) {
+			return ErrValidityPastCertExpiry
+		}
+	}
+
 	// Check signature with certificate
-	if err = c.CheckSignature(cert, RecordDataForSig(entry), entry.GetSignature()); err != nil {
+	data, err := RecordDataForSig(iprsKey, entry)
+	if err != nil {
+		return err
+	}
+	if err = c.CheckSignature(chain[0], data, entry.GetSignature()); err != nil {
 		return fmt.Errorf("Check signature failed for cert [%s]: %v", certHash, err)
 	}
 
@@ -95,43 +315,118 @@ func (v *CertRecordVerifier) VerifyRecord(ctx context.Context, iprsKey rsp.IprsP
 	return nil
 }
 
-func (v *CertRecordVerifier) getCerts(ctx context.Context, certHash, issuerCertHash string) (*x509.Certificate, *x509.Certificate, error) {
-	// The issuer can use her own cert to sign records
-	if certHash == issuerCertHash {
-		ct, err := v.m.GetCertificate(ctx, certHash)
-		if err != nil {
-			log.Warningf("Failed to get Certificate [%s]", certHash)
-			return nil, nil, err
+// checkRevocation checks the revocation list of each issuer in the chain
+// (fetched in parallel) for the cert it issued
+func (v *CertRecordVerifier) checkRevocation(ctx context.Context, chain []*x509.Certificate, chainHashes []string) error {
+	if v.rm == nil {
+		return nil
+	}
+
+	resp := make(chan error, len(chain)-1)
+	for i := 0; i < len(chain)-1; i++ {
+		go func(i int) {
+			revoked, err := v.rm.IsRevoked(ctx, chain[i], chainHashes[i+1])
+			if err == nil && revoked {
+				log.Warningf("Cert [%s] has been revoked by issuer [%s]", chainHashes[i], chainHashes[i+1])
+				err = ErrCertRevoked
+			}
+			resp <- err
+		}(i)
+	}
+
+	var err error
+	for i := 0; i < len(chain)-1; i++ {
+		if rerr := <-resp; rerr != nil {
+			err = rerr
 		}
-		return ct, ct, nil
 	}
+	return err
+}
 
-	// If the issuer and cert hash are different, get them in parallel
-	var cert, issuerCert *x509.Certificate
-	resp := make(chan error, 2)
+// checkAllowList checks that the signing cert, or one of the intermediate
+// certs that issued it, is on the root cert's allow list (if the root has
+// published one). Certs can be on the list by hash, or by subject if they
+// were issued directly by the root cert, as an intermediate cert could
+// issue a cert with any subject. The root cert itself is always allowed
+// to sign.
+func (v *CertRecordVerifier) checkAllowList(ctx context.Context, chain []*x509.Certificate, certHash, rootCertHash string) error {
+	if v.am == nil || certHash == rootCertHash {
+		return nil
+	}
 
-	getCert := func(hash string, cType string, cPtr **x509.Certificate) {
-		ct, err := v.m.GetCertificate(ctx, hash)
+	l, err := v.am.GetAllowList(ctx, rootCertHash)
+	if err != nil || l == nil {
+		return err
+	}
+
+	for i, cert := range chain[:len(chain)-1] {
+		allowed, err := l.HasCertHash(cert)
 		if err != nil {
-			log.Warningf("Failed to get %s [%s]", cType, hash)
-			resp <- err
-			return
+			return err
+		}
+		// The chain has been verified, so the last cert before the root
+		// was issued by the root
+		if allowed || (i == len(chain)-2 && l.HasSubject(cert)) {
+			return nil
 		}
+	}
+	return ErrCertNotAllowed
+}
 
-		*cPtr = ct
-		resp <- nil
+// getCert gets a pinned certificate or fetches it
+func (v *CertRecordVerifier) getCert(ctx context.Context, hash string) (*x509.Certificate, error) {
+	certs, err := v.getCerts(ctx, []string{hash})
+	if err != nil {
+		return nil, err
 	}
+	return certs[0], nil
+}
 
-	go getCert(certHash, "Certificate", &cert)
-	go getCert(issuerCertHash, "Issuer Certificate", &issuerCert)
+// getCerts fetches the certificates with the given hashes in parallel,
+// returning them in the same order as the hashes
+func (v *CertRecordVerifier) getCerts(ctx context.Context, hashes []string) ([]*x509.Certificate, error) {
+	// The same cert may appear more than once (eg the root can use her
+	// own cert to sign records) so only fetch each cert once
+	indexes := make(map[string][]int)
+	for i, hash := range hashes {
+		indexes[hash] = append(indexes[hash], i)
+	}
 
-	var err error
-	for i := 0; i < 2; i++ {
-		err = <-resp
-		if err != nil {
-			return nil, nil, err
+	type certResp struct {
+		hash string
+		cert *x509.Certificate
+		err  error
+	}
+	resp := make(chan certResp, len(indexes))
+
+	for hash := range indexes {
+		go func(hash string) {
+			// Pinned certs don't need to be fetched
+			if v.trust != nil {
+				if ct, ok := v.trust.GetCertificate(hash); ok {
+					resp <- certResp{hash, ct, nil}
+					return
+				}
+			}
+
+			ct, err := v.m.GetCertificate(ctx, hash)
+			if err != nil {
+				log.Warningf("Failed to get Certificate [%s]", hash)
+			}
+			resp <- certResp{hash, ct, err}
+		}(hash)
+	}
+
+	certs := make([]*x509.Certificate, len(hashes))
+	for range indexes {
+		r := <-resp
+		if r.err != nil {
+			return nil, r.err
+		}
+		for _, i := range indexes[r.hash] {
+			certs[i] = r.cert
 		}
 	}
 
-	return cert, issuerCert, nil
+	return certs, nil
 }
@@ -2,19 +2,26 @@ package iprs_record
 
 import (
 	"context"
+	"crypto"
 	"crypto/rand"
-	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io/ioutil"
 	"math/big"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	c "github.com/dirkmc/go-iprs/certificate"
+	clock "github.com/dirkmc/go-iprs/clock"
 	rsp "github.com/dirkmc/go-iprs/path"
 	pb "github.com/dirkmc/go-iprs/pb"
 	path "github.com/ipfs/go-ipfs/path"
 	mockrouting "github.com/ipfs/go-ipfs/routing/mock"
+	dshelp "github.com/ipfs/go-ipfs/thirdparty/ds-help"
+	routing "gx/ipfs/QmPCGUjMRuBcPybZFpjhzpifwPP9wPRoiy5geTQKU4vqWA/go-libp2p-routing"
 	proto "gx/ipfs/QmZ4Qi3GaRbjcx28Sme5eMH7RQjGkt8wHxt2a65oLaeFEV/gogo-protobuf/proto"
 	ds "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore"
 	dssync "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore/sync"
@@ -29,12 +36,12 @@ func TestCertRecordVerification(t *testing.T) {
 	ctx := context.Background()
 	dstore := dssync.MutexWrap(ds.NewMapDatastore())
 	r := mockrouting.NewServer().ClientWithDatastore(ctx, testutil.RandIdentityOrFatal(t), dstore)
-	certManager := c.NewCertificateManager(r)
-	verifier := NewCertRecordVerifier(certManager)
+	certManager := c.NewCertificateManager(r, nil)
+	verifier := NewCertRecordVerifier(certManager, nil, nil, nil)
 
 	// Simplifies creating a record and publishing it to routing
-	NewRecord := func() func(rsp.IprsPath, *rsa.PrivateKey, *x509.Certificate, uint64, time.Time) *pb.IprsEntry {
-		return func(iprsKey rsp.IprsPath, pk *rsa.PrivateKey, cert *x509.Certificate, seq uint64, eol time.Time) *pb.IprsEntry {
+	NewRecord := func() func(rsp.IprsPath, crypto.Signer, *x509.Certificate, uint64, time.Time) *pb.IprsEntry {
+		return func(iprsKey rsp.IprsPath, pk crypto.Signer, cert *x509.Certificate, seq uint64, eol time.Time) *pb.IprsEntry {
 			vl := NewEolRecordValidity(eol)
 			s := NewCertRecordSigner(certManager, cert, pk)
 			rec := NewRecord(r, vl, s, path.Path("foo"))
@@ -58,26 +65,18 @@ func TestCertRecordVerification(t *testing.T) {
 	// Setup: Create a CA certificate and a child of the CA certificate
 
 	// CA Certificate
-	caCert, caPk, err := generateCACertificate("ca cert")
-	if err != nil {
-		t.Fatal(err)
-	}
+	ca := newRootCA(t, "ca cert")
+	caCert, caPk := ca.Cert, ca.Key
 
 	// Child of CA Certificate
-	childCert, pk, err := generateChildCertificate("child cert", caCert, caPk)
-	if err != nil {
-		t.Fatal(err)
-	}
+	childCert, pk := issueCertificate(t, ca, "child cert")
 
 	// Unrelated CA Certificate
-	unrelatedCaCert, _, err := generateCACertificate("unrelated ca cert")
-	if err != nil {
-		t.Fatal(err)
-	}
+	unrelatedCaCert := newRootCA(t, "unrelated ca cert").Cert
 
 	// Put the unrelated certificate onto the network
 	// so it's available to the verifier
-	_, err = certManager.PutCertificate(ctx, unrelatedCaCert)
+	_, err := certManager.PutCertificate(ctx, unrelatedCaCert)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -119,7 +118,7 @@ func TestCertRecordVerification(t *testing.T) {
 	}
 
 	// Record is not valid if the signing key is unrelated to the cert
-	unrelatedPk, err := rsa.GenerateKey(rand.Reader, 2048)
+	unrelatedPk, err := c.GenerateKey(c.KeyTypeRSA)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -136,10 +135,8 @@ func TestCertRecordVerification(t *testing.T) {
 
 		// Record is not valid if the CA cert could not be retrieved
 		// from the network
-		tmpCaCert, tmpPk, err := generateCACertificate("temporary ca cert")
-		if err != nil {
-			t.Fatal(err)
-		}
+		tmpCa := newRootCA(t, "temporary ca cert")
+		tmpCaCert, tmpPk := tmpCa.Cert, tmpCa.Key
 		tmpCaCertIprsKey := getIprsPathFromCert(t, tmpCaCert, "/somePath")
 		e4 := NewRecord(tmpCaCertIprsKey, tmpPk, tmpCaCert, 1, ts.Add(time.Hour))
 
@@ -152,10 +149,7 @@ func TestCertRecordVerification(t *testing.T) {
 
 		// Record is not valid if the child cert could not be retrieved
 		// from the network (even though issuing CA cert can be)
-		tmpChildCert, tmpChildPk, err := generateChildCertificate("tmp child cert", caCert, caPk)
-		if err != nil {
-			t.Fatal(err)
-		}
+		tmpChildCert, tmpChildPk := issueCertificate(t, ca, "tmp child cert")
 		tmpChildCertIprsKey := getIprsPathFromCert(t, caCert, "/somePath")
 		e5 := NewRecord(tmpChildCertIprsKey, tmpChildPk, tmpChildCert, 1, ts.Add(time.Hour))
 
@@ -167,89 +161,882 @@ func TestCertRecordVerification(t *testing.T) {
 	*/
 }
 
-func getIprsPathFromCert(t *testing.T, cert *x509.Certificate, relativePath string) rsp.IprsPath {
-	certHash, err := c.GetCertificateHash(cert)
+func TestCertRecordKeyTypes(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	r := mockrouting.NewServer().ClientWithDatastore(ctx, testutil.RandIdentityOrFatal(t), dstore)
+	f := NewRecordFactory(r, nil)
+	eol := time.Now().Add(time.Hour)
+
+	keyTypes := map[string]c.KeyType{
+		"RSA":     c.KeyTypeRSA,
+		"ECDSA":   c.KeyTypeECDSA,
+		"Ed25519": c.KeyTypeEd25519,
+	}
+
+	for caType, caKeyType := range keyTypes {
+		for childType, childKeyType := range keyTypes {
+			opts := certOptions("ca cert")
+			opts.KeyType = caKeyType
+			ca, err := c.NewRootCA(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			caCert, caPk := ca.Cert, ca.Key
+			opts = certOptions("child cert")
+			opts.KeyType = childKeyType
+			childCert, pk, err := ca.IssueCertificate(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Record signed with CA cert is valid
+			iprsKey := getIprsPathFromCert(t, caCert, "/myIprsName")
+			rec := f.NewEolCertRecord(path.Path("/ipfs/myIpfsHash"), caCert, caPk, eol)
+			err = rec.Publish(ctx, iprsKey, 1)
+			if err != nil {
+				t.Fatalf("%s CA: %s", caType, err)
+			}
+			entry, err := rec.Entry(iprsKey, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = f.Verify(ctx, iprsKey, entry)
+			if err != nil {
+				t.Fatalf("%s CA: %s", caType, err)
+			}
+
+			// Record signed with child cert is valid
+			iprsKey = getIprsPathFromCert(t, caCert, "/myDelegatedFriendsIprsName")
+			rec = f.NewEolCertRecord(path.Path("/ipfs/myIpfsHash"), childCert, pk, eol)
+			err = rec.Publish(ctx, iprsKey, 1)
+			if err != nil {
+				t.Fatalf("%s CA, %s child: %s", caType, childType, err)
+			}
+			entry, err = rec.Entry(iprsKey, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = f.Verify(ctx, iprsKey, entry)
+			if err != nil {
+				t.Fatalf("%s CA, %s child: %s", caType, childType, err)
+			}
+
+			// Record signed with a key that doesn't belong to the
+			// child cert FAIL
+			rec = f.NewEolCertRecord(path.Path("/ipfs/myIpfsHash"), childCert, caPk, eol)
+			entry, err = rec.Entry(iprsKey, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = f.Verify(ctx, iprsKey, entry)
+			if err == nil {
+				t.Fatalf("%s CA, %s child: expected signature error", caType, childType)
+			}
+		}
+	}
+}
+
+func TestCertChainVerification(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	r := mockrouting.NewServer().ClientWithDatastore(ctx, testutil.RandIdentityOrFatal(t), dstore)
+	f := NewRecordFactory(r, nil)
+	eol := time.Now().Add(time.Hour)
+
+	// Setup: root -> intermediate 1 -> intermediate 2 -> leaf
+	root := newRootCA(t, "root cert")
+	int1 := newIntermediateCA(t, root, "intermediate cert 1")
+	int2 := newIntermediateCA(t, int1, "intermediate cert 2")
+	leafCert, leafPk := issueCertificate(t, int2, "leaf cert")
+	rootCert, int1Cert, int2Cert := root.Cert, int1.Cert, int2.Cert
+	unrelatedCert := newRootCA(t, "unrelated cert").Cert
+
+	iprsKey := getIprsPathFromCert(t, rootCert, "/myIprsName")
+	publishEntry := func(intermediates ...*x509.Certificate) *pb.IprsEntry {
+		s := f.NewCertChainRecordSigner(leafCert, intermediates, leafPk)
+		rec := f.NewRecord(NewEolRecordValidity(eol), s, path.Path("/ipfs/myIpfsHash"))
+		// Publish the certificates but not the entry, which would
+		// fail the checks before publishing if it's not valid
+		entry, err := rec.Entry(iprsKey, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = rec.PublishVerification(ctx, iprsKey, entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return entry
+	}
+
+	// The root cert is not published by the signer
+	putCertificates(t, r, rootCert)
+
+	// Full chain SUCCESS
+	e := publishEntry(int2.Intermediates()...)
+	err := f.Verify(ctx, iprsKey, e)
 	if err != nil {
 		t.Fatal(err)
 	}
-	iprsKeyStr := "/iprs/" + certHash + relativePath
-	iprsKey, err := rsp.FromString(iprsKeyStr)
+	certHash, intermediates, err := CertParseVerification(e)
 	if err != nil {
 		t.Fatal(err)
 	}
-	return iprsKey
-}
+	if certHash != getIprsPathFromCert(t, leafCert, "").GetHashString() || len(intermediates) != 2 {
+		t.Fatal("Expected verification to contain leaf cert and two intermediates")
+	}
 
-func generateCACertificate(org string) (*x509.Certificate, *rsa.PrivateKey, error) {
-	return generateCertificate(org, nil, nil, true)
+	// Missing intermediate FAIL
+	e = publishEntry(int2Cert)
+	err = f.Verify(ctx, iprsKey, e)
+	if err == nil {
+		t.Fatal("Expected missing intermediate to fail verification")
+	}
+
+	// Intermediates in wrong order FAIL
+	e = publishEntry(int1Cert, int2Cert)
+	err = f.Verify(ctx, iprsKey, e)
+	if err == nil {
+		t.Fatal("Expected out of order intermediates to fail verification")
+	}
+
+	// Chain to a different root FAIL
+	e = publishEntry(int2Cert, int1Cert)
+	err = f.Verify(ctx, getIprsPathFromCert(t, unrelatedCert, "/myIprsName"), e)
+	if err == nil {
+		t.Fatal("Expected chain to different root to fail verification")
+	}
+
+	// Chain too long FAIL
+	var long []*x509.Certificate
+	for i := 0; i <= MaxCertChainLength; i++ {
+		long = append(long, int2Cert)
+	}
+	e = publishEntry(long...)
+	err = f.Verify(ctx, iprsKey, e)
+	if err != ErrCertChainLength {
+		t.Fatal("Expected chain length error")
+	}
 }
 
-func generateChildCertificate(org string, parent *x509.Certificate, parentKey *rsa.PrivateKey) (*x509.Certificate, *rsa.PrivateKey, error) {
-	return generateCertificate(org, parent, parentKey, false)
+func TestCertRecordPolicy(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	r := mockrouting.NewServer().ClientWithDatastore(ctx, testutil.RandIdentityOrFatal(t), dstore)
+	now := time.Now()
+	clk := clock.NewMockClock(now)
+	f := NewRecordFactory(r, clk)
+	eol := now.Add(time.Hour)
+
+	verify := func(root, cert *x509.Certificate, intermediates []*x509.Certificate, pk crypto.Signer, vl RecordValidity) error {
+		iprsKey := getIprsPathFromCert(t, root, "/myIprsName")
+		s := f.NewCertChainRecordSigner(cert, intermediates, pk)
+		rec := f.NewRecord(vl, s, path.Path("/ipfs/myIpfsHash"))
+		entry, err := rec.Entry(iprsKey, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = rec.PublishVerification(ctx, iprsKey, entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f.Verify(ctx, iprsKey, entry)
+	}
+
+	root := newRootCA(t, "root cert")
+	rootCert := root.Cert
+	putCertificates(t, r, rootCert)
+	leafCert, pk := issueCertificate(t, root, "leaf cert")
+
+	// Valid chain SUCCESS
+	err := verify(rootCert, leafCert, nil, pk, NewEolRecordValidity(eol))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Certificate not yet valid FAIL
+	clk.Set(leafCert.NotBefore.Add(-time.Minute))
+	err = verify(rootCert, leafCert, nil, pk, NewEolRecordValidity(eol))
+	if err != c.CertificateNotYetValidError {
+		t.Fatal("Expected certificate not yet valid error")
+	}
+
+	// Certificate expired FAIL
+	clk.Set(leafCert.NotAfter.Add(time.Minute))
+	err = verify(rootCert, leafCert, nil, pk, NewEolRecordValidity(leafCert.NotAfter.Add(time.Hour)))
+	if err != c.CertificateExpiredError {
+		t.Fatal("Expected certificate expired error")
+	}
+	clk.Set(now)
+
+	// Issuer that is not a CA FAIL
+	notCaCert, notCaPk := issueCertificate(t, root, "not ca cert")
+	childCert := createCertificate(t, certTemplate(t, leafCert), notCaCert, pk.Public(), notCaPk)
+	err = verify(rootCert, childCert, []*x509.Certificate{notCaCert}, pk, NewEolRecordValidity(eol))
+	if err != c.CertificateNotCAError {
+		t.Fatal("Expected not CA error")
+	}
+
+	// Chain longer than the root's path length FAIL
+	opts := certOptions("zero path root cert")
+	opts.MaxPathLenZero = true
+	zeroPath, err := c.NewRootCA(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putCertificates(t, r, zeroPath.Cert)
+	intCA := newIntermediateCA(t, zeroPath, "intermediate cert")
+	intChildCert, intChildPk := issueCertificate(t, intCA, "child cert")
+	err = verify(zeroPath.Cert, intChildCert, intCA.Intermediates(), intChildPk, NewEolRecordValidity(eol))
+	if err != c.CertificatePathLengthError {
+		t.Fatal("Expected path length error")
+	}
+
+	// Extended key usage without IPRS record signing FAIL
+	template := certTemplate(t, leafCert)
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	template.UnknownExtKeyUsage = nil
+	childCert = createCertificate(t, template, rootCert, pk.Public(), root.Key)
+	err = verify(rootCert, childCert, nil, pk, NewEolRecordValidity(eol))
+	if err != c.CertificateKeyUsageError {
+		t.Fatal("Expected key usage error")
+	}
+
+	// Extended key usage with IPRS record signing SUCCESS
+	template.UnknownExtKeyUsage = []asn1.ObjectIdentifier{c.ExtKeyUsageIprsRecordSigning}
+	childCert = createCertificate(t, template, rootCert, pk.Public(), root.Key)
+	err = verify(rootCert, childCert, nil, pk, NewEolRecordValidity(eol))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Record valid past certificate expiry SUCCESS
+	// (unless the validity is limited to the certificate)
+	pastExpiry := NewEolRecordValidity(leafCert.NotAfter.Add(time.Hour))
+	err = verify(rootCert, leafCert, nil, pk, pastExpiry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.SetLimitValidityToCert(true)
+
+	// Record valid past certificate expiry FAIL
+	err = verify(rootCert, leafCert, nil, pk, pastExpiry)
+	if err != ErrValidityPastCertExpiry {
+		t.Fatal("Expected validity past certificate expiry error")
+	}
+
+	// Record that never expires FAIL
+	start := now.Add(-time.Hour)
+	forever, err := NewRangeRecordValidity(&start, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = verify(rootCert, leafCert, nil, pk, forever)
+	if err != ErrValidityPastCertExpiry {
+		t.Fatal("Expected validity past certificate expiry error")
+	}
+
+	// Record that expires before the certificate SUCCESS
+	err = verify(rootCert, leafCert, nil, pk, NewEolRecordValidity(eol))
+	if err != nil {
+		t.Fatal(err)
+	}
 }
 
-func generateCertificate(org string, parent *x509.Certificate, parentKey *rsa.PrivateKey, isCA bool) (*x509.Certificate, *rsa.PrivateKey, error) {
-	template, err := newCertificate(org)
+func TestCertRecordRevocation(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	r := mockrouting.NewServer().ClientWithDatastore(ctx, testutil.RandIdentityOrFatal(t), dstore)
+	now := time.Now()
+	clk := clock.NewMockClock(now)
+	f := NewRecordFactory(r, clk)
+	rm := c.NewRevocationManager(r, nil, clk)
+	eol := now.Add(time.Hour)
+
+	ca := newRootCA(t, "ca cert")
+	intCA := newIntermediateCA(t, ca, "intermediate cert")
+	caCert, caPk := ca.Cert, ca.Key
+	intCert, intPk := intCA.Cert, intCA.Key
+	childCert, pk := issueCertificate(t, ca, "child cert")
+	otherChildCert, otherPk := issueCertificate(t, ca, "other child cert")
+	intChildCert, intChildPk := issueCertificate(t, intCA, "intermediate child cert")
+	putCertificates(t, r, caCert)
+
+	iprsKey := getIprsPathFromCert(t, caCert, "/myIprsName")
+	verify := func(cert *x509.Certificate, intermediates []*x509.Certificate, pk crypto.Signer) error {
+		s := f.NewCertChainRecordSigner(cert, intermediates, pk)
+		rec := f.NewRecord(NewEolRecordValidity(eol), s, path.Path("/ipfs/myIpfsHash"))
+		entry, err := rec.Entry(iprsKey, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = rec.PublishVerification(ctx, iprsKey, entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f.Verify(ctx, iprsKey, entry)
+	}
+
+	// No revocation list published SUCCESS
+	err := verify(childCert, nil, pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Revoke the child cert and the intermediate cert
+	crl, err := c.CreateRevocationList(caCert, caPk, []*x509.Certificate{childCert, intCert}, now, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = rm.PutRevocationList(ctx, crl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The absence of a revocation list is cached SUCCESS
+	err = verify(childCert, nil, pk)
 	if err != nil {
-		return nil, nil, err
+		t.Fatal(err)
+	}
+
+	clk.Add(c.DefaultRevocationListCacheTTL + time.Second)
+
+	// Revoked cert FAIL
+	err = verify(childCert, nil, pk)
+	if err != ErrCertRevoked {
+		t.Fatal("Expected revoked cert error")
 	}
 
-	if isCA {
-		template.IsCA = true
+	// Cert issued by revoked intermediate cert FAIL
+	err = verify(intChildCert, []*x509.Certificate{intCert}, intChildPk)
+	if err != ErrCertRevoked {
+		t.Fatal("Expected revoked cert error")
 	}
-	template.KeyUsage |= x509.KeyUsageCertSign
-	template.KeyUsage |= x509.KeyUsageKeyEncipherment
-	template.KeyUsage |= x509.KeyUsageKeyAgreement
 
-	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	// Cert that has not been revoked SUCCESS
+	err = verify(otherChildCert, nil, otherPk)
 	if err != nil {
-		return nil, nil, err
+		t.Fatal(err)
 	}
 
-	parentCertIprsKey := parentKey
-	if parentCertIprsKey == nil {
-		parentCertIprsKey = priv
+	// Revocation list must be signed by the cert whose hash is in the key
+	crlKey := "/crl/" + getIprsPathFromCert(t, caCert, "").GetHashString()
+	err = c.ValidateRevocationListRecord(crlKey, crl)
+	if err != nil {
+		t.Fatal(err)
 	}
-	parentCert := parent
-	if parentCert == nil {
-		parentCert = template
+	otherCrl, err := c.CreateRevocationList(intCert, intPk, []*x509.Certificate{intChildCert}, now, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.ValidateRevocationListRecord(crlKey, otherCrl)
+	if err == nil {
+		t.Fatal("Expected revocation list signed by a different cert to fail validation")
 	}
-	derBytes, err := x509.CreateCertificate(rand.Reader, template, parentCert, &priv.PublicKey, parentCertIprsKey)
+
+	// Selector picks the most recent revocation list
+	newerCrl, err := c.CreateRevocationList(caCert, caPk, []*x509.Certificate{childCert}, now.Add(time.Minute), now.Add(time.Hour))
 	if err != nil {
-		return nil, nil, err
+		t.Fatal(err)
+	}
+	i, err := c.RevocationListSelector(crlKey, [][]byte{crl, newerCrl})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i != 1 {
+		t.Fatal("Expected most recent revocation list to be selected")
 	}
 
-	cert, err := x509.ParseCertificate(derBytes)
+	// Revocation list past its next update FAIL
+	clk.Set(now.Add(time.Hour))
+	err = verify(otherChildCert, nil, otherPk)
+	if err != c.RevocationListStaleError {
+		t.Fatal("Expected stale revocation list error")
+	}
+
+	// Stale revocation list is used if allowed
+	f.SetAllowStaleRevocationLists(true)
+	err = verify(otherChildCert, nil, otherPk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = verify(childCert, nil, pk)
+	if err != ErrCertRevoked {
+		t.Fatal("Expected revoked cert error")
+	}
+}
+
+func TestCertRecordPathConstraints(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	r := mockrouting.NewServer().ClientWithDatastore(ctx, testutil.RandIdentityOrFatal(t), dstore)
+	f := NewRecordFactory(r, nil)
+	eol := time.Now().Add(time.Hour)
+
+	ca := newRootCA(t, "ca cert")
+	caCert, caPk := ca.Cert, ca.Key
+	opts := certOptions("team cert")
+	opts.PermittedPaths = []string{"/teamname"}
+	team, err := ca.NewIntermediateCA(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	teamCert, teamPk := team.Cert, team.Key
+	opts = certOptions("member cert")
+	opts.PermittedPaths = []string{"/teamname/member", "/othername"}
+	memberCert, pk, err := team.IssueCertificate(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verify := func(relativePath string, cert *x509.Certificate, intermediates []*x509.Certificate, pk crypto.Signer) error {
+		iprsKey := getIprsPathFromCert(t, caCert, relativePath)
+		s := f.NewCertChainRecordSigner(cert, intermediates, pk)
+		rec := f.NewRecord(NewEolRecordValidity(eol), s, path.Path("/ipfs/myIpfsHash"))
+		entry, err := rec.Entry(iprsKey, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = rec.PublishVerification(ctx, iprsKey, entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f.Verify(ctx, iprsKey, entry)
+	}
+
+	// Unconstrained CA cert can sign at any path SUCCESS
+	err = verify("/othername", caCert, nil, caPk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Paths within the team cert's constraints SUCCESS
+	for _, p := range []string{"/teamname", "/teamname/repo", "/teamname/repo/sub"} {
+		err = verify(p, teamCert, nil, teamPk)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Paths outside the team cert's constraints FAIL
+	for _, p := range []string{"", "/teamname2", "/othername/teamname"} {
+		err = verify(p, teamCert, nil, teamPk)
+		if err != c.CertificatePathConstraintError {
+			t.Fatalf("Expected path constraint error for %s", p)
+		}
+	}
+
+	// Path within the member cert's and the team cert's constraints SUCCESS
+	err = verify("/teamname/member/repo", memberCert, []*x509.Certificate{teamCert}, pk)
 	if err != nil {
-		return nil, nil, err
+		t.Fatal(err)
+	}
+
+	// Path within the team cert's constraints but not the member
+	// cert's constraints FAIL
+	err = verify("/teamname/repo", memberCert, []*x509.Certificate{teamCert}, pk)
+	if err != c.CertificatePathConstraintError {
+		t.Fatal("Expected path constraint error")
 	}
 
-	return cert, priv, nil
+	// Path within the member cert's constraints but not the team
+	// cert's constraints FAIL
+	err = verify("/othername", memberCert, []*x509.Certificate{teamCert}, pk)
+	if err != c.CertificatePathConstraintError {
+		t.Fatal("Expected path constraint error")
+	}
 }
 
-func newCertificate(org string) (*x509.Certificate, error) {
+func TestCertRecordAllowList(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	r := mockrouting.NewServer().ClientWithDatastore(ctx, testutil.RandIdentityOrFatal(t), dstore)
 	now := time.Now()
-	// need to set notBefore slightly in the past to account for time
-	// skew in the VMs otherwise the certs sometimes are not yet valid
-	notBefore := time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute()-5, 0, 0, time.Local)
-	notAfter := notBefore.Add(time.Hour * 24 * 1080)
+	clk := clock.NewMockClock(now)
+	f := NewRecordFactory(r, clk)
+	am := c.NewAllowListManager(r, nil, clk)
+	eol := now.Add(time.Hour)
+
+	ca := newRootCA(t, "ca cert")
+	caCert, caPk := ca.Cert, ca.Key
+	aliceCert, alicePk := issueCertificate(t, ca, "alice")
+	bobCert, bobPk := issueCertificate(t, ca, "bob")
+	team := newIntermediateCA(t, ca, "team")
+	teamCert, teamPk := team.Cert, team.Key
+	carolCert, carolPk := issueCertificate(t, team, "carol")
+	putCertificates(t, r, caCert)
+
+	iprsKey := getIprsPathFromCert(t, caCert, "/myIprsName")
+	verify := func(cert *x509.Certificate, intermediates []*x509.Certificate, pk crypto.Signer) error {
+		s := f.NewCertChainRecordSigner(cert, intermediates, pk)
+		rec := f.NewRecord(NewEolRecordValidity(eol), s, path.Path("/ipfs/myIpfsHash"))
+		entry, err := rec.Entry(iprsKey, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = rec.PublishVerification(ctx, iprsKey, entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f.Verify(ctx, iprsKey, entry)
+	}
+	publish := func(seq uint64, certs []*x509.Certificate, subjects []string) {
+		var hashes []string
+		for _, cert := range certs {
+			h, err := c.GetCertificateHash(cert)
+			if err != nil {
+				t.Fatal(err)
+			}
+			hashes = append(hashes, h)
+		}
+		l, err := c.CreateAllowList(caCert, caPk, seq, hashes, subjects)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = am.PutAllowList(ctx, l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Expire the verifier's cached allow list
+		clk.Add(c.DefaultAllowListCacheTTL + time.Second)
+	}
+
+	// No allow list published SUCCESS
+	err := verify(bobCert, nil, bobPk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publish(1, []*x509.Certificate{aliceCert}, nil)
+
+	// Cert on allow list SUCCESS
+	err = verify(aliceCert, nil, alicePk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Cert not on allow list FAIL
+	err = verify(bobCert, nil, bobPk)
+	if err != ErrCertNotAllowed {
+		t.Fatal("Expected cert not allowed error")
+	}
+
+	// Root cert is always allowed SUCCESS
+	err = verify(caCert, nil, caPk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Cert whose issuer is not on allow list FAIL
+	err = verify(carolCert, []*x509.Certificate{teamCert}, carolPk)
+	if err != ErrCertNotAllowed {
+		t.Fatal("Expected cert not allowed error")
+	}
+
+	// Allow bob by subject and carol's team by cert hash,
+	// and remove alice
+	publish(2, []*x509.Certificate{teamCert}, []string{bobCert.Subject.String()})
+
+	err = verify(bobCert, nil, bobPk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = verify(carolCert, []*x509.Certificate{teamCert}, carolPk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = verify(aliceCert, nil, alicePk)
+	if err != ErrCertNotAllowed {
+		t.Fatal("Expected cert not allowed error")
+	}
+
+	// Cert with an allowed subject issued by an intermediate that is not
+	// on the allow list FAIL
+	rogue := newIntermediateCA(t, ca, "rogue")
+	rogueBobCert, rogueBobPk := issueCertificate(t, rogue, "bob")
+	if rogueBobCert.Subject.String() != bobCert.Subject.String() {
+		t.Fatal("Expected rogue cert to have the same subject as bob's cert")
+	}
+	err = verify(rogueBobCert, []*x509.Certificate{rogue.Cert}, rogueBobPk)
+	if err != ErrCertNotAllowed {
+		t.Fatal("Expected cert not allowed error")
+	}
+
+	// Intermediate allowed by subject can issue certs SUCCESS
+	publish(3, nil, []string{teamCert.Subject.String()})
+	err = verify(carolCert, []*x509.Certificate{teamCert}, carolPk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Allow list must be signed by the cert whose hash is in the key
+	listKey := "/allowlist/" + getIprsPathFromCert(t, caCert, "").GetHashString()
+	l, err := c.CreateAllowList(teamCert, teamPk, 3, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.ValidateAllowListRecord(listKey, l)
+	if err == nil {
+		t.Fatal("Expected allow list signed by a different cert to fail validation")
+	}
+
+	// Selector picks the allow list with the highest sequence number
+	l1, err := c.CreateAllowList(caCert, caPk, 1, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l2, err := c.CreateAllowList(caCert, caPk, 2, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i, err := c.AllowListSelector(listKey, [][]byte{l2, l1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i != 0 {
+		t.Fatal("Expected allow list with highest sequence number to be selected")
+	}
+}
+
+func TestCertRecordTrustStore(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	r := mockrouting.NewServer().ClientWithDatastore(ctx, testutil.RandIdentityOrFatal(t), dstore)
+	f := NewRecordFactory(r, nil)
+	eol := time.Now().Add(time.Hour)
+
+	trusted := newRootCA(t, "trusted ca cert")
+	trustedCert := trusted.Cert
+	childCert, childPk := issueCertificate(t, trusted, "child cert")
+	untrusted := newRootCA(t, "untrusted ca cert")
+	untrustedCert, untrustedPk := untrusted.Cert, untrusted.Key
+
+	// Write the trusted cert to a PEM file in a directory
+	dir, err := ioutil.TempDir("", "iprs-trust")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pemBytes, err := c.MarshalCertificate(trustedCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "trusted.pem"), pemBytes, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "README"), []byte("not a cert"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trust, err := c.LoadTrustStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// File that doesn't contain a cert FAIL
+	_, err = c.LoadTrustStore(filepath.Join(dir, "README"))
+	if err == nil {
+		t.Fatal("Expected error loading file with no certificates")
+	}
+
+	entry := func(iprsKey rsp.IprsPath, rec *Record) *pb.IprsEntry {
+		e, err := rec.Entry(iprsKey, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = rec.PublishVerification(ctx, iprsKey, e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+
+	// Note that the trusted root cert is never published, so the pinned
+	// cert is used to verify records signed by the child cert
+	trustedKey := getIprsPathFromCert(t, trustedCert, "/myIprsName")
+	childEntry := entry(trustedKey, f.NewEolCertRecord(path.Path("/ipfs/myIpfsHash"), childCert, childPk, eol))
+	untrustedKey := getIprsPathFromCert(t, untrustedCert, "/myIprsName")
+	untrustedEntry := entry(untrustedKey, f.NewEolCertRecord(path.Path("/ipfs/myIpfsHash"), untrustedCert, untrustedPk, eol))
+	keyPk, _ := generateKeys(t, 1)
+	keyIprsKey := getIprsPathFromKey(t, keyPk[0])
+	keyEntry := entry(keyIprsKey, f.NewEolKeyRecord(path.Path("/ipfs/myIpfsHash"), keyPk[0], eol))
+
+	// Any root is trusted SUCCESS
+	f.SetTrustStore(trust, TrustAnyRoot)
+	err = f.Verify(ctx, trustedKey, childEntry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.Verify(ctx, untrustedKey, untrustedEntry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.Verify(ctx, keyIprsKey, keyEntry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only pinned roots are trusted
+	f.SetTrustStore(trust, TrustPinnedRoots)
+
+	// Record chaining to pinned root SUCCESS
+	err = f.Verify(ctx, trustedKey, childEntry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Record chaining to root that is not pinned FAIL
+	// (even though the record is otherwise valid)
+	err = f.Verify(ctx, untrustedKey, untrustedEntry)
+	if err != ErrUntrustedRoot {
+		t.Fatal("Expected untrusted root error")
+	}
+
+	// Record signed with a key FAIL
+	err = f.Verify(ctx, keyIprsKey, keyEntry)
+	if err != ErrUntrustedRoot {
+		t.Fatal("Expected untrusted root error")
+	}
+}
+
+func TestCertRecordDatastoreCache(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	r := mockrouting.NewServer().ClientWithDatastore(ctx, testutil.RandIdentityOrFatal(t), dstore)
+	cacheStore := dssync.MutexWrap(ds.NewMapDatastore())
+	f := NewRecordFactoryWithDatastore(r, cacheStore, nil)
+	eol := time.Now().Add(time.Hour)
+
+	ca := newRootCA(t, "ca cert")
+	putCertificates(t, r, ca.Cert)
+	childCert, childPk := issueCertificate(t, ca, "child cert")
+	iprsKey := getIprsPathFromCert(t, ca.Cert, "/myIprsName")
+	rec := f.NewEolCertRecord(path.Path("/ipfs/myIpfsHash"), childCert, childPk, eol)
+	e, err := rec.Entry(iprsKey, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rec.PublishVerification(ctx, iprsKey, e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Verifying fetches the certificates, so that they're cached in the
+	// datastore
+	err = f.Verify(ctx, iprsKey, e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Remove the certificates from routing
+	for _, cert := range []*x509.Certificate{ca.Cert, childCert} {
+		certHash, err := c.GetCertificateHash(cert)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = dstore.Delete(dshelp.NewKeyFromBinary([]byte("/" + c.CertType + "/" + certHash)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
 
-	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
-	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
+	// Certificates are not in routing FAIL
+	err = NewRecordFactory(r, nil).Verify(ctx, iprsKey, e)
+	if err == nil {
+		t.Fatal("Expected verification to fail without certificates")
+	}
+
+	// Certificates are cached in the datastore SUCCESS
+	err = NewRecordFactoryWithDatastore(r, cacheStore, nil).Verify(ctx, iprsKey, e)
 	if err != nil {
-		return nil, err
+		t.Fatal(err)
+	}
+}
+
+// putCertificates puts certificates that are not published by record
+// signers, eg root certificates
+func putCertificates(t *testing.T, r routing.ValueStore, certs ...*x509.Certificate) {
+	m := c.NewCertificateManager(r, nil)
+	for _, cert := range certs {
+		_, err := m.PutCertificate(context.Background(), cert)
+		if err != nil {
+			t.Fatal(err)
+		}
 	}
+}
 
+func getIprsPathFromCert(t *testing.T, cert *x509.Certificate, relativePath string) rsp.IprsPath {
+	certHash, err := c.GetCertificateHash(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iprsKeyStr := "/iprs/" + certHash + relativePath
+	iprsKey, err := rsp.FromString(iprsKeyStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return iprsKey
+}
+
+func certOptions(org string) c.CertificateOptions {
+	return c.CertificateOptions{Subject: pkix.Name{Organization: []string{org}}}
+}
+
+func newRootCA(t *testing.T, org string) *c.CertificateAuthority {
+	ca, err := c.NewRootCA(certOptions(org))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca
+}
+
+func newIntermediateCA(t *testing.T, issuer *c.CertificateAuthority, org string) *c.CertificateAuthority {
+	ca, err := issuer.NewIntermediateCA(certOptions(org))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca
+}
+
+func issueCertificate(t *testing.T, issuer *c.CertificateAuthority, org string) (*x509.Certificate, crypto.Signer) {
+	cert, pk, err := issuer.IssueCertificate(certOptions(org))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, pk
+}
+
+// certTemplate copies a certificate into a template with a new serial
+// number, so that tests can create certificates that a
+// CertificateAuthority would not issue
+func certTemplate(t *testing.T, cert *x509.Certificate) *x509.Certificate {
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		t.Fatal(err)
+	}
 	return &x509.Certificate{
-		SerialNumber: serialNumber,
-		Subject: pkix.Name{
-			Organization: []string{org},
-		},
-		NotBefore: notBefore,
-		NotAfter:  notAfter,
-
-		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature | x509.KeyUsageKeyAgreement,
+		SerialNumber:          serialNumber,
+		Subject:               cert.Subject,
+		NotBefore:             cert.NotBefore,
+		NotAfter:              cert.NotAfter,
+		KeyUsage:              cert.KeyUsage,
+		ExtKeyUsage:           cert.ExtKeyUsage,
+		UnknownExtKeyUsage:    cert.UnknownExtKeyUsage,
 		BasicConstraintsValid: true,
-	}, nil
+	}
+}
 
+func createCertificate(t *testing.T, template, parent *x509.Certificate, pub crypto.PublicKey, parentKey crypto.Signer) *x509.Certificate {
+	derBytes, err := x509.CreateCertificate(rand.Reader, template, parent, pub, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(derBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
 }
@@ -0,0 +1,300 @@
+package iprs_record
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	clock "github.com/dirkmc/go-iprs/clock"
+	rsp "github.com/dirkmc/go-iprs/path"
+	pb "github.com/dirkmc/go-iprs/pb"
+	proto "gx/ipfs/QmZ4Qi3GaRbjcx28Sme5eMH7RQjGkt8wHxt2a65oLaeFEV/gogo-protobuf/proto"
+)
+
+// MaxCompositeDepth is the maximum nesting depth of Composite validity
+// clauses within a Composite record
+const MaxCompositeDepth = 8
+
+// ErrEmptyComposite should be returned when an attempt is made to
+// construct a Composite record with no clauses
+var ErrEmptyComposite = errors.New("composite validity has no clauses")
+
+// ErrCompositeDepth should be returned when Composite clauses are
+// nested more than MaxCompositeDepth deep
+var ErrCompositeDepth = errors.New("composite validity nested too deeply")
+
+// CompositeRecordValidity combines other RecordValidity clauses, eg
+// "valid between x and y AND until z". Clauses can only depend on time,
+// not on other entries: there is no clause for "valid until sequence x
+// is superseded", because an entry with a higher sequence number always
+// replaces it when records are selected.
+type CompositeRecordValidity struct {
+	op      pb.CompositeValidity_Operator
+	clauses []RecordValidity
+}
+
+// NewAndRecordValidity creates a validity that is valid when all of
+// the clauses are valid
+func NewAndRecordValidity(clauses ...RecordValidity) (*CompositeRecordValidity, error) {
+	return newCompositeRecordValidity(pb.CompositeValidity_And, clauses)
+}
+
+// NewOrRecordValidity creates a validity that is valid when any of
+// the clauses are valid
+func NewOrRecordValidity(clauses ...RecordValidity) (*CompositeRecordValidity, error) {
+	return newCompositeRecordValidity(pb.CompositeValidity_Or, clauses)
+}
+
+func newCompositeRecordValidity(op pb.CompositeValidity_Operator, clauses []RecordValidity) (*CompositeRecordValidity, error) {
+	if len(clauses) == 0 {
+		return nil, ErrEmptyComposite
+	}
+	return &CompositeRecordValidity{op, clauses}, nil
+}
+
+func (v *CompositeRecordValidity) Validity() ([]byte, error) {
+	cv := &pb.CompositeValidity{
+		Operator: v.op.Enum(),
+	}
+	for _, c := range v.clauses {
+		validity, err := c.Validity()
+		if err != nil {
+			return nil, err
+		}
+		cv.Clauses = append(cv.Clauses, &pb.CompositeValidity_Clause{
+			ValidityType: c.ValidityType(),
+			Validity:     validity,
+		})
+	}
+	return proto.Marshal(cv)
+}
+
+func (v *CompositeRecordValidity) ValidityType() *pb.IprsEntry_ValidityType {
+	t := pb.IprsEntry_Composite
+	return &t
+}
+
+// compositeRecordChecker
+
+type compositeRecordChecker struct {
+	clock     clock.Clock
+	tolerance SkewTolerance
+}
+
+// NewCompositeRecordChecker creates a RecordChecker for Composite
+// records that checks each clause against the given clock, allowing
+// for the given amount of clock skew
+func NewCompositeRecordChecker(clk clock.Clock, tolerance SkewTolerance) *compositeRecordChecker {
+	return &compositeRecordChecker{clock.OrRealClock(clk), tolerance}
+}
+
+func (v *compositeRecordChecker) ValidateRecord(iprsKey rsp.IprsPath, entry *pb.IprsEntry) error {
+	return CompositeValidityCheck(entry, v.clock.Now(), v.tolerance)
+}
+
+// CompositeValidityCheck checks that the entry's clauses are valid at
+// the given time
+func CompositeValidityCheck(entry *pb.IprsEntry, now time.Time, tolerance SkewTolerance) error {
+	return clauseValidityCheck(entry, now, tolerance, 0)
+}
+
+func compositeValidityCheck(entry *pb.IprsEntry, cv *pb.CompositeValidity, now time.Time, tolerance SkewTolerance, depth int) error {
+	var errs []error
+	for _, c := range cv.GetClauses() {
+		err := clauseValidityCheck(clauseEntry(entry, c), now, tolerance, depth+1)
+		if cv.GetOperator() == pb.CompositeValidity_And && err != nil {
+			return err
+		}
+		if cv.GetOperator() == pb.CompositeValidity_Or && err == nil {
+			return nil
+		}
+		errs = append(errs, err)
+	}
+
+	if cv.GetOperator() == pb.CompositeValidity_And {
+		return nil
+	}
+
+	// None of the Or clauses are valid. If they are all just expired
+	// or not yet valid, say so, otherwise return the first other error.
+	pending := false
+	for _, err := range errs {
+		if err == ErrPendingRecord {
+			pending = true
+		} else if err != ErrExpiredRecord {
+			return err
+		}
+	}
+	if pending {
+		return ErrPendingRecord
+	}
+	return ErrExpiredRecord
+}
+
+func clauseValidityCheck(ce *pb.IprsEntry, now time.Time, tolerance SkewTolerance, depth int) error {
+	switch ce.GetValidityType() {
+	case pb.IprsEntry_EOL:
+		return EolValidityCheck(ce, now, tolerance)
+	case pb.IprsEntry_TimeRange:
+		return RangeValidityCheck(ce, now, tolerance)
+	case pb.IprsEntry_Recurring:
+		return RecurringValidityCheck(ce, now, tolerance)
+	case pb.IprsEntry_MultiRange:
+		return MultiRangeValidityCheck(ce, now, tolerance)
+	case pb.IprsEntry_Composite:
+		if depth >= MaxCompositeDepth {
+			return ErrCompositeDepth
+		}
+		cv, err := CompositeParseValidity(ce)
+		if err != nil {
+			log.Warning("Failed to parse IPRS Composite record")
+			return err
+		}
+		return compositeValidityCheck(ce, cv, now, tolerance, depth)
+	}
+	return fmt.Errorf("Unrecognized validity type %s in composite clause", ce.GetValidityType().String())
+}
+
+// clauseEntry returns a copy of the entry with the validity replaced
+// by the clause's validity, so that clauses can be checked in the same
+// way as the entry itself
+func clauseEntry(entry *pb.IprsEntry, c *pb.CompositeValidity_Clause) *pb.IprsEntry {
+	ce := *entry
+	t := c.GetValidityType()
+	ce.ValidityType = &t
+	ce.Validity = c.GetValidity()
+	return &ce
+}
+
+func CompositeParseValidity(r *pb.IprsEntry) (*pb.CompositeValidity, error) {
+	cv := new(pb.CompositeValidity)
+	err := proto.Unmarshal(r.GetValidity(), cv)
+	if err != nil {
+		return nil, err
+	}
+	if len(cv.GetClauses()) == 0 {
+		return nil, ErrEmptyComposite
+	}
+	return cv, nil
+}
+
+// CompositeClauseEntries returns a copy of the entry for each of its
+// clauses, with the validity replaced by the clause's validity
+func CompositeClauseEntries(r *pb.IprsEntry) ([]*pb.IprsEntry, error) {
+	cv, err := CompositeParseValidity(r)
+	if err != nil {
+		return nil, err
+	}
+	var entries []*pb.IprsEntry
+	for _, c := range cv.GetClauses() {
+		entries = append(entries, clauseEntry(r, c))
+	}
+	return entries, nil
+}
+
+// CompositeTimeBounds returns the earliest time at which the entry could
+// be valid and the latest time at which the entry could be valid.
+// A nil start time means the beginning of time and a nil end time
+// means the end of time.
+func CompositeTimeBounds(r *pb.IprsEntry) (*[2]*time.Time, error) {
+	return clauseTimeBounds(r, 0)
+}
+
+func clauseTimeBounds(ce *pb.IprsEntry, depth int) (*[2]*time.Time, error) {
+	switch ce.GetValidityType() {
+	case pb.IprsEntry_EOL:
+		eol, err := EolParseValidity(ce)
+		if err != nil {
+			return nil, err
+		}
+		return &[2]*time.Time{nil, &eol}, nil
+	case pb.IprsEntry_TimeRange:
+		return RangeParseValidity(ce)
+	case pb.IprsEntry_Recurring:
+		rv, err := RecurringParseValidity(ce)
+		if err != nil {
+			return nil, err
+		}
+		return rv.Range, nil
+	case pb.IprsEntry_MultiRange:
+		return MultiRangeTimeBounds(ce)
+	case pb.IprsEntry_Composite:
+		if depth >= MaxCompositeDepth {
+			return nil, ErrCompositeDepth
+		}
+		cv, err := CompositeParseValidity(ce)
+		if err != nil {
+			return nil, err
+		}
+
+		var bounds *[2]*time.Time
+		for _, c := range cv.GetClauses() {
+			b, err := clauseTimeBounds(clauseEntry(ce, c), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			if bounds == nil {
+				bounds = b
+				continue
+			}
+			// The entry is valid for the intersection of And clauses
+			// and the union of Or clauses
+			and := cv.GetOperator() == pb.CompositeValidity_And
+			if (compareTimes(b[0], bounds[0], -1) > 0) == and {
+				bounds[0] = b[0]
+			}
+			if (compareTimes(b[1], bounds[1], 1) < 0) == and {
+				bounds[1] = b[1]
+			}
+		}
+		return bounds, nil
+	}
+	return nil, fmt.Errorf("Unrecognized validity type %s in composite clause", ce.GetValidityType().String())
+}
+
+func (v *compositeRecordChecker) SelectRecord(recs []*pb.IprsEntry, vals [][]byte) (int, error) {
+	var best_seq uint64
+	best_i := -1
+
+	for i, r := range recs {
+		// Best record is the one with the highest sequence number
+		if r == nil || r.GetSequence() < best_seq {
+			continue
+		}
+
+		if best_i == -1 || r.GetSequence() > best_seq {
+			best_seq = r.GetSequence()
+			best_i = i
+		} else if r.GetSequence() == best_seq {
+			// If sequence number is equal, compare the overall time
+			// range in which the records could be valid
+			t, err := CompositeTimeBounds(r)
+			if err != nil {
+				continue
+			}
+
+			bestt, err := CompositeTimeBounds(recs[best_i])
+			if err != nil {
+				continue
+			}
+
+			cmp := CompareTimeRanges(t, bestt)
+			if cmp > 0 {
+				best_i = i
+			} else if cmp == 0 {
+				// This is just to make sure the selection is deterministic
+				if bytes.Compare(vals[i], vals[best_i]) > 0 {
+					best_i = i
+				}
+			}
+		}
+	}
+	if best_i == -1 {
+		return 0, errors.New("no usable records in given set")
+	}
+
+	return best_i, nil
+}
+
+var CompositeRecordChecker = NewCompositeRecordChecker(clock.RealClock, SkewTolerance{})
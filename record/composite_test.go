@@ -0,0 +1,181 @@
+package iprs_record
+
+import (
+	"testing"
+	"time"
+
+	clock "github.com/dirkmc/go-iprs/clock"
+	rsp "github.com/dirkmc/go-iprs/path"
+	pb "github.com/dirkmc/go-iprs/pb"
+	u "github.com/ipfs/go-ipfs-util"
+	path "github.com/ipfs/go-ipfs/path"
+	ci "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
+)
+
+// This is just so we can get an IprsEntry for a given sequence number and validity
+func setupNewCompositeRecordFunc(t *testing.T) func(uint64, RecordValidity) *pb.IprsEntry {
+	// generate a key for signing the records
+	sr := u.NewSeededRand(15) // generate deterministic keypair
+	pk, _, err := ci.GenerateKeyPairWithReader(ci.RSA, 1024, sr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := NewRecordFactory(nil, nil)
+	iprsKey := getIprsPathFromKey(t, pk)
+
+	return func(seq uint64, vl RecordValidity) *pb.IprsEntry {
+		e, err := f.NewRecord(vl, f.NewKeyRecordSigner(pk), path.Path("foo")).Entry(iprsKey, seq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+}
+
+func newAnd(t *testing.T, clauses ...RecordValidity) *CompositeRecordValidity {
+	vl, err := NewAndRecordValidity(clauses...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return vl
+}
+
+func newOr(t *testing.T, clauses ...RecordValidity) *CompositeRecordValidity {
+	vl, err := NewOrRecordValidity(clauses...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return vl
+}
+
+func newRange(t *testing.T, start, end *time.Time) *RangeRecordValidity {
+	vl, err := NewRangeRecordValidity(start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return vl
+}
+
+func TestNewCompositeRecord(t *testing.T) {
+	_, err := NewAndRecordValidity()
+	if err != ErrEmptyComposite {
+		t.Fatal("Expected empty composite error")
+	}
+	_, err = NewOrRecordValidity()
+	if err != ErrEmptyComposite {
+		t.Fatal("Expected empty composite error")
+	}
+}
+
+func TestCompositeValidation(t *testing.T) {
+	NewRecord := setupNewCompositeRecordFunc(t)
+	ts := time.Unix(1000000, 0)
+	clk := clock.NewMockClock(ts)
+	ValidateRecord := NewCompositeRecordChecker(clk, SkewTolerance{}).ValidateRecord
+
+	OneHourAgo := ts.Add(time.Hour * -1)
+	InOneHour := ts.Add(time.Hour)
+	InTwoHours := ts.Add(time.Hour * 2)
+
+	current := newRange(t, &OneHourAgo, &InOneHour)
+	pending := newRange(t, &InOneHour, &InTwoHours)
+	expired := NewEolRecordValidity(OneHourAgo)
+	valid := NewEolRecordValidity(InTwoHours)
+
+	iprsKey, err := rsp.FromString("/iprs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// And is valid if all clauses are valid
+	err = ValidateRecord(iprsKey, NewRecord(1, newAnd(t, current, valid)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ValidateRecord(iprsKey, NewRecord(1, newAnd(t, current, expired)))
+	if err != ErrExpiredRecord {
+		t.Fatal("Expected expired error")
+	}
+	err = ValidateRecord(iprsKey, NewRecord(1, newAnd(t, pending, valid)))
+	if err != ErrPendingRecord {
+		t.Fatal("Expected pending error")
+	}
+
+	// Or is valid if any clause is valid
+	err = ValidateRecord(iprsKey, NewRecord(1, newOr(t, expired, current)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ValidateRecord(iprsKey, NewRecord(1, newOr(t, expired, pending)))
+	if err != ErrPendingRecord {
+		t.Fatal("Expected pending error")
+	}
+	err = ValidateRecord(iprsKey, NewRecord(1, newOr(t, expired, expired)))
+	if err != ErrExpiredRecord {
+		t.Fatal("Expected expired error")
+	}
+
+	// Clauses can be nested
+	nested := NewRecord(1, newAnd(t, valid, newOr(t, expired, current)))
+	err = ValidateRecord(iprsKey, nested)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Move the clock so that the pending range is current
+	clk.Add(time.Hour + time.Second)
+	err = ValidateRecord(iprsKey, nested)
+	if err != ErrExpiredRecord {
+		t.Fatal("Expected expired error")
+	}
+	err = ValidateRecord(iprsKey, NewRecord(1, newAnd(t, pending, valid)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Clauses cannot be nested too deeply
+	var deep RecordValidity = valid
+	for i := 0; i < MaxCompositeDepth+1; i++ {
+		deep = newAnd(t, deep)
+	}
+	err = ValidateRecord(iprsKey, NewRecord(1, deep))
+	if err != ErrCompositeDepth {
+		t.Fatal("Expected composite depth error")
+	}
+}
+
+func TestCompositeOrdering(t *testing.T) {
+	NewRecord := setupNewCompositeRecordFunc(t)
+
+	// select timestamp so selection is deterministic
+	ts := time.Unix(1000000, 0)
+	OneHourAgo := ts.Add(time.Hour * -1)
+	InOneHour := ts.Add(time.Hour)
+	InTwoHours := ts.Add(time.Hour * 2)
+	InThreeHours := ts.Add(time.Hour * 3)
+
+	e1 := NewRecord(1, newAnd(t, newRange(t, &ts, &InThreeHours), NewEolRecordValidity(InOneHour)))
+	e2 := NewRecord(2, newAnd(t, newRange(t, &ts, &InThreeHours), NewEolRecordValidity(InOneHour)))
+	e3 := NewRecord(2, newAnd(t, newRange(t, &ts, &InThreeHours), NewEolRecordValidity(InTwoHours)))
+	e4 := NewRecord(2, newOr(t, newRange(t, &ts, &InOneHour), NewEolRecordValidity(InThreeHours)))
+	e5 := NewRecord(2, newOr(t, newRange(t, &OneHourAgo, nil), NewEolRecordValidity(InOneHour)))
+
+	// e1 is the only record
+	assertCompositeSelected(t, e1, e1)
+	// e2 has the highest sequence number
+	assertCompositeSelected(t, e2, e1, e2)
+	// e3 is valid until a later time (the And of its clauses)
+	assertCompositeSelected(t, e3, e1, e2, e3)
+	// e4 is valid until a later time (the Or of its clauses)
+	assertCompositeSelected(t, e4, e1, e2, e3, e4)
+	// e5 is valid until the end of time (the Or of its clauses)
+	assertCompositeSelected(t, e5, e1, e2, e3, e4, e5)
+}
+
+func assertCompositeSelected(t *testing.T, r *pb.IprsEntry, from ...*pb.IprsEntry) {
+	err := AssertSelected(CompositeRecordChecker.SelectRecord, r, from)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
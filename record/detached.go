@@ -0,0 +1,83 @@
+package iprs_record
+
+import (
+	"bytes"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	rsp "github.com/dirkmc/go-iprs/path"
+	pb "github.com/dirkmc/go-iprs/pb"
+	proto "gx/ipfs/QmZ4Qi3GaRbjcx28Sme5eMH7RQjGkt8wHxt2a65oLaeFEV/gogo-protobuf/proto"
+)
+
+const detachedEntryPemType = "IPRS ENTRY"
+const detachedEntryKeyHeader = "IPRS-Key"
+
+// DetachedEntry is an entry together with the IPRS key that it will be
+// published at, so that it can be moved between machines to be signed,
+// eg to a machine that holds an offline private key:
+// On the online machine, create an unsigned entry with
+// Record.UnsignedEntry() and Export() it. On the offline machine,
+// ImportDetachedEntry(), sign it with SignDetachedEntry() and Export()
+// it again. Back on the online machine, ImportDetachedEntry() and
+// publish the signed entry with the Publisher's PublishEntry().
+type DetachedEntry struct {
+	IprsKey rsp.IprsPath
+	Entry   *pb.IprsEntry
+}
+
+// Export encodes the entry as a PEM block with the IPRS key in a header
+func (d *DetachedEntry) Export() ([]byte, error) {
+	data, err := proto.Marshal(d.Entry)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := new(bytes.Buffer)
+	err = pem.Encode(buf, &pem.Block{
+		Type:    detachedEntryPemType,
+		Headers: map[string]string{detachedEntryKeyHeader: d.IprsKey.String()},
+		Bytes:   data,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ImportDetachedEntry decodes an entry encoded with Export
+func ImportDetachedEntry(data []byte) (*DetachedEntry, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != detachedEntryPemType {
+		return nil, errors.New("Could not decode detached IPRS entry")
+	}
+
+	iprsKey, err := rsp.FromString(block.Headers[detachedEntryKeyHeader])
+	if err != nil {
+		return nil, err
+	}
+
+	entry := new(pb.IprsEntry)
+	err = proto.Unmarshal(block.Bytes, entry)
+	if err != nil {
+		return nil, fmt.Errorf("Could not unmarshal detached IPRS entry: %s", err)
+	}
+
+	return &DetachedEntry{iprsKey, entry}, nil
+}
+
+// SignDetachedEntry signs the entry with the given signer, which must
+// have the same verification data as the record the entry was created
+// from
+func SignDetachedEntry(s RecordSigner, d *DetachedEntry) error {
+	verification, err := s.Verification()
+	if err != nil {
+		return err
+	}
+	if d.Entry.GetVerificationType() != *s.VerificationType() || !bytes.Equal(d.Entry.GetVerification(), verification) {
+		return ErrEntryMismatch
+	}
+
+	return s.SignRecord(d.IprsKey, d.Entry)
+}
@@ -0,0 +1,116 @@
+package iprs_record
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "github.com/dirkmc/go-iprs/pb"
+	path "github.com/ipfs/go-ipfs/path"
+	mockrouting "github.com/ipfs/go-ipfs/routing/mock"
+	proto "gx/ipfs/QmZ4Qi3GaRbjcx28Sme5eMH7RQjGkt8wHxt2a65oLaeFEV/gogo-protobuf/proto"
+	ds "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore"
+	dssync "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore/sync"
+	testutil "gx/ipfs/QmeDA8gNhvRTsbrjEieay5wezupJDiky8xvCzDABbsGzmp/go-testutil"
+)
+
+func TestDetachedSigning(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	r := mockrouting.NewServer().ClientWithDatastore(ctx, testutil.RandIdentityOrFatal(t), dstore)
+	f := NewRecordFactory(r, nil)
+	pks, pubks := generateKeys(t, 2)
+	pk, otherpk := pks[0], pks[1]
+	iprsKey := getIprsPathFromKey(t, pk)
+	eol := time.Now().Add(time.Hour)
+
+	// Online machine: create the record with only the public key
+	s := f.NewPublicKeyRecordSigner(pubks[0])
+	rec := f.NewRecord(NewEolRecordValidity(eol), s, path.Path("/ipfs/myIpfsHash"))
+
+	// Signing without the private key FAIL
+	_, err := rec.Entry(iprsKey, 1)
+	if err != ErrNoPrivateKey {
+		t.Fatal("Expected no private key error")
+	}
+
+	unsigned, err := rec.UnsignedEntry(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported, err := (&DetachedEntry{iprsKey, unsigned}).Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Publishing the unsigned entry FAIL
+	err = rec.PublishEntry(ctx, iprsKey, unsigned, f)
+	if err == nil {
+		t.Fatal("Expected unsigned entry to fail verification")
+	}
+
+	// Offline machine: sign the entry with the private key
+	d, err := ImportDetachedEntry(exported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.IprsKey.String() != iprsKey.String() {
+		t.Fatal("Expected imported IPRS key to match exported key")
+	}
+
+	// Signing with a different key FAIL
+	err = SignDetachedEntry(f.NewKeyRecordSigner(otherpk), d)
+	if err != ErrEntryMismatch {
+		t.Fatal("Expected entry mismatch error")
+	}
+
+	err = SignDetachedEntry(f.NewKeyRecordSigner(pk), d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported, err = d.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Online machine: publish the signed entry
+	d, err = ImportDetachedEntry(exported)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Publishing with a record for a different key FAIL
+	otherRec := f.NewEolKeyRecord(path.Path("/ipfs/myIpfsHash"), otherpk, eol)
+	err = otherRec.PublishEntry(ctx, iprsKey, d.Entry, f)
+	if err != ErrEntryMismatch {
+		t.Fatal("Expected entry mismatch error")
+	}
+
+	err = rec.PublishEntry(ctx, iprsKey, d.Entry, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eBytes, err := r.GetValue(ctx, iprsKey.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := new(pb.IprsEntry)
+	err = proto.Unmarshal(eBytes, entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(entry.GetValue()) != "/ipfs/myIpfsHash" || entry.GetSequence() != 1 {
+		t.Fatal("Expected published entry to match signed entry")
+	}
+	err = f.Verify(ctx, iprsKey, entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Garbage data FAIL
+	_, err = ImportDetachedEntry([]byte("not an entry"))
+	if err == nil {
+		t.Fatal("Expected import error")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"time"
+	clock "github.com/dirkmc/go-iprs/clock"
 	pb "github.com/dirkmc/go-iprs/pb"
 	rsp "github.com/dirkmc/go-iprs/path"
 	u "github.com/ipfs/go-ipfs-util"
@@ -30,23 +31,34 @@ func (v *EolRecordValidity) ValidityType() *pb.IprsEntry_ValidityType {
 	return &t
 }
 
-type eolRecordChecker struct {}
+type eolRecordChecker struct {
+	clock     clock.Clock
+	tolerance SkewTolerance
+}
+
+// NewEolRecordChecker creates a RecordChecker for EOL records that
+// checks expiry against the given clock, allowing for the given
+// amount of clock skew
+func NewEolRecordChecker(clk clock.Clock, tolerance SkewTolerance) *eolRecordChecker {
+	return &eolRecordChecker{ clock.OrRealClock(clk), tolerance }
+}
 
 func (v *eolRecordChecker) SelectRecord(recs []*pb.IprsEntry, vals [][]byte) (int, error) {
 	return EolSelectRecord(recs, vals)
 }
 
 func (v *eolRecordChecker) ValidateRecord(iprsKey rsp.IprsPath, entry *pb.IprsEntry) error {
-	return EolValidityCheck(entry)
+	return EolValidityCheck(entry, v.clock.Now(), v.tolerance)
 }
 
-func EolValidityCheck(entry *pb.IprsEntry) error {
+// EolValidityCheck checks that the entry has not expired at the given time
+func EolValidityCheck(entry *pb.IprsEntry, now time.Time, tolerance SkewTolerance) error {
 	t, err := EolParseValidity(entry)
 	if err != nil {
 		log.Warningf("Failed to parse time from IPRS record EOL [%s]", entry.GetValidity())
 		return err
 	}
-	if time.Now().After(t) {
+	if tolerance.isExpired(t, now) {
 		return ErrExpiredRecord
 	}
 	return nil
@@ -95,4 +107,4 @@ func EolSelectRecord(recs []*pb.IprsEntry, vals [][]byte) (int, error) {
 	return best_i, nil
 }
 
-var EolRecordChecker = &eolRecordChecker{}
+var EolRecordChecker = NewEolRecordChecker(clock.RealClock, SkewTolerance{})
@@ -4,6 +4,7 @@ import (
 	"testing"
 	"time"
 	path "github.com/ipfs/go-ipfs/path"
+	clock "github.com/dirkmc/go-iprs/clock"
 	pb "github.com/dirkmc/go-iprs/pb"
 	u "github.com/ipfs/go-ipfs-util"
 	ci "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
@@ -19,10 +20,11 @@ func setupNewEolRecordFunc(t *testing.T) (func(uint64, time.Time) *pb.IprsEntry)
 		t.Fatal(err)
 	}
 
-	f := NewRecordFactory(nil)
+	f := NewRecordFactory(nil, nil)
+	iprsKey := getIprsPathFromKey(t, pk)
 
 	return func(seq uint64, eol time.Time) *pb.IprsEntry {
-		e, err := f.NewEolKeyRecord(path.Path("foo"), pk, eol).Entry(seq)
+		e, err := f.NewEolKeyRecord(path.Path("foo"), pk, eol).Entry(iprsKey, seq)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -66,14 +68,14 @@ func assertEolSelected(t *testing.T, r *pb.IprsEntry, from ...*pb.IprsEntry) {
 
 func TestEolValidation(t *testing.T) {
 	NewRecord := setupNewEolRecordFunc(t)
-	ValidateRecord := EolRecordChecker.ValidateRecord
+	ts := time.Unix(1000000, 0)
+	clk := clock.NewMockClock(ts)
+	ValidateRecord := NewEolRecordChecker(clk, SkewTolerance{}).ValidateRecord
 	iprsKey, err := rsp.FromString("/iprs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	ts := time.Now()
-
 	e1 := NewRecord(1, ts.Add(time.Hour * -1))
 	e2 := NewRecord(1, ts.Add(time.Hour))
 
@@ -86,4 +88,34 @@ func TestEolValidation(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+
+	// Move the clock beyond the EOL
+	clk.Add(time.Hour + time.Second)
+	err = ValidateRecord(iprsKey, e2)
+	if err != ErrExpiredRecord {
+		t.Fatal("Expected expired error")
+	}
+}
+
+func TestEolValidationSkewTolerance(t *testing.T) {
+	NewRecord := setupNewEolRecordFunc(t)
+	ts := time.Unix(1000000, 0)
+	tolerance := SkewTolerance{Expired: time.Second * 30}
+	ValidateRecord := NewEolRecordChecker(clock.NewMockClock(ts), tolerance).ValidateRecord
+	iprsKey, err := rsp.FromString("/iprs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Expired within the tolerance OK
+	err = ValidateRecord(iprsKey, NewRecord(1, ts.Add(time.Second*-10)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Expired beyond the tolerance FAIL
+	err = ValidateRecord(iprsKey, NewRecord(1, ts.Add(time.Second*-31)))
+	if err != ErrExpiredRecord {
+		t.Fatal("Expected expired error")
+	}
 }
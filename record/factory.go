@@ -2,60 +2,219 @@ package iprs_record
 
 import (
 	"context"
-	"crypto/rsa"
+	"crypto"
 	"crypto/x509"
 	"fmt"
 	c "github.com/dirkmc/go-iprs/certificate"
+	clock "github.com/dirkmc/go-iprs/clock"
 	rsp "github.com/dirkmc/go-iprs/path"
 	pb "github.com/dirkmc/go-iprs/pb"
 	path "github.com/ipfs/go-ipfs/path"
 	routing "gx/ipfs/QmPCGUjMRuBcPybZFpjhzpifwPP9wPRoiy5geTQKU4vqWA/go-libp2p-routing"
 	ci "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
+	ds "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore"
 	"time"
 )
 
 type RecordFactory struct {
 	r         routing.ValueStore
+	dstore    ds.Datastore
+	clock     clock.Clock
 	pkm       *PublicKeyManager
 	certm     *c.CertificateManager
+	certv     *CertRecordVerifier
+	policy    TrustPolicy
+	checkers  map[pb.IprsEntry_ValidityType]RecordChecker
 	verifiers map[pb.IprsEntry_VerificationType]RecordVerifier
+	ttl       *time.Duration
+	retry     RetryPolicy
 }
 
-func NewRecordFactory(r routing.ValueStore) *RecordFactory {
+// If clk is nil the system clock is used
+func NewRecordFactory(r routing.ValueStore, clk clock.Clock) *RecordFactory {
+	return NewRecordFactoryWithDatastore(r, nil, clk)
+}
+
+// NewRecordFactoryWithDatastore creates a factory whose certificate
+// manager caches fetched certificates in the given datastore.
+// If dstore is nil certificates are only cached in memory.
+// If clk is nil the system clock is used.
+func NewRecordFactoryWithDatastore(r routing.ValueStore, dstore ds.Datastore, clk clock.Clock) *RecordFactory {
+	clk = clock.OrRealClock(clk)
 	pkm := NewPublicKeyManager(r)
-	certm := c.NewCertificateManager(r)
+	certm := c.NewCertificateManager(r, dstore)
+	rm := c.NewRevocationManager(r, nil, clk)
+	am := c.NewAllowListManager(r, nil, clk)
+	certv := NewCertRecordVerifier(certm, rm, am, clk)
 
 	verifiers := make(map[pb.IprsEntry_VerificationType]RecordVerifier)
 	verifiers[pb.IprsEntry_Key] = NewKeyRecordVerifier(pkm)
-	verifiers[pb.IprsEntry_Cert] = NewCertRecordVerifier(certm)
+	verifiers[pb.IprsEntry_Cert] = certv
+	verifiers[pb.IprsEntry_MultiKey] = NewMultiKeyRecordVerifier(pkm)
 
 	return &RecordFactory{
 		r:         r,
+		dstore:    dstore,
+		clock:     clk,
 		pkm:       pkm,
 		certm:     certm,
+		certv:     certv,
+		checkers:  newRecordCheckers(clk, SkewTolerance{}),
 		verifiers: verifiers,
+		retry:     NoRetry,
+	}
+}
+
+func newRecordCheckers(clk clock.Clock, tolerance SkewTolerance) map[pb.IprsEntry_ValidityType]RecordChecker {
+	checkers := make(map[pb.IprsEntry_ValidityType]RecordChecker)
+	checkers[pb.IprsEntry_EOL] = NewEolRecordChecker(clk, tolerance)
+	checkers[pb.IprsEntry_TimeRange] = NewRangeRecordChecker(clk, tolerance)
+	checkers[pb.IprsEntry_Composite] = NewCompositeRecordChecker(clk, tolerance)
+	checkers[pb.IprsEntry_Recurring] = NewRecurringRecordChecker(clk, tolerance)
+	checkers[pb.IprsEntry_MultiRange] = NewMultiRangeRecordChecker(clk, tolerance)
+	return checkers
+}
+
+// SetSkewTolerance sets the amount of clock skew that is tolerated when
+// validating records. It should be called before the factory is used.
+func (f *RecordFactory) SetSkewTolerance(tolerance SkewTolerance) {
+	f.checkers = newRecordCheckers(f.clock, tolerance)
+}
+
+// SetRecordTtl sets the TTL of records subsequently created by the
+// factory, ie how long resolvers may cache them for
+func (f *RecordFactory) SetRecordTtl(ttl time.Duration) {
+	f.ttl = &ttl
+}
+
+// SetRetryPolicy sets how records subsequently created by the factory
+// retry puts to routing that fail with a transient error
+func (f *RecordFactory) SetRetryPolicy(p RetryPolicy) {
+	f.retry = p
+}
+
+// SetLimitValidityToCert sets whether to reject Cert records that could
+// be valid after the signing certificate expires
+func (f *RecordFactory) SetLimitValidityToCert(limit bool) {
+	f.certv.SetLimitValidityToCert(limit)
+}
+
+// SetAllowStaleRevocationLists sets whether Cert records are checked
+// against revocation lists whose next update has passed, rather than
+// rejected. By default they are rejected.
+func (f *RecordFactory) SetAllowStaleRevocationLists(allow bool) {
+	if f.certv.rm != nil {
+		f.certv.rm.SetAllowStale(allow)
 	}
 }
 
+// SetTrustStore sets the pinned root certificates for Cert records.
+// If the policy is TrustPinnedRoots, only Cert records that chain to a
+// pinned root certificate are accepted (records verified with keys are
+// rejected).
+func (f *RecordFactory) SetTrustStore(trust *c.TrustStore, policy TrustPolicy) {
+	f.policy = policy
+	f.certv.SetTrustStore(trust, policy)
+}
+
+// Validates that the given record has not expired etc
+func (f *RecordFactory) Validate(iprsKey rsp.IprsPath, entry *pb.IprsEntry) error {
+	checker, ok := f.checkers[entry.GetValidityType()]
+	if !ok {
+		return fmt.Errorf("Unrecognized validity type %s", entry.GetValidityType().String())
+	}
+	return checker.ValidateRecord(iprsKey, entry)
+}
+
 // Verifies that the given record is correctly signed etc
 func (f *RecordFactory) Verify(ctx context.Context, iprsKey rsp.IprsPath, entry *pb.IprsEntry) error {
-	verifier, ok := f.verifiers[entry.GetVerificationType()]
+	return f.verify(ctx, f.verifiers, iprsKey, entry)
+}
+
+func (f *RecordFactory) verify(ctx context.Context, verifiers map[pb.IprsEntry_VerificationType]RecordVerifier, iprsKey rsp.IprsPath, entry *pb.IprsEntry) error {
+	if f.policy == TrustPinnedRoots && entry.GetVerificationType() != pb.IprsEntry_Cert {
+		return ErrUntrustedRoot
+	}
+	verifier, ok := verifiers[entry.GetVerificationType()]
 	if !ok {
 		return fmt.Errorf("Unrecognized validity type %s", entry.GetVerificationType().String())
 	}
 	return verifier.VerifyRecord(ctx, iprsKey, entry)
 }
 
+// verifiersFor returns verifiers configured like the factory's, that
+// fetch public keys and certificates from the given value store
+func (f *RecordFactory) verifiersFor(r routing.ValueStore) map[pb.IprsEntry_VerificationType]RecordVerifier {
+	pkm := NewPublicKeyManager(r)
+	certv := *f.certv
+	certv.m = c.NewCertificateManager(r, f.dstore)
+
+	verifiers := make(map[pb.IprsEntry_VerificationType]RecordVerifier)
+	verifiers[pb.IprsEntry_Key] = NewKeyRecordVerifier(pkm)
+	verifiers[pb.IprsEntry_Cert] = &certv
+	verifiers[pb.IprsEntry_MultiKey] = NewMultiKeyRecordVerifier(pkm)
+	return verifiers
+}
+
+// PublishEntry validates and verifies a signed entry, then puts it to
+// routing. Unlike Record.PublishEntry it does not publish the data
+// required to verify the entry, so it must already be on the network.
+func (f *RecordFactory) PublishEntry(ctx context.Context, iprsKey rsp.IprsPath, entry *pb.IprsEntry) error {
+	err := f.Validate(iprsKey, entry)
+	if err != nil {
+		return err
+	}
+	err = f.Verify(ctx, iprsKey, entry)
+	if err != nil {
+		return err
+	}
+	return f.retry.run(ctx, f.clock, new(PublishStep), func() error {
+		return putEntryToRouting(ctx, f.r, iprsKey, entry)
+	})
+}
+
 func (f *RecordFactory) NewKeyRecordSigner(pk ci.PrivKey) *KeyRecordSigner {
 	return NewKeyRecordSigner(f.pkm, pk)
 }
 
-func (f *RecordFactory) NewCertRecordSigner(cert *x509.Certificate, pk *rsa.PrivateKey) *CertRecordSigner {
+// NewPublicKeyRecordSigner creates a signer for records whose private key
+// is kept offline
+func (f *RecordFactory) NewPublicKeyRecordSigner(pubk ci.PubKey) *KeyRecordSigner {
+	return NewPublicKeyRecordSigner(f.pkm, pubk)
+}
+
+func (f *RecordFactory) NewCertRecordSigner(cert *x509.Certificate, pk crypto.Signer) *CertRecordSigner {
 	return NewCertRecordSigner(f.certm, cert, pk)
 }
 
+// NewCertChainRecordSigner creates a signer for a certificate that was
+// issued by the root certificate through the given intermediates
+func (f *RecordFactory) NewCertChainRecordSigner(cert *x509.Certificate, intermediates []*x509.Certificate, pk crypto.Signer) *CertRecordSigner {
+	return NewCertChainRecordSigner(f.certm, cert, intermediates, pk)
+}
+
+// NewMultiKeyRecordSigner creates a signer for records that must be signed
+// by threshold of the given public keys, using any locally held private keys
+func (f *RecordFactory) NewMultiKeyRecordSigner(threshold int, pubks []ci.PubKey, pks ...ci.PrivKey) (*MultiKeyRecordSigner, error) {
+	return NewMultiKeyRecordSigner(f.pkm, threshold, pubks, pks...)
+}
+
 func (f *RecordFactory) NewRecord(vl RecordValidity, s RecordSigner, p path.Path) *Record {
-	return NewRecord(f.r, vl, s, p)
+	r := NewRecord(f.r, vl, s, p)
+	r.f = f
+	r.SetRetryPolicy(f.retry)
+	if f.ttl != nil {
+		r.SetTtl(*f.ttl)
+	}
+	return r
+}
+
+// NewTombstoneRecord creates a tombstone record, which unpublishes the
+// name it is published at
+func (f *RecordFactory) NewTombstoneRecord(vl RecordValidity, s RecordSigner) *Record {
+	r := f.NewRecord(vl, s, "")
+	r.tombstone = true
+	return r
 }
 
 func (f *RecordFactory) NewEolKeyRecord(p path.Path, pk ci.PrivKey, eol time.Time) *Record {
@@ -64,7 +223,7 @@ func (f *RecordFactory) NewEolKeyRecord(p path.Path, pk ci.PrivKey, eol time.Tim
 	return f.NewRecord(vl, s, p)
 }
 
-func (f *RecordFactory) NewEolCertRecord(p path.Path, cert *x509.Certificate, pk *rsa.PrivateKey, eol time.Time) *Record {
+func (f *RecordFactory) NewEolCertRecord(p path.Path, cert *x509.Certificate, pk crypto.Signer, eol time.Time) *Record {
 	vl := NewEolRecordValidity(eol)
 	s := f.NewCertRecordSigner(cert, pk)
 	return f.NewRecord(vl, s, p)
@@ -79,7 +238,7 @@ func (f *RecordFactory) NewRangeKeyRecord(p path.Path, pk ci.PrivKey, start, end
 	return f.NewRecord(vl, s, p), nil
 }
 
-func (f *RecordFactory) NewRangeCertRecord(p path.Path, cert *x509.Certificate, pk *rsa.PrivateKey, start, end *time.Time) (*Record, error) {
+func (f *RecordFactory) NewRangeCertRecord(p path.Path, cert *x509.Certificate, pk crypto.Signer, start, end *time.Time) (*Record, error) {
 	vl, err := NewRangeRecordValidity(start, end)
 	if err != nil {
 		return nil, err
@@ -87,3 +246,21 @@ func (f *RecordFactory) NewRangeCertRecord(p path.Path, cert *x509.Certificate,
 	s := f.NewCertRecordSigner(cert, pk)
 	return f.NewRecord(vl, s, p), nil
 }
+
+func (f *RecordFactory) NewMultiRangeKeyRecord(p path.Path, pk ci.PrivKey, ranges ...[2]*time.Time) (*Record, error) {
+	vl, err := NewMultiRangeRecordValidity(ranges...)
+	if err != nil {
+		return nil, err
+	}
+	s := f.NewKeyRecordSigner(pk)
+	return f.NewRecord(vl, s, p), nil
+}
+
+func (f *RecordFactory) NewMultiRangeCertRecord(p path.Path, cert *x509.Certificate, pk crypto.Signer, ranges ...[2]*time.Time) (*Record, error) {
+	vl, err := NewMultiRangeRecordValidity(ranges...)
+	if err != nil {
+		return nil, err
+	}
+	s := f.NewCertRecordSigner(cert, pk)
+	return f.NewRecord(vl, s, p), nil
+}
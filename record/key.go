@@ -5,6 +5,8 @@ import (
 	"fmt"
 	pb "github.com/dirkmc/go-iprs/pb"
 	rsp "github.com/dirkmc/go-iprs/path"
+	routing "gx/ipfs/QmPCGUjMRuBcPybZFpjhzpifwPP9wPRoiy5geTQKU4vqWA/go-libp2p-routing"
+	proto "gx/ipfs/QmZ4Qi3GaRbjcx28Sme5eMH7RQjGkt8wHxt2a65oLaeFEV/gogo-protobuf/proto"
 	ci "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
 )
 
@@ -12,14 +14,22 @@ import (
 type KeyRecordSigner struct {
 	m *PublicKeyManager
 	pk ci.PrivKey
+	pubk ci.PubKey
 }
 
 func NewKeyRecordSigner(m *PublicKeyManager, pk ci.PrivKey) *KeyRecordSigner {
-	return &KeyRecordSigner{ m, pk }
+	return &KeyRecordSigner{ m, pk, pk.GetPublic() }
+}
+
+// NewPublicKeyRecordSigner creates a signer that can create unsigned
+// entries and publish the public key, but cannot sign entries itself.
+// It is used for records whose private key is kept offline.
+func NewPublicKeyRecordSigner(m *PublicKeyManager, pubk ci.PubKey) *KeyRecordSigner {
+	return &KeyRecordSigner{ m, nil, pubk }
 }
 
 func (s *KeyRecordSigner) BasePath() (rsp.IprsPath, error) {
-	h, err := GetPublicKeyHash(s.pk.GetPublic())
+	h, err := GetPublicKeyHash(s.pubk)
 	if err != nil {
 		return rsp.NilPath, err
 	}
@@ -37,11 +47,23 @@ func (s *KeyRecordSigner) Verification() ([]byte, error) {
 
 func (s *KeyRecordSigner) PublishVerification(ctx context.Context, iprsKey rsp.IprsPath, entry *pb.IprsEntry) error {
 	// TODO: Check iprsKey is valid for this type of RecordSigner
-	return s.m.PutPublicKey(ctx, s.pk.GetPublic())
+	return s.m.PutPublicKey(ctx, s.pubk)
+}
+
+func (s *KeyRecordSigner) stageVerification(ctx context.Context, vs routing.ValueStore) error {
+	return NewPublicKeyManager(vs).PutPublicKey(ctx, s.pubk)
 }
 
-func (s *KeyRecordSigner) SignRecord(entry *pb.IprsEntry) error {
-	sig, err := s.pk.Sign(RecordDataForSig(entry))
+func (s *KeyRecordSigner) SignRecord(iprsKey rsp.IprsPath, entry *pb.IprsEntry) error {
+	if s.pk == nil {
+		return ErrNoPrivateKey
+	}
+	entry.SignatureVersion = proto.Uint32(SignatureV2)
+	data, err := RecordDataForSig(iprsKey, entry)
+	if err != nil {
+		return err
+	}
+	sig, err := s.pk.Sign(data)
 	if err != nil {
 		return err
 	}
@@ -65,7 +87,12 @@ func (v *KeyRecordVerifier) VerifyRecord(ctx context.Context, iprsKey rsp.IprsPa
 		return err
 	}
 
-	if ok, err := pubk.Verify(RecordDataForSig(entry), entry.GetSignature()); err != nil || !ok {
+	data, err := RecordDataForSig(iprsKey, entry)
+	if err != nil {
+		return err
+	}
+
+	if ok, err := pubk.Verify(data, entry.GetSignature()); err != nil || !ok {
 		return fmt.Errorf("Invalid record value. Not signed by private key corresponding to public key %v", pubk)
 	}
 
@@ -97,6 +97,155 @@ func TestKeyRecordVerification(t *testing.T) {
 	// be retrieved from the network
 }
 
+func TestKeyRecordSignatureVersions(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	r := mockrouting.NewServer().ClientWithDatastore(ctx, testutil.RandIdentityOrFatal(t), dstore)
+	pubkManager := NewPublicKeyManager(r)
+	verifier := NewKeyRecordVerifier(pubkManager)
+
+	sr := u.NewSeededRand(15) // generate deterministic keypair
+	pk, _, err := ci.GenerateKeyPairWithReader(ci.RSA, 1024, sr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pubkManager.PutPublicKey(ctx, pk.GetPublic())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	iprsKey := getIprsPathFromKey(t, pk)
+	vl := NewEolRecordValidity(time.Now().Add(time.Hour))
+	s := NewKeyRecordSigner(pubkManager, pk)
+	rec := NewRecord(r, vl, s, path.Path("foo"))
+
+	// New records are signed with a V2 signature
+	e1, err := rec.Entry(iprsKey, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if GetSignatureVersion(e1) != SignatureV2 {
+		t.Fatalf("Expected signature version %d, got %d", SignatureV2, GetSignatureVersion(e1))
+	}
+	err = verifier.VerifyRecord(ctx, iprsKey, e1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The sequence number is covered by a V2 signature
+	e1.Sequence = proto.Uint64(2)
+	err = verifier.VerifyRecord(ctx, iprsKey, e1)
+	if err == nil {
+		t.Fatal("Expected error for V2 record with modified sequence number")
+	}
+
+	// The IPRS key is covered by a V2 signature
+	e2, err := rec.Entry(iprsKey, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherIprsKey, err := rsp.FromString(iprsKey.String() + "/other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = verifier.VerifyRecord(ctx, otherIprsKey, e2)
+	if err == nil {
+		t.Fatal("Expected error for V2 record verified at a different IPRS key")
+	}
+
+	// Records signed with a V1 signature (no signature version)
+	// can still be verified
+	e3, err := rec.Entry(iprsKey, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e3.SignatureVersion = nil
+	e3.Signature, err = pk.Sign(RecordDataForSigV1(e3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = verifier.VerifyRecord(ctx, iprsKey, e3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Records with an unknown signature version cannot be verified
+	e3.SignatureVersion = proto.Uint32(SignatureV2 + 1)
+	err = verifier.VerifyRecord(ctx, iprsKey, e3)
+	if err == nil {
+		t.Fatal("Expected error for unknown signature version")
+	}
+}
+
+func TestKeyRecordTtl(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	r := mockrouting.NewServer().ClientWithDatastore(ctx, testutil.RandIdentityOrFatal(t), dstore)
+	f := NewRecordFactory(r, nil)
+
+	sr := u.NewSeededRand(15) // generate deterministic keypair
+	pk, _, err := ci.GenerateKeyPairWithReader(ci.RSA, 1024, sr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.pkm.PutPublicKey(ctx, pk.GetPublic())
+	if err != nil {
+		t.Fatal(err)
+	}
+	iprsKey := getIprsPathFromKey(t, pk)
+	eol := time.Now().Add(time.Hour)
+
+	// Records without a TTL
+	e1, err := f.NewEolKeyRecord(path.Path("foo"), pk, eol).Entry(iprsKey, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := GetTtl(e1); ok {
+		t.Fatal("Expected record not to have a TTL")
+	}
+
+	// TTL set on the record
+	rec := f.NewEolKeyRecord(path.Path("foo"), pk, eol)
+	rec.SetTtl(time.Second * 30)
+	e2, err := rec.Entry(iprsKey, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ttl, ok := GetTtl(e2)
+	if !ok || ttl != time.Second*30 {
+		t.Fatal("Expected record to have a TTL of 30 seconds")
+	}
+	err = f.Verify(ctx, iprsKey, e2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The TTL is covered by the signature
+	e2.Ttl = proto.Uint64(uint64(time.Hour))
+	err = f.Verify(ctx, iprsKey, e2)
+	if err == nil {
+		t.Fatal("Expected error for record with modified TTL")
+	}
+
+	// TTL set on the factory
+	f.SetRecordTtl(time.Minute * 5)
+	e3, err := f.NewEolKeyRecord(path.Path("foo"), pk, eol).Entry(iprsKey, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ttl, ok = GetTtl(e3)
+	if !ok || ttl != time.Minute*5 {
+		t.Fatal("Expected record to have a TTL of 5 minutes")
+	}
+
+	// The TTL of a V1 record is not covered by the signature so it
+	// is ignored
+	e3.SignatureVersion = nil
+	if _, ok := GetTtl(e3); ok {
+		t.Fatal("Expected TTL of V1 record to be ignored")
+	}
+}
+
 func getIprsPathFromKey(t *testing.T, pk ci.PrivKey) rsp.IprsPath {
 	b, err := pk.GetPublic().Bytes()
 	if err != nil {
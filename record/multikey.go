@@ -0,0 +1,331 @@
+package iprs_record
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	rsp "github.com/dirkmc/go-iprs/path"
+	pb "github.com/dirkmc/go-iprs/pb"
+	u "github.com/ipfs/go-ipfs-util"
+	routing "gx/ipfs/QmPCGUjMRuBcPybZFpjhzpifwPP9wPRoiy5geTQKU4vqWA/go-libp2p-routing"
+	proto "gx/ipfs/QmZ4Qi3GaRbjcx28Sme5eMH7RQjGkt8wHxt2a65oLaeFEV/gogo-protobuf/proto"
+	ci "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
+)
+
+// ErrMultiKeyThreshold should be returned when a MultiKey record does
+// not have enough valid signatures to meet its threshold
+var ErrMultiKeyThreshold = errors.New("not enough valid signatures to meet multi key threshold")
+
+// ErrMultiKeyUnknownKey should be returned when a partial signature
+// is made with a key that is not in the MultiKey set
+var ErrMultiKeyUnknownKey = errors.New("key is not in multi key set")
+
+// PartialSignature is a signature over a MultiKey record made with one
+// of the keys in the set
+type PartialSignature struct {
+	// B58 encoded hash of the public key
+	KeyHash   string
+	Signature []byte
+}
+
+// MultiKeyRecordSigner signs records that require signatures from
+// at least k of a set of n keys (a k-of-n threshold).
+// Because no single party should hold k keys, the signer collects
+// partial signatures from the other key holders. The coordinator gets
+// the record's entry (with the sequence number that will be published)
+// and sends it to each key holder, who calls SignPartial on it and
+// sends back the partial signature. The coordinator then adds the partial
+// signatures with AddPartialSignature and publishes the record.
+type MultiKeyRecordSigner struct {
+	m         *PublicKeyManager
+	threshold int
+	pubks     []ci.PubKey
+	hashes    []string
+	pks       []ci.PrivKey
+	partial   map[string][]byte
+}
+
+// NewMultiKeyRecordSigner creates a signer for records that must be signed
+// by threshold of the given public keys. Any private keys that are held
+// locally are used to sign the record.
+func NewMultiKeyRecordSigner(m *PublicKeyManager, threshold int, pubks []ci.PubKey, pks ...ci.PrivKey) (*MultiKeyRecordSigner, error) {
+	if threshold < 1 || threshold > len(pubks) {
+		return nil, fmt.Errorf("Multi key threshold must be between 1 and %d", len(pubks))
+	}
+
+	// Sort the keys by hash, so that the key set always has the same
+	// representation (and therefore the same IPRS path)
+	byHash := make(map[string]ci.PubKey)
+	var hashes []string
+	for _, pubk := range pubks {
+		h, err := GetPublicKeyHash(pubk)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := byHash[h]; ok {
+			return nil, fmt.Errorf("Duplicate key %s in multi key set", h)
+		}
+		byHash[h] = pubk
+		hashes = append(hashes, h)
+	}
+	sort.Strings(hashes)
+
+	s := &MultiKeyRecordSigner{
+		m:         m,
+		threshold: threshold,
+		hashes:    hashes,
+		partial:   make(map[string][]byte),
+	}
+	for _, h := range hashes {
+		s.pubks = append(s.pubks, byHash[h])
+	}
+
+	for _, pk := range pks {
+		h, err := GetPublicKeyHash(pk.GetPublic())
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := byHash[h]; !ok {
+			return nil, ErrMultiKeyUnknownKey
+		}
+		s.pks = append(s.pks, pk)
+	}
+
+	return s, nil
+}
+
+func (s *MultiKeyRecordSigner) BasePath() (rsp.IprsPath, error) {
+	v, err := s.Verification()
+	if err != nil {
+		return rsp.NilPath, err
+	}
+	return rsp.FromString("/iprs/" + u.Hash(v).B58String())
+}
+
+func (s *MultiKeyRecordSigner) VerificationType() *pb.IprsEntry_VerificationType {
+	t := pb.IprsEntry_MultiKey
+	return &t
+}
+
+// Verification is the threshold and the sorted list of key hashes
+func (s *MultiKeyRecordSigner) Verification() ([]byte, error) {
+	return proto.Marshal(&pb.MultiKeyVerification{
+		Threshold: proto.Uint32(uint32(s.threshold)),
+		KeyHashes: s.hashes,
+	})
+}
+
+func (s *MultiKeyRecordSigner) PublishVerification(ctx context.Context, iprsKey rsp.IprsPath, entry *pb.IprsEntry) error {
+	// Publish all the public keys, so that the signatures from any
+	// of the keys can be verified
+	resp := make(chan error, len(s.pubks))
+	for _, pubk := range s.pubks {
+		go func(pubk ci.PubKey) {
+			resp <- s.m.PutPublicKey(ctx, pubk)
+		}(pubk)
+	}
+
+	var err error
+	for range s.pubks {
+		if perr := <-resp; perr != nil {
+			err = perr
+		}
+	}
+	return err
+}
+
+func (s *MultiKeyRecordSigner) stageVerification(ctx context.Context, vs routing.ValueStore) error {
+	m := NewPublicKeyManager(vs)
+	for _, pubk := range s.pubks {
+		if err := m.PutPublicKey(ctx, pubk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddPartialSignature adds a signature made by another key holder with
+// SignPartial. Partial signatures that are not valid for the entry
+// being signed are ignored when the record is signed.
+func (s *MultiKeyRecordSigner) AddPartialSignature(ps *PartialSignature) error {
+	if s.keyIndex(ps.KeyHash) < 0 {
+		return ErrMultiKeyUnknownKey
+	}
+	s.partial[ps.KeyHash] = ps.Signature
+	return nil
+}
+
+func (s *MultiKeyRecordSigner) keyIndex(hash string) int {
+	i := sort.SearchStrings(s.hashes, hash)
+	if i < len(s.hashes) && s.hashes[i] == hash {
+		return i
+	}
+	return -1
+}
+
+// SignRecord signs the entry with the locally held private keys, and
+// adds any partial signatures that are valid for the entry. The entry
+// is not valid until it has been signed by at least threshold keys.
+func (s *MultiKeyRecordSigner) SignRecord(iprsKey rsp.IprsPath, entry *pb.IprsEntry) error {
+	entry.SignatureVersion = proto.Uint32(SignatureV2)
+	data, err := RecordDataForSig(iprsKey, entry)
+	if err != nil {
+		return err
+	}
+
+	sigs := make(map[int][]byte)
+	for h, sig := range s.partial {
+		i := s.keyIndex(h)
+		if ok, err := s.pubks[i].Verify(data, sig); err != nil || !ok {
+			log.Warningf("Ignoring invalid partial signature from key %s", h)
+			continue
+		}
+		sigs[i] = sig
+	}
+	for _, pk := range s.pks {
+		h, err := GetPublicKeyHash(pk.GetPublic())
+		if err != nil {
+			return err
+		}
+		sig, err := pk.Sign(data)
+		if err != nil {
+			return err
+		}
+		sigs[s.keyIndex(h)] = sig
+	}
+
+	// Order signatures by key index so that the encoding is deterministic
+	var indices []int
+	for i := range sigs {
+		indices = append(indices, i)
+	}
+	sort.Ints(indices)
+
+	mks := new(pb.MultiKeySignature)
+	for _, i := range indices {
+		mks.Signatures = append(mks.Signatures, &pb.MultiKeySignature_Signature{
+			KeyIndex:  proto.Uint32(uint32(i)),
+			Signature: sigs[i],
+		})
+	}
+
+	entry.Signature, err = proto.Marshal(mks)
+	return err
+}
+
+// SignPartial creates a partial signature over a MultiKey entry with one
+// of the keys in the entry's key set. The entry must have the fields
+// that will be published (including the sequence number) already set.
+func SignPartial(pk ci.PrivKey, iprsKey rsp.IprsPath, entry *pb.IprsEntry) (*PartialSignature, error) {
+	mkv, err := MultiKeyParseVerification(entry)
+	if err != nil {
+		return nil, err
+	}
+	h, err := GetPublicKeyHash(pk.GetPublic())
+	if err != nil {
+		return nil, err
+	}
+	found := false
+	for _, kh := range mkv.GetKeyHashes() {
+		found = found || kh == h
+	}
+	if !found {
+		return nil, ErrMultiKeyUnknownKey
+	}
+
+	e := *entry
+	e.SignatureVersion = proto.Uint32(SignatureV2)
+	data, err := RecordDataForSig(iprsKey, &e)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := pk.Sign(data)
+	if err != nil {
+		return nil, err
+	}
+	return &PartialSignature{h, sig}, nil
+}
+
+// MultiKeyParseVerification parses and checks the key set and threshold
+// of a MultiKey entry
+func MultiKeyParseVerification(entry *pb.IprsEntry) (*pb.MultiKeyVerification, error) {
+	mkv := new(pb.MultiKeyVerification)
+	err := proto.Unmarshal(entry.GetVerification(), mkv)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := mkv.GetKeyHashes()
+	if mkv.GetThreshold() < 1 || int(mkv.GetThreshold()) > len(hashes) {
+		return nil, fmt.Errorf("Invalid multi key threshold %d for %d keys", mkv.GetThreshold(), len(hashes))
+	}
+	for i := 1; i < len(hashes); i++ {
+		if hashes[i-1] >= hashes[i] {
+			return nil, errors.New("Multi key hashes must be sorted and unique")
+		}
+	}
+	return mkv, nil
+}
+
+type MultiKeyRecordVerifier struct {
+	m *PublicKeyManager
+}
+
+func NewMultiKeyRecordVerifier(m *PublicKeyManager) *MultiKeyRecordVerifier {
+	return &MultiKeyRecordVerifier{m}
+}
+
+func (v *MultiKeyRecordVerifier) VerifyRecord(ctx context.Context, iprsKey rsp.IprsPath, entry *pb.IprsEntry) error {
+	// The IPRS key is the hash of the key set and threshold
+	if u.Hash(entry.GetVerification()).B58String() != iprsKey.GetHashString() {
+		return fmt.Errorf("Multi key set does not match IPRS key %s", iprsKey)
+	}
+
+	mkv, err := MultiKeyParseVerification(entry)
+	if err != nil {
+		return err
+	}
+
+	// MultiKey records were introduced after SignatureV1
+	if GetSignatureVersion(entry) == SignatureV1 {
+		return ErrUnknownSignatureVersion
+	}
+	data, err := RecordDataForSig(iprsKey, entry)
+	if err != nil {
+		return err
+	}
+
+	mks := new(pb.MultiKeySignature)
+	err = proto.Unmarshal(entry.GetSignature(), mks)
+	if err != nil {
+		return err
+	}
+
+	// Count the number of distinct keys with a valid signature
+	hashes := mkv.GetKeyHashes()
+	valid := make(map[uint32]bool)
+	for _, sig := range mks.GetSignatures() {
+		i := sig.GetKeyIndex()
+		if int(i) >= len(hashes) || valid[i] {
+			continue
+		}
+
+		pubk, err := v.m.GetPublicKeyForHash(ctx, hashes[i])
+		if err != nil {
+			continue
+		}
+		if ok, err := pubk.Verify(data, sig.GetSignature()); err != nil || !ok {
+			log.Warningf("Invalid signature from key %s for multi key record %s", hashes[i], iprsKey)
+			continue
+		}
+
+		valid[i] = true
+		if len(valid) >= int(mkv.GetThreshold()) {
+			return nil
+		}
+	}
+
+	return ErrMultiKeyThreshold
+}
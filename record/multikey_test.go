@@ -0,0 +1,195 @@
+package iprs_record
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "github.com/dirkmc/go-iprs/pb"
+	u "github.com/ipfs/go-ipfs-util"
+	path "github.com/ipfs/go-ipfs/path"
+	mockrouting "github.com/ipfs/go-ipfs/routing/mock"
+	proto "gx/ipfs/QmZ4Qi3GaRbjcx28Sme5eMH7RQjGkt8wHxt2a65oLaeFEV/gogo-protobuf/proto"
+	ci "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
+	ds "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore"
+	dssync "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore/sync"
+	testutil "gx/ipfs/QmeDA8gNhvRTsbrjEieay5wezupJDiky8xvCzDABbsGzmp/go-testutil"
+)
+
+func generateKeys(t *testing.T, n int) ([]ci.PrivKey, []ci.PubKey) {
+	sr := u.NewSeededRand(15) // generate deterministic keypairs
+	var pks []ci.PrivKey
+	var pubks []ci.PubKey
+	for i := 0; i < n; i++ {
+		pk, pubk, err := ci.GenerateKeyPairWithReader(ci.RSA, 1024, sr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pks = append(pks, pk)
+		pubks = append(pubks, pubk)
+	}
+	return pks, pubks
+}
+
+func TestNewMultiKeyRecordSigner(t *testing.T) {
+	f := NewRecordFactory(nil, nil)
+	pks, pubks := generateKeys(t, 4)
+
+	// Threshold out of range FAIL
+	_, err := f.NewMultiKeyRecordSigner(0, pubks[:3])
+	if err == nil {
+		t.Fatal("Expected threshold error")
+	}
+	_, err = f.NewMultiKeyRecordSigner(4, pubks[:3])
+	if err == nil {
+		t.Fatal("Expected threshold error")
+	}
+
+	// Duplicate key FAIL
+	_, err = f.NewMultiKeyRecordSigner(2, []ci.PubKey{pubks[0], pubks[1], pubks[0]})
+	if err == nil {
+		t.Fatal("Expected duplicate key error")
+	}
+
+	// Private key not in set FAIL
+	_, err = f.NewMultiKeyRecordSigner(2, pubks[:3], pks[3])
+	if err != ErrMultiKeyUnknownKey {
+		t.Fatal("Expected unknown key error")
+	}
+
+	// Base path does not depend on the order of keys
+	s1, err := f.NewMultiKeyRecordSigner(2, pubks[:3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2, err := f.NewMultiKeyRecordSigner(2, []ci.PubKey{pubks[2], pubks[0], pubks[1]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p1, err := s1.BasePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p2, err := s2.BasePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p1 != p2 {
+		t.Fatal("Expected base path to be independent of key order")
+	}
+
+	// Base path depends on the threshold
+	s3, err := f.NewMultiKeyRecordSigner(3, pubks[:3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	p3, err := s3.BasePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p1 == p3 {
+		t.Fatal("Expected base path to depend on threshold")
+	}
+}
+
+func TestMultiKeyRecordVerification(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	r := mockrouting.NewServer().ClientWithDatastore(ctx, testutil.RandIdentityOrFatal(t), dstore)
+	f := NewRecordFactory(r, nil)
+	pks, pubks := generateKeys(t, 4)
+	eol := time.Now().Add(time.Hour)
+
+	// 2 of 3 keys, the coordinator holds key 0
+	s, err := f.NewMultiKeyRecordSigner(2, pubks[:3], pks[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	iprsKey, err := s.BasePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := f.NewRecord(NewEolRecordValidity(eol), s, path.Path("foo"))
+
+	// Only signed by one key FAIL
+	e1, err := rec.Entry(iprsKey, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.PublishVerification(ctx, iprsKey, e1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.Verify(ctx, iprsKey, e1)
+	if err != ErrMultiKeyThreshold {
+		t.Fatal("Expected threshold error")
+	}
+
+	// Partial signature from a key that is not in the set FAIL
+	_, err = SignPartial(pks[3], iprsKey, e1)
+	if err != ErrMultiKeyUnknownKey {
+		t.Fatal("Expected unknown key error")
+	}
+
+	// Partial signature for a different sequence number is ignored
+	e2, err := rec.Entry(iprsKey, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps, err := SignPartial(pks[1], iprsKey, e2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.AddPartialSignature(ps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e1, err = rec.Entry(iprsKey, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.Verify(ctx, iprsKey, e1)
+	if err != ErrMultiKeyThreshold {
+		t.Fatal("Expected threshold error")
+	}
+
+	// Signed by two keys OK
+	e2, err = rec.Entry(iprsKey, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.Verify(ctx, iprsKey, e2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The key set is bound to the IPRS key
+	s4, err := f.NewMultiKeyRecordSigner(2, pubks[1:], pks[1], pks[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	e3, err := f.NewRecord(NewEolRecordValidity(eol), s4, path.Path("foo")).Entry(iprsKey, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.Verify(ctx, iprsKey, e3)
+	if err == nil {
+		t.Fatal("Expected error for key set that does not match IPRS key")
+	}
+
+	// The same signature repeated does not count towards the threshold
+	mks := new(pb.MultiKeySignature)
+	err = proto.Unmarshal(e2.GetSignature(), mks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mks.Signatures = []*pb.MultiKeySignature_Signature{mks.Signatures[0], mks.Signatures[0]}
+	e2.Signature, err = proto.Marshal(mks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.Verify(ctx, iprsKey, e2)
+	if err != ErrMultiKeyThreshold {
+		t.Fatal("Expected threshold error")
+	}
+}
@@ -0,0 +1,235 @@
+package iprs_record
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	clock "github.com/dirkmc/go-iprs/clock"
+	rsp "github.com/dirkmc/go-iprs/path"
+	pb "github.com/dirkmc/go-iprs/pb"
+)
+
+// ErrEmptyMultiRange should be returned when an attempt is made to
+// construct a MultiRange record with no time ranges
+var ErrEmptyMultiRange = errors.New("multi range validity has no time ranges")
+
+// MultiRangeRecordValidity is valid during any of several time ranges,
+// eg two separate launch dates. A nil start time means the beginning
+// of time and a nil end time means the end of time.
+type MultiRangeRecordValidity struct {
+	ranges []*[2]*time.Time
+}
+
+// NewMultiRangeRecordValidity creates a validity from the given time
+// ranges. Overlapping and adjoining ranges are merged together.
+func NewMultiRangeRecordValidity(ranges ...[2]*time.Time) (*MultiRangeRecordValidity, error) {
+	if len(ranges) == 0 {
+		return nil, ErrEmptyMultiRange
+	}
+
+	var rs []*[2]*time.Time
+	for i := range ranges {
+		r := ranges[i]
+		if r[0] != nil && r[1] != nil && r[0].After(*r[1]) {
+			return nil, ErrRecordTimeRange
+		}
+		rs = append(rs, &r)
+	}
+
+	return &MultiRangeRecordValidity{normalizeTimeRanges(rs)}, nil
+}
+
+// Validity is formatted as a comma separated list of time ranges
+// in order of start time, eg
+// -∞~2018-01-01T00:00:00Z,2018-02-01T00:00:00Z~2018-03-01T00:00:00Z
+func (v *MultiRangeRecordValidity) Validity() ([]byte, error) {
+	var parts []string
+	for _, r := range v.ranges {
+		rv, err := NewRangeRecordValidity(r[0], r[1])
+		if err != nil {
+			return nil, err
+		}
+		b, err := rv.Validity()
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, string(b))
+	}
+	return []byte(strings.Join(parts, ",")), nil
+}
+
+func (v *MultiRangeRecordValidity) ValidityType() *pb.IprsEntry_ValidityType {
+	t := pb.IprsEntry_MultiRange
+	return &t
+}
+
+// normalizeTimeRanges sorts the ranges by start time and merges
+// ranges that overlap or adjoin
+func normalizeTimeRanges(ranges []*[2]*time.Time) []*[2]*time.Time {
+	sorted := make([]*[2]*time.Time, len(ranges))
+	copy(sorted, ranges)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return compareTimes(sorted[i][0], sorted[j][0], -1) < 0
+	})
+
+	var merged []*[2]*time.Time
+	for _, r := range sorted {
+		if len(merged) > 0 {
+			last := merged[len(merged)-1]
+			// If the range starts before the previous range ends,
+			// extend the previous range. A nil start is the beginning
+			// of time and a nil end is the end of time.
+			if r[0] == nil || last[1] == nil || !r[0].After(*last[1]) {
+				if compareTimes(r[1], last[1], 1) > 0 {
+					last[1] = r[1]
+				}
+				continue
+			}
+		}
+		m := *r
+		merged = append(merged, &m)
+	}
+	return merged
+}
+
+// MultiRangeParseValidity returns the entry's time ranges in order of
+// start time, with overlapping ranges merged
+func MultiRangeParseValidity(r *pb.IprsEntry) ([]*[2]*time.Time, error) {
+	v := string(r.GetValidity())
+	if v == "" {
+		return nil, ErrEmptyMultiRange
+	}
+
+	var ranges []*[2]*time.Time
+	for _, s := range strings.Split(v, ",") {
+		tr, err := parseTimeRange(s)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, tr)
+	}
+	return normalizeTimeRanges(ranges), nil
+}
+
+// MultiRangeTimeBounds returns the start of the entry's first time range
+// and the end of its last time range
+func MultiRangeTimeBounds(r *pb.IprsEntry) (*[2]*time.Time, error) {
+	ranges, err := MultiRangeParseValidity(r)
+	if err != nil {
+		return nil, err
+	}
+	return &[2]*time.Time{ranges[0][0], ranges[len(ranges)-1][1]}, nil
+}
+
+// MultiRangeActiveRange returns the entry's time range that contains
+// the given time, or nil if no time range contains the given time
+func MultiRangeActiveRange(r *pb.IprsEntry, now time.Time) (*[2]*time.Time, error) {
+	ranges, err := MultiRangeParseValidity(r)
+	if err != nil {
+		return nil, err
+	}
+	for _, tr := range ranges {
+		if !isPendingAt(tr, now, SkewTolerance{}) && !isExpiredAt(tr, now, SkewTolerance{}) {
+			return tr, nil
+		}
+	}
+	return nil, nil
+}
+
+func isPendingAt(tr *[2]*time.Time, now time.Time, tolerance SkewTolerance) bool {
+	return tr[0] != nil && tolerance.isPending(*tr[0], now)
+}
+
+func isExpiredAt(tr *[2]*time.Time, now time.Time, tolerance SkewTolerance) bool {
+	return tr[1] != nil && tolerance.isExpired(*tr[1], now)
+}
+
+// MultiRangeValidityCheck checks that the given time is within one of
+// the entry's time ranges
+func MultiRangeValidityCheck(entry *pb.IprsEntry, now time.Time, tolerance SkewTolerance) error {
+	ranges, err := MultiRangeParseValidity(entry)
+	if err != nil {
+		log.Warning("Failed to parse IPRS Multi Range record")
+		return err
+	}
+
+	for _, tr := range ranges {
+		pending := isPendingAt(tr, now, tolerance)
+		if !pending && !isExpiredAt(tr, now, tolerance) {
+			return nil
+		}
+		// Ranges are in order, so if this range hasn't started yet
+		// none of the following ranges have started either
+		if pending {
+			return ErrPendingRecord
+		}
+	}
+	return ErrExpiredRecord
+}
+
+// multiRangeRecordChecker
+
+type multiRangeRecordChecker struct {
+	clock     clock.Clock
+	tolerance SkewTolerance
+}
+
+// NewMultiRangeRecordChecker creates a RecordChecker for MultiRange
+// records that checks the time ranges against the given clock, allowing
+// for the given amount of clock skew
+func NewMultiRangeRecordChecker(clk clock.Clock, tolerance SkewTolerance) *multiRangeRecordChecker {
+	return &multiRangeRecordChecker{clock.OrRealClock(clk), tolerance}
+}
+
+func (v *multiRangeRecordChecker) ValidateRecord(iprsKey rsp.IprsPath, entry *pb.IprsEntry) error {
+	return MultiRangeValidityCheck(entry, v.clock.Now(), v.tolerance)
+}
+
+func (v *multiRangeRecordChecker) SelectRecord(recs []*pb.IprsEntry, vals [][]byte) (int, error) {
+	var best_seq uint64
+	best_i := -1
+
+	for i, r := range recs {
+		// Best record is the one with the highest sequence number
+		if r == nil || r.GetSequence() < best_seq {
+			continue
+		}
+
+		if best_i == -1 || r.GetSequence() > best_seq {
+			best_seq = r.GetSequence()
+			best_i = i
+		} else if r.GetSequence() == best_seq {
+			// If sequence number is equal, look at the overall
+			// span of the time ranges
+			t, err := MultiRangeTimeBounds(r)
+			if err != nil {
+				continue
+			}
+
+			bestt, err := MultiRangeTimeBounds(recs[best_i])
+			if err != nil {
+				continue
+			}
+
+			cmp := CompareTimeRanges(t, bestt)
+			if cmp > 0 {
+				best_i = i
+			} else if cmp == 0 {
+				// This is just to make sure the selection is deterministic
+				if bytes.Compare(vals[i], vals[best_i]) > 0 {
+					best_i = i
+				}
+			}
+		}
+	}
+	if best_i == -1 {
+		return 0, errors.New("no usable records in given set")
+	}
+
+	return best_i, nil
+}
+
+var MultiRangeRecordChecker = NewMultiRangeRecordChecker(clock.RealClock, SkewTolerance{})
@@ -0,0 +1,266 @@
+package iprs_record
+
+import (
+	"testing"
+	"time"
+
+	clock "github.com/dirkmc/go-iprs/clock"
+	rsp "github.com/dirkmc/go-iprs/path"
+	pb "github.com/dirkmc/go-iprs/pb"
+	u "github.com/ipfs/go-ipfs-util"
+	path "github.com/ipfs/go-ipfs/path"
+	ci "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
+)
+
+// This is just so we can get an IprsEntry for a given sequence number and time ranges
+func setupNewMultiRangeRecordFunc(t *testing.T) func(uint64, ...[2]*time.Time) *pb.IprsEntry {
+	// generate a key for signing the records
+	sr := u.NewSeededRand(15) // generate deterministic keypair
+	pk, _, err := ci.GenerateKeyPairWithReader(ci.RSA, 1024, sr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := NewRecordFactory(nil, nil)
+	iprsKey := getIprsPathFromKey(t, pk)
+
+	return func(seq uint64, ranges ...[2]*time.Time) *pb.IprsEntry {
+		r, err := f.NewMultiRangeKeyRecord(path.Path("foo"), pk, ranges...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e, err := r.Entry(iprsKey, seq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+}
+
+func hoursFrom(ts time.Time, hours int) *time.Time {
+	t := ts.Add(time.Hour * time.Duration(hours))
+	return &t
+}
+
+func TestNewMultiRangeRecord(t *testing.T) {
+	ts := time.Now()
+
+	// No ranges FAIL
+	_, err := NewMultiRangeRecordValidity()
+	if err != ErrEmptyMultiRange {
+		t.Fatal("Expected empty multi range error")
+	}
+
+	// End before start FAIL
+	_, err = NewMultiRangeRecordValidity([2]*time.Time{hoursFrom(ts, 0), hoursFrom(ts, 1)}, [2]*time.Time{hoursFrom(ts, 3), hoursFrom(ts, 2)})
+	if err != ErrRecordTimeRange {
+		t.Fatal("Expected end before start error")
+	}
+
+	// Disjoint ranges OK
+	_, err = NewMultiRangeRecordValidity([2]*time.Time{nil, hoursFrom(ts, 1)}, [2]*time.Time{hoursFrom(ts, 2), nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMultiRangeNormalization(t *testing.T) {
+	NewRecord := setupNewMultiRangeRecordFunc(t)
+	ts := time.Unix(1000000, 0).UTC()
+
+	tests := []struct {
+		ranges [][2]*time.Time
+		exp    [][2]*time.Time
+	}{{
+		// Ranges are sorted by start time
+		[][2]*time.Time{{hoursFrom(ts, 4), hoursFrom(ts, 5)}, {hoursFrom(ts, 0), hoursFrom(ts, 1)}},
+		[][2]*time.Time{{hoursFrom(ts, 0), hoursFrom(ts, 1)}, {hoursFrom(ts, 4), hoursFrom(ts, 5)}},
+	}, {
+		// Overlapping ranges are merged
+		[][2]*time.Time{{hoursFrom(ts, 0), hoursFrom(ts, 2)}, {hoursFrom(ts, 1), hoursFrom(ts, 3)}, {hoursFrom(ts, 5), hoursFrom(ts, 6)}},
+		[][2]*time.Time{{hoursFrom(ts, 0), hoursFrom(ts, 3)}, {hoursFrom(ts, 5), hoursFrom(ts, 6)}},
+	}, {
+		// Adjoining ranges are merged
+		[][2]*time.Time{{hoursFrom(ts, 0), hoursFrom(ts, 1)}, {hoursFrom(ts, 1), hoursFrom(ts, 2)}},
+		[][2]*time.Time{{hoursFrom(ts, 0), hoursFrom(ts, 2)}},
+	}, {
+		// Contained ranges are merged
+		[][2]*time.Time{{hoursFrom(ts, 0), hoursFrom(ts, 5)}, {hoursFrom(ts, 1), hoursFrom(ts, 2)}},
+		[][2]*time.Time{{hoursFrom(ts, 0), hoursFrom(ts, 5)}},
+	}, {
+		// Open ended ranges absorb the ranges that follow them
+		[][2]*time.Time{{hoursFrom(ts, 3), hoursFrom(ts, 4)}, {nil, hoursFrom(ts, 1)}, {hoursFrom(ts, 2), nil}, {hoursFrom(ts, 5), hoursFrom(ts, 6)}},
+		[][2]*time.Time{{nil, hoursFrom(ts, 1)}, {hoursFrom(ts, 2), nil}},
+	}, {
+		// Ranges that both start at the beginning of time are merged
+		[][2]*time.Time{{nil, hoursFrom(ts, 1)}, {nil, hoursFrom(ts, 2)}},
+		[][2]*time.Time{{nil, hoursFrom(ts, 2)}},
+	}}
+
+	for i, test := range tests {
+		ranges, err := MultiRangeParseValidity(NewRecord(1, test.ranges...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ranges) != len(test.exp) {
+			t.Fatalf("test %d: expected %d ranges but got %d", i, len(test.exp), len(ranges))
+		}
+		for j, r := range ranges {
+			if CompareTimeRanges(r, &test.exp[j]) != 0 {
+				t.Fatalf("test %d: range %d did not match expected range", i, j)
+			}
+		}
+	}
+}
+
+func TestMultiRangeValidation(t *testing.T) {
+	NewRecord := setupNewMultiRangeRecordFunc(t)
+	ts := time.Unix(1000000, 0)
+	clk := clock.NewMockClock(ts)
+	ValidateRecord := NewMultiRangeRecordChecker(clk, SkewTolerance{}).ValidateRecord
+	iprsKey, err := rsp.FromString("/iprs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Valid from 1 to 2 hours from now, and from 3 to 4 hours from now
+	e := NewRecord(1, [2]*time.Time{hoursFrom(ts, 1), hoursFrom(ts, 2)}, [2]*time.Time{hoursFrom(ts, 3), hoursFrom(ts, 4)})
+
+	// Before the first range
+	err = ValidateRecord(iprsKey, e)
+	if err != ErrPendingRecord {
+		t.Fatal("Expected pending error")
+	}
+
+	// In the first range
+	clk.Add(time.Minute * 90)
+	err = ValidateRecord(iprsKey, e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Between the ranges
+	clk.Add(time.Hour)
+	err = ValidateRecord(iprsKey, e)
+	if err != ErrPendingRecord {
+		t.Fatal("Expected pending error")
+	}
+
+	// In the second range
+	clk.Add(time.Hour)
+	err = ValidateRecord(iprsKey, e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// After the last range
+	clk.Add(time.Hour)
+	err = ValidateRecord(iprsKey, e)
+	if err != ErrExpiredRecord {
+		t.Fatal("Expected expired error")
+	}
+}
+
+func TestMultiRangeValidationSkewTolerance(t *testing.T) {
+	NewRecord := setupNewMultiRangeRecordFunc(t)
+	ts := time.Unix(1000000, 0)
+	tolerance := SkewTolerance{Pending: time.Second * 30, Expired: time.Second * 30}
+	ValidateRecord := NewMultiRangeRecordChecker(clock.NewMockClock(ts), tolerance).ValidateRecord
+	iprsKey, err := rsp.FromString("/iprs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	InTenSeconds := ts.Add(time.Second * 10)
+	InOneMinute := ts.Add(time.Minute)
+	TenSecondsAgo := ts.Add(time.Second * -10)
+	OneMinuteAgo := ts.Add(time.Minute * -1)
+
+	// Second range starts within the tolerance OK
+	err = ValidateRecord(iprsKey, NewRecord(1, [2]*time.Time{nil, hoursFrom(ts, -1)}, [2]*time.Time{&InTenSeconds, nil}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Second range starts beyond the tolerance FAIL
+	err = ValidateRecord(iprsKey, NewRecord(1, [2]*time.Time{nil, hoursFrom(ts, -1)}, [2]*time.Time{&InOneMinute, nil}))
+	if err != ErrPendingRecord {
+		t.Fatal("Expected pending error")
+	}
+
+	// First range expired within the tolerance OK
+	err = ValidateRecord(iprsKey, NewRecord(1, [2]*time.Time{nil, &TenSecondsAgo}, [2]*time.Time{hoursFrom(ts, 1), nil}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Last range expired beyond the tolerance FAIL
+	err = ValidateRecord(iprsKey, NewRecord(1, [2]*time.Time{nil, hoursFrom(ts, -1)}, [2]*time.Time{hoursFrom(ts, -1), &OneMinuteAgo}))
+	if err != ErrExpiredRecord {
+		t.Fatal("Expected expired error")
+	}
+}
+
+func TestMultiRangeActiveRange(t *testing.T) {
+	NewRecord := setupNewMultiRangeRecordFunc(t)
+	ts := time.Unix(1000000, 0)
+	e := NewRecord(1, [2]*time.Time{hoursFrom(ts, 1), hoursFrom(ts, 2)}, [2]*time.Time{hoursFrom(ts, 3), nil})
+
+	r, err := MultiRangeActiveRange(e, ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r != nil {
+		t.Fatal("Expected no active range")
+	}
+
+	r, err = MultiRangeActiveRange(e, hoursFrom(ts, 1).Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r == nil || !r[1].Equal(*hoursFrom(ts, 2)) {
+		t.Fatal("Expected first range to be active")
+	}
+
+	r, err = MultiRangeActiveRange(e, *hoursFrom(ts, 5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r == nil || r[1] != nil {
+		t.Fatal("Expected last range to be active")
+	}
+}
+
+func TestMultiRangeOrdering(t *testing.T) {
+	NewRecord := setupNewMultiRangeRecordFunc(t)
+
+	// select timestamp so selection is deterministic
+	ts := time.Unix(1000000, 0)
+
+	e1 := NewRecord(1, [2]*time.Time{hoursFrom(ts, 0), hoursFrom(ts, 1)})
+	e2 := NewRecord(2, [2]*time.Time{hoursFrom(ts, 0), hoursFrom(ts, 1)})
+	e3 := NewRecord(2, [2]*time.Time{hoursFrom(ts, 0), hoursFrom(ts, 1)}, [2]*time.Time{hoursFrom(ts, 2), hoursFrom(ts, 3)})
+	e4 := NewRecord(2, [2]*time.Time{hoursFrom(ts, -1), hoursFrom(ts, 1)}, [2]*time.Time{hoursFrom(ts, 2), hoursFrom(ts, 3)})
+	e5 := NewRecord(2, [2]*time.Time{nil, hoursFrom(ts, 1)}, [2]*time.Time{hoursFrom(ts, 2), nil})
+	e6 := NewRecord(3, [2]*time.Time{hoursFrom(ts, 0), hoursFrom(ts, 1)})
+
+	// e1 is the only record
+	assertMultiRangeSelected(t, e1, e1)
+	// e2 has the highest sequence number
+	assertMultiRangeSelected(t, e2, e1, e2)
+	// e3 has a later end time
+	assertMultiRangeSelected(t, e3, e1, e2, e3)
+	// e4 has an earlier start time
+	assertMultiRangeSelected(t, e4, e1, e2, e3, e4)
+	// e5 has no start or end time
+	assertMultiRangeSelected(t, e5, e1, e2, e3, e4, e5)
+	// e6 has the highest sequence number
+	assertMultiRangeSelected(t, e6, e1, e2, e3, e4, e5, e6)
+}
+
+func assertMultiRangeSelected(t *testing.T, r *pb.IprsEntry, from ...*pb.IprsEntry) {
+	err := AssertSelected(MultiRangeRecordChecker.SelectRecord, r, from)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
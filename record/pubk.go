@@ -5,6 +5,7 @@ import (
 	rsp "github.com/dirkmc/go-iprs/path"
 	u "github.com/ipfs/go-ipfs-util"
 	routing "gx/ipfs/QmPCGUjMRuBcPybZFpjhzpifwPP9wPRoiy5geTQKU4vqWA/go-libp2p-routing"
+	mh "gx/ipfs/QmYeKnKpubCMRiq3PGZcTREErthbb5Q9cXsCoSkD9bjEBd/go-multihash"
 	ci "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
 	"time"
 )
@@ -53,6 +54,21 @@ func (m *PublicKeyManager) GetPublicKey(ctx context.Context, iprsKey rsp.IprsPat
 	return pubk, nil
 }
 
+// GetPublicKeyForHash gets the public key with the given B58 encoded hash
+func (m *PublicKeyManager) GetPublicKeyForHash(ctx context.Context, hash string) (ci.PubKey, error) {
+	pkHash, err := mh.FromB58String(hash)
+	if err != nil {
+		return nil, err
+	}
+	pubk, err := routing.GetPublicKey(m.routing, ctx, pkHash)
+	if err != nil {
+		log.Warningf("Failed to get public key %s", hash)
+		return nil, err
+	}
+
+	return pubk, nil
+}
+
 func GetPublicKeyHash(pubk ci.PubKey) (string, error) {
 	pubkBytes, err := pubk.Bytes()
 	if err != nil {
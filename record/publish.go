@@ -0,0 +1,127 @@
+package iprs_record
+
+import (
+	"context"
+	"net"
+	"time"
+
+	clock "github.com/dirkmc/go-iprs/clock"
+)
+
+// RetryPolicy determines how many times a put to routing is attempted,
+// and how long to wait between attempts. The wait starts at
+// InitialBackoff and is multiplied by Multiplier after each attempt, up
+// to MaxBackoff.
+type RetryPolicy struct {
+	// The maximum number of attempts (values below 1 mean 1 attempt)
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Values below 1 mean the wait does not increase
+	Multiplier float64
+}
+
+// NoRetry attempts each put to routing once. It is the default.
+var NoRetry = RetryPolicy{MaxAttempts: 1}
+
+// DefaultRetryPolicy makes up to 4 attempts, waiting 500ms, 1s then 2s
+// between them
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    4,
+	InitialBackoff: time.Millisecond * 500,
+	MaxBackoff:     time.Second * 10,
+	Multiplier:     2,
+}
+
+// backoff returns how long to wait after the given (1-based) attempt
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := float64(p.InitialBackoff)
+	if p.Multiplier > 1 {
+		for i := 1; i < attempt; i++ {
+			d *= p.Multiplier
+		}
+	}
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		return p.MaxBackoff
+	}
+	return time.Duration(d)
+}
+
+// PublishStep is the outcome of one step of publishing a record
+type PublishStep struct {
+	// Whether the step completed successfully
+	Stored bool
+	// How many times the step was attempted
+	Attempts int
+	// How long the step took, including time spent waiting to retry
+	Duration time.Duration
+	// The error from the last attempt, if the step failed
+	Err error
+}
+
+// PublishReport describes what was stored when publishing a record, so
+// that a caller can tell what succeeded if publishing fails
+type PublishReport struct {
+	// Storing the data required to verify the entry, eg public key
+	Verification PublishStep
+	// Storing the entry itself
+	Entry PublishStep
+}
+
+// Err returns the error of the first step that failed, or nil if the
+// record was published
+func (pr *PublishReport) Err() error {
+	if pr.Verification.Err != nil {
+		return pr.Verification.Err
+	}
+	return pr.Entry.Err
+}
+
+// run calls fn until it succeeds, it returns an error that is not
+// transient, or the policy's attempts are exhausted, and records the
+// outcome in step. It waits between attempts and measures the step's
+// duration with the given clock.
+func (p RetryPolicy) run(ctx context.Context, clk clock.Clock, step *PublishStep, fn func() error) error {
+	start := clk.Now()
+	defer func() {
+		step.Duration = clk.Now().Sub(start)
+	}()
+
+	for {
+		step.Attempts++
+		step.Err = fn()
+		if step.Err == nil {
+			step.Stored = true
+			return nil
+		}
+		if step.Attempts >= p.MaxAttempts || !isTransient(ctx, step.Err) {
+			return step.Err
+		}
+
+		wait := p.backoff(step.Attempts)
+		log.Debugf("Publish attempt %d failed, retrying in %s: %s", step.Attempts, wait, step.Err)
+		select {
+		case <-clk.After(wait):
+		case <-ctx.Done():
+			return step.Err
+		}
+	}
+}
+
+// isTransient indicates whether a failed put to routing is worth
+// retrying. Only network errors that are timeouts or temporary, and
+// timeouts of a request made with a shorter deadline than ctx, are
+// retried. Other errors (eg routing.ErrNotSupported, or an invalid
+// record) would fail again.
+func isTransient(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err == context.DeadlineExceeded {
+		return true
+	}
+	if nerr, ok := err.(net.Error); ok {
+		return nerr.Timeout() || nerr.Temporary()
+	}
+	return false
+}
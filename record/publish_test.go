@@ -0,0 +1,215 @@
+package iprs_record
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	clock "github.com/dirkmc/go-iprs/clock"
+	path "github.com/ipfs/go-ipfs/path"
+	mockrouting "github.com/ipfs/go-ipfs/routing/mock"
+	routing "gx/ipfs/QmPCGUjMRuBcPybZFpjhzpifwPP9wPRoiy5geTQKU4vqWA/go-libp2p-routing"
+	ds "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore"
+	dssync "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore/sync"
+	testutil "gx/ipfs/QmeDA8gNhvRTsbrjEieay5wezupJDiky8xvCzDABbsGzmp/go-testutil"
+)
+
+// timeoutError is a network error that is worth retrying
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "put timed out" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+var errFlakyPut error = timeoutError{}
+
+// flakyValueStore fails the first n puts of each key with err
+type flakyValueStore struct {
+	routing.ValueStore
+	err   error
+	n     int
+	lk    sync.Mutex
+	calls map[string]int
+}
+
+func (f *flakyValueStore) PutValue(ctx context.Context, k string, v []byte) error {
+	f.lk.Lock()
+	f.calls[k]++
+	fail := f.calls[k] <= f.n
+	f.lk.Unlock()
+	if fail {
+		return f.err
+	}
+	return f.ValueStore.PutValue(ctx, k, v)
+}
+
+func TestPublishRetry(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	r := mockrouting.NewServer().ClientWithDatastore(ctx, testutil.RandIdentityOrFatal(t), dstore)
+	flaky := &flakyValueStore{ValueStore: r}
+	f := NewRecordFactory(flaky, nil)
+
+	pk, _, err := testutil.RandTestKeyPair(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iprsKey, err := f.NewKeyRecordSigner(pk).BasePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	eol := time.Now().Add(time.Hour)
+
+	publish := func(seq uint64, failures int, putErr error) (*PublishReport, error) {
+		flaky.err = putErr
+		flaky.n = failures
+		flaky.calls = make(map[string]int)
+		record := f.NewEolKeyRecord(path.Path("foo"), pk, eol)
+		return record.PublishWithReport(ctx, iprsKey, seq)
+	}
+
+	// Without retries a transient error fails both steps, and the report
+	// shows that nothing was stored
+	report, err := publish(1, 1, errFlakyPut)
+	if err != errFlakyPut {
+		t.Fatal("Expected flaky put error")
+	}
+	for _, step := range []PublishStep{report.Verification, report.Entry} {
+		if step.Stored || step.Attempts != 1 || step.Err != errFlakyPut {
+			t.Fatalf("Unexpected step report %+v", step)
+		}
+	}
+
+	// With retries, transient errors are retried after backing off
+	f.SetRetryPolicy(RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond * 10,
+		Multiplier:     2,
+	})
+	report, err = publish(2, 2, errFlakyPut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range []PublishStep{report.Verification, report.Entry} {
+		if !step.Stored || step.Attempts != 3 || step.Err != nil {
+			t.Fatalf("Unexpected step report %+v", step)
+		}
+		if step.Duration < time.Millisecond*30 {
+			t.Fatalf("Expected step to back off, took %s", step.Duration)
+		}
+	}
+
+	// Retries give up after the maximum number of attempts
+	report, err = publish(3, 3, errFlakyPut)
+	if err != errFlakyPut {
+		t.Fatal("Expected flaky put error")
+	}
+	if report.Entry.Stored || report.Entry.Attempts != 3 {
+		t.Fatalf("Unexpected entry report %+v", report.Entry)
+	}
+
+	// Errors that are not transient are not retried
+	errPermanent := errors.New("permanent error")
+	for i, putErr := range []error{routing.ErrNotSupported, errPermanent} {
+		report, err = publish(uint64(4+i), 1, putErr)
+		if err != putErr {
+			t.Fatalf("Expected error %s, got %v", putErr, err)
+		}
+		if report.Entry.Attempts != 1 {
+			t.Fatalf("Expected no retries, got %d attempts", report.Entry.Attempts)
+		}
+	}
+
+	// A timeout of the request (as opposed to the caller's context) is
+	// retried
+	report, err = publish(6, 1, context.DeadlineExceeded)
+	if err != nil || report.Entry.Attempts != 2 {
+		t.Fatalf("Expected deadline exceeded to be retried, got %v", err)
+	}
+
+	// Retries stop when the context is cancelled
+	f.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Hour})
+	cctx, cancel := context.WithTimeout(ctx, time.Millisecond*50)
+	defer cancel()
+	flaky.n = 1
+	flaky.err = errFlakyPut
+	flaky.calls = make(map[string]int)
+	report, err = f.NewEolKeyRecord(path.Path("foo"), pk, eol).PublishWithReport(cctx, iprsKey, 7)
+	if err != errFlakyPut || report.Entry.Attempts != 1 {
+		t.Fatal("Expected publish to stop retrying when context is cancelled")
+	}
+}
+
+func TestPublishRetryClock(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	r := mockrouting.NewServer().ClientWithDatastore(ctx, testutil.RandIdentityOrFatal(t), dstore)
+	flaky := &flakyValueStore{ValueStore: r, err: errFlakyPut, n: 2, calls: make(map[string]int)}
+	now := time.Now()
+	clk := clock.NewMockClock(now)
+	f := NewRecordFactory(flaky, clk)
+	f.SetRetryPolicy(RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Hour,
+		Multiplier:     2,
+	})
+
+	pk, _, err := testutil.RandTestKeyPair(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iprsKey, err := f.NewKeyRecordSigner(pk).BasePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Retries wait for the factory's clock rather than the system clock
+	done := make(chan *PublishReport)
+	go func() {
+		report, err := f.NewEolKeyRecord(path.Path("foo"), pk, now.Add(time.Hour*24)).PublishWithReport(ctx, iprsKey, 1)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- report
+	}()
+
+	var report *PublishReport
+	for i := 0; report == nil; i++ {
+		if i == 500 {
+			t.Fatal("Expected publish to complete when the clock is advanced")
+		}
+		select {
+		case report = <-done:
+		case <-time.After(time.Millisecond * 10):
+			clk.Add(time.Minute * 10)
+		}
+	}
+
+	// The duration is measured with the factory's clock, so it includes
+	// the hour and two hours spent backing off
+	for _, step := range []PublishStep{report.Verification, report.Entry} {
+		if !step.Stored || step.Attempts != 3 {
+			t.Fatalf("Unexpected step report %+v", step)
+		}
+		if step.Duration < time.Hour*3 {
+			t.Fatalf("Expected step duration to be measured with the clock, got %s", step.Duration)
+		}
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: time.Second,
+		MaxBackoff:     time.Second * 5,
+		Multiplier:     2,
+	}
+	expected := []time.Duration{time.Second, time.Second * 2, time.Second * 4, time.Second * 5}
+	for i, d := range expected {
+		if p.backoff(i+1) != d {
+			t.Fatalf("Expected backoff %s after attempt %d, got %s", d, i+1, p.backoff(i+1))
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"strings"
 	"time"
+	clock "github.com/dirkmc/go-iprs/clock"
 	pb "github.com/dirkmc/go-iprs/pb"
 	rsp "github.com/dirkmc/go-iprs/path"
 	u "github.com/ipfs/go-ipfs-util"
@@ -52,7 +53,17 @@ func (v *RangeRecordValidity) ValidityType() *pb.IprsEntry_ValidityType {
 
 //rangeRecordChecker
 
-type rangeRecordChecker struct {}
+type rangeRecordChecker struct {
+	clock     clock.Clock
+	tolerance SkewTolerance
+}
+
+// NewRangeRecordChecker creates a RecordChecker for TimeRange records
+// that checks the range against the given clock, allowing for the
+// given amount of clock skew
+func NewRangeRecordChecker(clk clock.Clock, tolerance SkewTolerance) *rangeRecordChecker {
+	return &rangeRecordChecker{ clock.OrRealClock(clk), tolerance }
+}
 
 func (v *rangeRecordChecker) SelectRecord(recs []*pb.IprsEntry, vals [][]byte) (int, error) {
 	var best_seq uint64
@@ -79,19 +90,13 @@ func (v *rangeRecordChecker) SelectRecord(recs []*pb.IprsEntry, vals [][]byte) (
 				continue
 			}
 
-			// Best record is the one that's valid to the latest possible moment
-			if t[1] == nil && bestt[1] != nil || (t[1] != nil && bestt[1] != nil && (*t[1]).After(*bestt[1])) {
+			cmp := CompareTimeRanges(t, bestt)
+			if cmp > 0 {
 				best_i = i
-			} else if t[1] == bestt[1] {
-				// If records are valid until an equal time, best record
-				// is the one that's valid since the longest time in the past
-				if t[0] == nil && bestt[0] != nil || (t[0] != nil && bestt[0] != nil && (*t[0]).Before(*bestt[0])) {
+			} else if cmp == 0 {
+				// This is just to make sure the selection is deterministic
+				if bytes.Compare(vals[i], vals[best_i]) > 0 {
 					best_i = i
-				} else if t[0] == bestt[0] {
-					// This is just to make sure the selection is deterministic
-					if bytes.Compare(vals[i], vals[best_i]) > 0 {
-						best_i = i
-					}
 				}
 			}
 		}
@@ -103,8 +108,48 @@ func (v *rangeRecordChecker) SelectRecord(recs []*pb.IprsEntry, vals [][]byte) (
 	return best_i, nil
 }
 
+// CompareTimeRanges returns a positive number if time range a is better
+// than time range b, a negative number if b is better or zero if they
+// are equivalent. A nil start time means the beginning of time and a nil
+// end time means the end of time.
+func CompareTimeRanges(a, b *[2]*time.Time) int {
+	// Best range is the one that's valid to the latest possible moment
+	if c := compareTimes(a[1], b[1], 1); c != 0 {
+		return c
+	}
+	// If ranges are valid until an equal time, best range is the
+	// one that's valid since the longest time in the past
+	return -compareTimes(a[0], b[0], -1)
+}
+
+// compareTimes compares two times, where nil is treated as infinity
+// with the given sign
+func compareTimes(a, b *time.Time, nilSign int) int {
+	if a == nil && b == nil {
+		return 0
+	}
+	if a == nil {
+		return nilSign
+	}
+	if b == nil {
+		return -nilSign
+	}
+	if (*a).After(*b) {
+		return 1
+	}
+	if (*a).Before(*b) {
+		return -1
+	}
+	return 0
+}
+
 func RangeParseValidity(r *pb.IprsEntry) (*[2]*time.Time, error) {
-	timeRange := strings.Split(string(r.GetValidity()), "~")
+	return parseTimeRange(string(r.GetValidity()))
+}
+
+// parseTimeRange parses a time range formatted as <start>~<end>
+func parseTimeRange(s string) (*[2]*time.Time, error) {
+	timeRange := strings.Split(s, "~")
 	if len(timeRange) != 2 {
 		return nil, errors.New("Invalid TimeRange record")
 	}
@@ -134,18 +179,24 @@ func RangeParseValidity(r *pb.IprsEntry) (*[2]*time.Time, error) {
 }
 
 func (v *rangeRecordChecker) ValidateRecord(iprsKey rsp.IprsPath, entry *pb.IprsEntry) error {
+	return RangeValidityCheck(entry, v.clock.Now(), v.tolerance)
+}
+
+// RangeValidityCheck checks that the given time is within the entry's
+// time range
+func RangeValidityCheck(entry *pb.IprsEntry, now time.Time, tolerance SkewTolerance) error {
 	t, err := RangeParseValidity(entry)
 	if err != nil {
 		log.Warning("Failed to parse IPRS Time Range record")
 		return err
 	}
-	if t[0] != nil && time.Now().Before(*t[0]) {
+	if t[0] != nil && tolerance.isPending(*t[0], now) {
 		return ErrPendingRecord
 	}
-	if t[1] != nil && time.Now().After(*t[1]) {
+	if t[1] != nil && tolerance.isExpired(*t[1], now) {
 		return ErrExpiredRecord
 	}
 	return nil
 }
 
-var RangeRecordChecker = &rangeRecordChecker{}
+var RangeRecordChecker = NewRangeRecordChecker(clock.RealClock, SkewTolerance{})
@@ -4,6 +4,7 @@ import (
 	"testing"
 	"time"
 	path "github.com/ipfs/go-ipfs/path"
+	clock "github.com/dirkmc/go-iprs/clock"
 	pb "github.com/dirkmc/go-iprs/pb"
 	u "github.com/ipfs/go-ipfs-util"
 	ci "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
@@ -19,14 +20,15 @@ func setupNewRangeRecordFunc(t *testing.T) (func(uint64, *time.Time, *time.Time)
 		t.Fatal(err)
 	}
 
-	f := NewRecordFactory(nil)
+	f := NewRecordFactory(nil, nil)
+	iprsKey := getIprsPathFromKey(t, pk)
 
 	return func(seq uint64, start *time.Time, end *time.Time) *pb.IprsEntry {
 		r, err := f.NewRangeKeyRecord(path.Path("foo"), pk, start, end)
 		if err != nil {
 			t.Fatal(err)
 		}
-		e, err := r.Entry(seq)
+		e, err := r.Entry(iprsKey, seq)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -44,7 +46,7 @@ func setupNewRangeRecordFuncWithError(t *testing.T) (func(uint64, *time.Time, *t
 		t.Fatal(err)
 	}
 
-	f := NewRecordFactory(nil)
+	f := NewRecordFactory(nil, nil)
 
 	return func(seq uint64, start *time.Time, end *time.Time) (*Record, error) {
 		return f.NewRangeKeyRecord(path.Path("foo"), pk, start, end)
@@ -145,11 +147,12 @@ func assertRangeSelected(t *testing.T, r *pb.IprsEntry, from ...*pb.IprsEntry) {
 
 func TestRangeValidation(t *testing.T) {
 	NewRecord := setupNewRangeRecordFunc(t)
-	ValidateRecord := RangeRecordChecker.ValidateRecord
+	ts := time.Unix(1000000, 0)
+	clk := clock.NewMockClock(ts)
+	ValidateRecord := NewRangeRecordChecker(clk, SkewTolerance{}).ValidateRecord
 
 	var BeginningOfTime *time.Time
 	var EndOfTime *time.Time
-	ts := time.Now()
 	OneHourAgo := ts.Add(time.Hour * -1)
 	TwoHoursAgo := ts.Add(time.Hour * -2)
 	InTwoHours := ts.Add(time.Hour * 2)
@@ -203,4 +206,55 @@ func TestRangeValidation(t *testing.T) {
 	if err == nil {
 		t.Fatal("Expected expired error")
 	}
+
+	// Move the clock into the future
+	clk.Add(time.Hour + time.Second)
+	err = ValidateRecord(iprsKey, okA)
+	if err != ErrExpiredRecord {
+		t.Fatal("Expected expired error")
+	}
+	err = ValidateRecord(iprsKey, expiredA)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRangeValidationSkewTolerance(t *testing.T) {
+	NewRecord := setupNewRangeRecordFunc(t)
+	ts := time.Unix(1000000, 0)
+	tolerance := SkewTolerance{Pending: time.Second * 30, Expired: time.Second * 30}
+	ValidateRecord := NewRangeRecordChecker(clock.NewMockClock(ts), tolerance).ValidateRecord
+	iprsKey, err := rsp.FromString("/iprs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	InTenSeconds := ts.Add(time.Second * 10)
+	InOneMinute := ts.Add(time.Minute)
+	TenSecondsAgo := ts.Add(time.Second * -10)
+	OneMinuteAgo := ts.Add(time.Minute * -1)
+
+	// Starts within the tolerance OK
+	err = ValidateRecord(iprsKey, NewRecord(1, &InTenSeconds, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Starts beyond the tolerance FAIL
+	err = ValidateRecord(iprsKey, NewRecord(1, &InOneMinute, nil))
+	if err != ErrPendingRecord {
+		t.Fatal("Expected pending error")
+	}
+
+	// Expired within the tolerance OK
+	err = ValidateRecord(iprsKey, NewRecord(1, nil, &TenSecondsAgo))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Expired beyond the tolerance FAIL
+	err = ValidateRecord(iprsKey, NewRecord(1, nil, &OneMinuteAgo))
+	if err != ErrExpiredRecord {
+		t.Fatal("Expected expired error")
+	}
 }
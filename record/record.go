@@ -3,13 +3,17 @@ package iprs_record
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	clock "github.com/dirkmc/go-iprs/clock"
 	rsp "github.com/dirkmc/go-iprs/path"
 	pb "github.com/dirkmc/go-iprs/pb"
 	path "github.com/ipfs/go-ipfs/path"
 	logging "github.com/ipfs/go-log"
 	routing "gx/ipfs/QmPCGUjMRuBcPybZFpjhzpifwPP9wPRoiy5geTQKU4vqWA/go-libp2p-routing"
 	proto "gx/ipfs/QmZ4Qi3GaRbjcx28Sme5eMH7RQjGkt8wHxt2a65oLaeFEV/gogo-protobuf/proto"
+	"sync"
 	"time"
 )
 
@@ -17,6 +21,32 @@ const PublishPutValTimeout = time.Second * 10
 
 var log = logging.Logger("iprs.record")
 
+const (
+	// SignatureV1 signs the concatenation of the record value, validity
+	// and verification fields. It is only supported for verification of
+	// records published before SignatureV2 was introduced.
+	SignatureV1 uint32 = 1
+	// SignatureV2 signs a domain separated, length-prefixed encoding of
+	// the record fields, including the sequence number and IPRS key
+	SignatureV2 uint32 = 2
+)
+
+// sigV2Domain prefixes SignatureV2 signing data so that it can never be
+// mistaken for any other type of signed data
+const sigV2Domain = "iprs-record-signature-v2\x00"
+
+// ErrUnknownSignatureVersion is returned when an IprsEntry has a
+// signature version that is not recognized
+var ErrUnknownSignatureVersion = errors.New("unknown signature version")
+
+// ErrNoPrivateKey is returned when a RecordSigner that does not have a
+// private key (because the key is kept offline) is asked to sign an entry
+var ErrNoPrivateKey = errors.New("signer does not have a private key")
+
+// ErrEntryMismatch is returned when a signed entry does not have the
+// verification data of the record it is published with
+var ErrEntryMismatch = errors.New("entry verification does not match record")
+
 type RecordValidity interface {
 	ValidityType() *pb.IprsEntry_ValidityType
 	// Return the validity data for the record
@@ -39,7 +69,8 @@ type RecordSigner interface {
 	// Publish any data required for verification to the network
 	// eg public key, certificate etc
 	PublishVerification(ctx context.Context, iprsKey rsp.IprsPath, entry *pb.IprsEntry) error
-	SignRecord(entry *pb.IprsEntry) error
+	// Sign the entry that will be published at the given IPRS key
+	SignRecord(iprsKey rsp.IprsPath, entry *pb.IprsEntry) error
 }
 
 type RecordVerifier interface {
@@ -52,6 +83,13 @@ type Record struct {
 	vl      RecordValidity
 	s       RecordSigner
 	val     path.Path
+	ttl     *time.Duration
+	retry   RetryPolicy
+	// Tombstones have no value, and say that the name has been deleted
+	tombstone bool
+	// The factory that created the record, used to check entries
+	// before they are published
+	f *RecordFactory
 }
 
 func NewRecord(r routing.ValueStore, vl RecordValidity, s RecordSigner, val path.Path) *Record {
@@ -60,10 +98,48 @@ func NewRecord(r routing.ValueStore, vl RecordValidity, s RecordSigner, val path
 		vl:      vl,
 		s:       s,
 		val:     val,
+		retry:   NoRetry,
+	}
+}
+
+// SetTtl sets how long resolvers may cache the record for. If no TTL
+// is set, resolvers use their own default.
+func (r *Record) SetTtl(ttl time.Duration) {
+	r.ttl = &ttl
+}
+
+// SetRetryPolicy sets how puts to routing are retried when they fail
+// with a transient error. By default they are not retried.
+func (r *Record) SetRetryPolicy(p RetryPolicy) {
+	r.retry = p
+}
+
+// getClock returns the clock of the factory that created the record, or the
+// system clock if it was not created by a factory
+func (r *Record) getClock() clock.Clock {
+	if r.f == nil {
+		return clock.RealClock
+	}
+	return r.f.clock
+}
+
+func (r *Record) Entry(iprsKey rsp.IprsPath, seq uint64) (*pb.IprsEntry, error) {
+	entry, err := r.UnsignedEntry(seq)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.s.SignRecord(iprsKey, entry)
+	if err != nil {
+		return nil, err
 	}
+
+	return entry, nil
 }
 
-func (r *Record) Entry(seq uint64) (*pb.IprsEntry, error) {
+// UnsignedEntry creates an entry without a signature, so that it can be
+// signed elsewhere, eg on a machine that holds an offline private key
+func (r *Record) UnsignedEntry(seq uint64) (*pb.IprsEntry, error) {
 	entry := new(pb.IprsEntry)
 
 	validity, err := r.vl.Validity()
@@ -81,11 +157,13 @@ func (r *Record) Entry(seq uint64) (*pb.IprsEntry, error) {
 	entry.Validity = validity
 	entry.VerificationType = r.s.VerificationType()
 	entry.Verification = verification
-
-	err = r.s.SignRecord(entry)
-	if err != nil {
-		return nil, err
+	if r.ttl != nil {
+		entry.Ttl = proto.Uint64(uint64(*r.ttl))
 	}
+	if r.tombstone {
+		entry.ValueType = pb.IprsEntry_Tombstone.Enum()
+	}
+	entry.SignatureVersion = proto.Uint32(SignatureV2)
 
 	return entry, nil
 }
@@ -95,34 +173,108 @@ func (r *Record) BasePath() (rsp.IprsPath, error) {
 }
 
 func (r *Record) Publish(ctx context.Context, iprsKey rsp.IprsPath, seq uint64) error {
-	// TODO: Check iprsKey is valid for this type of RecordSigner
+	_, err := r.PublishWithReport(ctx, iprsKey, seq)
+	return err
+}
 
-	entry, err := r.Entry(seq)
+// PublishWithReport signs and publishes the entry with the given sequence
+// number, and reports the outcome of each step. It waits for every step
+// to finish, so if it returns an error the report shows what was stored.
+func (r *Record) PublishWithReport(ctx context.Context, iprsKey rsp.IprsPath, seq uint64) (*PublishReport, error) {
+	report := new(PublishReport)
+	entry, err := r.DryRun(ctx, iprsKey, seq)
 	if err != nil {
-		return err
+		return report, err
 	}
 
 	// Put the verification data and the record itself to routing
-	resp := make(chan error, 2)
-
+	var wg sync.WaitGroup
+	wg.Add(2)
 	go func() {
-		resp <- r.s.PublishVerification(ctx, iprsKey, entry)
+		defer wg.Done()
+		r.retry.run(ctx, r.getClock(), &report.Verification, func() error {
+			return r.s.PublishVerification(ctx, iprsKey, entry)
+		})
 	}()
 	go func() {
-		resp <- r.putEntryToRouting(ctx, iprsKey, entry)
+		defer wg.Done()
+		r.retry.run(ctx, r.getClock(), &report.Entry, func() error {
+			return r.putEntryToRouting(ctx, iprsKey, entry)
+		})
 	}()
+	wg.Wait()
 
-	for i := 0; i < 2; i++ {
-		err = <-resp
-		if err != nil {
-			return err
-		}
+	return report, report.Err()
+}
+
+// DryRun signs the entry with the given sequence number and checks that
+// it would be accepted by the network, without publishing anything. If
+// a check fails it returns a *SelfCheckError. Records that were not
+// created by a RecordFactory can only be checked against their key.
+func (r *Record) DryRun(ctx context.Context, iprsKey rsp.IprsPath, seq uint64) (*pb.IprsEntry, error) {
+	entry, err := r.Entry(iprsKey, seq)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	if r.f != nil {
+		err = r.f.SelfCheck(ctx, r.s, iprsKey, entry)
+	} else if err = checkKey(ctx, r.s, iprsKey, nil); err != nil {
+		err = &SelfCheckError{CheckKey, err}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// PublishEntry publishes an entry that was signed elsewhere (see
+// UnsignedEntry). The entry must have the record's verification data,
+// and is validated and verified with the factory before it is put to
+// routing.
+func (r *Record) PublishEntry(ctx context.Context, iprsKey rsp.IprsPath, entry *pb.IprsEntry, f *RecordFactory) error {
+	verification, err := r.s.Verification()
+	if err != nil {
+		return err
+	}
+	if entry.GetVerificationType() != *r.s.VerificationType() || !bytes.Equal(entry.GetVerification(), verification) {
+		return ErrEntryMismatch
+	}
+
+	err = f.Validate(iprsKey, entry)
+	if err != nil {
+		return err
+	}
+
+	// The verification data (eg public key) must be published before
+	// the entry can be verified
+	err = r.retry.run(ctx, f.clock, new(PublishStep), func() error {
+		return r.s.PublishVerification(ctx, iprsKey, entry)
+	})
+	if err != nil {
+		return err
+	}
+	err = f.Verify(ctx, iprsKey, entry)
+	if err != nil {
+		return err
+	}
+
+	return r.retry.run(ctx, f.clock, new(PublishStep), func() error {
+		return r.putEntryToRouting(ctx, iprsKey, entry)
+	})
+}
+
+// PublishVerification publishes the data required to verify the entry
+// (eg public key, certificate etc), without publishing the entry itself
+func (r *Record) PublishVerification(ctx context.Context, iprsKey rsp.IprsPath, entry *pb.IprsEntry) error {
+	return r.s.PublishVerification(ctx, iprsKey, entry)
 }
 
 func (r *Record) putEntryToRouting(ctx context.Context, iprsKey rsp.IprsPath, entry *pb.IprsEntry) error {
+	return putEntryToRouting(ctx, r.routing, iprsKey, entry)
+}
+
+func putEntryToRouting(ctx context.Context, r routing.ValueStore, iprsKey rsp.IprsPath, entry *pb.IprsEntry) error {
 	data, err := proto.Marshal(entry)
 	if err != nil {
 		return err
@@ -132,10 +284,44 @@ func (r *Record) putEntryToRouting(ctx context.Context, iprsKey rsp.IprsPath, en
 	defer cancel()
 
 	log.Debugf("Storing iprs entry at %s", iprsKey)
-	return r.routing.PutValue(timectx, iprsKey.String(), data)
+	return r.PutValue(timectx, iprsKey.String(), data)
 }
 
-func RecordDataForSig(r *pb.IprsEntry) []byte {
+// RecordDataForSig returns the data that should be signed (or verified)
+// for the entry, according to the entry's signature version
+func RecordDataForSig(iprsKey rsp.IprsPath, r *pb.IprsEntry) ([]byte, error) {
+	switch GetSignatureVersion(r) {
+	case SignatureV1:
+		return RecordDataForSigV1(r), nil
+	case SignatureV2:
+		return RecordDataForSigV2(iprsKey, r), nil
+	}
+	return nil, ErrUnknownSignatureVersion
+}
+
+// GetSignatureVersion returns the signature version of the entry.
+// Entries without a signature version were signed with SignatureV1.
+func GetSignatureVersion(r *pb.IprsEntry) uint32 {
+	if r.SignatureVersion == nil {
+		return SignatureV1
+	}
+	return r.GetSignatureVersion()
+}
+
+// GetTtl returns the TTL of the entry, or false if the entry does not
+// have a TTL. The TTL is ignored for SignatureV1 entries because it is
+// not covered by the signature.
+func GetTtl(r *pb.IprsEntry) (time.Duration, bool) {
+	if r.Ttl == nil || GetSignatureVersion(r) == SignatureV1 {
+		return 0, false
+	}
+	return time.Duration(r.GetTtl()), true
+}
+
+// RecordDataForSigV1 does not include the sequence number or IPRS key,
+// and fields are not length-prefixed, so it should not be used to sign
+// new records
+func RecordDataForSigV1(r *pb.IprsEntry) []byte {
 	return bytes.Join([][]byte{
 		r.Value,
 		[]byte(fmt.Sprint(r.GetValidityType())),
@@ -145,3 +331,47 @@ func RecordDataForSig(r *pb.IprsEntry) []byte {
 	},
 		[]byte{})
 }
+
+// RecordDataForSigV2 encodes each field as
+// <field number><big endian uint64 length><data>
+// in field number order, after a domain separation prefix.
+// Optional fields that are not set are omitted.
+func RecordDataForSigV2(iprsKey rsp.IprsPath, r *pb.IprsEntry) []byte {
+	buf := new(bytes.Buffer)
+	buf.WriteString(sigV2Domain)
+	writeSigField(buf, 0, iprsKey.Bytes())
+	writeSigField(buf, 1, r.Value)
+	writeSigUint(buf, 3, uint64(r.GetVerificationType()))
+	writeSigField(buf, 4, r.Verification)
+	if r.ValidityType != nil {
+		writeSigUint(buf, 5, uint64(r.GetValidityType()))
+	}
+	if r.Validity != nil {
+		writeSigField(buf, 6, r.Validity)
+	}
+	if r.Sequence != nil {
+		writeSigUint(buf, 7, r.GetSequence())
+	}
+	writeSigUint(buf, 8, uint64(SignatureV2))
+	if r.Ttl != nil {
+		writeSigUint(buf, 9, r.GetTtl())
+	}
+	if r.ValueType != nil {
+		writeSigUint(buf, 10, uint64(r.GetValueType()))
+	}
+	return buf.Bytes()
+}
+
+func writeSigField(buf *bytes.Buffer, field byte, data []byte) {
+	var l [8]byte
+	binary.BigEndian.PutUint64(l[:], uint64(len(data)))
+	buf.WriteByte(field)
+	buf.Write(l[:])
+	buf.Write(data)
+}
+
+func writeSigUint(buf *bytes.Buffer, field byte, v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	writeSigField(buf, field, b[:])
+}
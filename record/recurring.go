@@ -0,0 +1,371 @@
+package iprs_record
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	_ "time/tzdata"
+
+	clock "github.com/dirkmc/go-iprs/clock"
+	rsp "github.com/dirkmc/go-iprs/path"
+	pb "github.com/dirkmc/go-iprs/pb"
+)
+
+// ErrRecurringWindow should be returned when an attempt is made to
+// construct a Recurring record with an invalid window
+var ErrRecurringWindow = errors.New("invalid recurring time window")
+
+// ErrOutsideWindow should be returned when an Iprs record is
+// invalid due to the current time being outside its recurring window
+var ErrOutsideWindow = errors.New("record not valid outside its recurring time window")
+
+const recurringTimeFormat = "15:04"
+
+// Time zones that have been loaded, by name, so that each is only loaded
+// once rather than every time a record is validated
+var locations = struct {
+	sync.Mutex
+	m map[string]*time.Location
+}{m: make(map[string]*time.Location)}
+
+// RecurringWindow is a daily time window, eg 09:00 to 17:00, on certain
+// days of the week. If To is before From, the window ends on the
+// following day, eg 22:00 to 02:00.
+type RecurringWindow struct {
+	// Days on which the window starts, indexed by time.Weekday
+	Days [7]bool
+	// Offset from midnight at which the window starts
+	From time.Duration
+	// Offset from midnight at which the window ends
+	To time.Duration
+}
+
+// NewRecurringWindow creates a window from "HH:MM" times on the given
+// days of the week
+func NewRecurringWindow(days []time.Weekday, from, to string) (*RecurringWindow, error) {
+	w := new(RecurringWindow)
+	for _, d := range days {
+		if d < time.Sunday || d > time.Saturday {
+			return nil, ErrRecurringWindow
+		}
+		w.Days[d] = true
+	}
+
+	var err error
+	w.From, err = parseTimeOfDay(from)
+	if err != nil {
+		return nil, err
+	}
+	w.To, err = parseTimeOfDay(to)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = w.check(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *RecurringWindow) check() error {
+	anyDay := false
+	for _, d := range w.Days {
+		anyDay = anyDay || d
+	}
+	if !anyDay || w.From == w.To {
+		return ErrRecurringWindow
+	}
+	if w.From < 0 || w.From >= 24*time.Hour || w.To < 0 || w.To >= 24*time.Hour {
+		return ErrRecurringWindow
+	}
+	return nil
+}
+
+// String formats the window as eg "Mon,Tue,Wed,Thu,Fri;09:00-17:00"
+func (w *RecurringWindow) String() string {
+	var days []string
+	for d, ok := range w.Days {
+		if ok {
+			days = append(days, time.Weekday(d).String()[:3])
+		}
+	}
+	return strings.Join(days, ",") + ";" + formatTimeOfDay(w.From) + "-" + formatTimeOfDay(w.To)
+}
+
+// Around returns the start and end of the window occurrence that
+// contains t, in the given location. If t is not within an
+// occurrence of the window, ok is false.
+func (w *RecurringWindow) Around(t time.Time, loc *time.Location) (start time.Time, end time.Time, ok bool) {
+	t = t.In(loc)
+	// The occurrence containing t either started today or, if it
+	// runs past midnight, yesterday
+	for _, daysAgo := range []int{0, 1} {
+		start, end = w.occurrence(t.AddDate(0, 0, -daysAgo), loc)
+		if w.Days[start.Weekday()] && !t.Before(start) && t.Before(end) {
+			return start, end, true
+		}
+	}
+	return time.Time{}, time.Time{}, false
+}
+
+// occurrence returns the start and end of the window that starts on
+// the same day as t
+func (w *RecurringWindow) occurrence(t time.Time, loc *time.Location) (time.Time, time.Time) {
+	// Use the wall clock time so that windows are correct
+	// across daylight savings changes
+	y, m, d := t.Date()
+	start := time.Date(y, m, d, int(w.From/time.Hour), int((w.From%time.Hour)/time.Minute), 0, 0, loc)
+	if w.To < w.From {
+		d++
+	}
+	end := time.Date(y, m, d, int(w.To/time.Hour), int((w.To%time.Hour)/time.Minute), 0, 0, loc)
+	return start, end
+}
+
+func parseRecurringWindow(s string) (*RecurringWindow, error) {
+	parts := strings.Split(s, ";")
+	if len(parts) != 2 {
+		return nil, ErrRecurringWindow
+	}
+	times := strings.Split(parts[1], "-")
+	if len(times) != 2 {
+		return nil, ErrRecurringWindow
+	}
+
+	var days []time.Weekday
+	for _, name := range strings.Split(parts[0], ",") {
+		found := false
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			if d.String()[:3] == name {
+				days = append(days, d)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("Invalid day [%s] in recurring window", name)
+		}
+	}
+
+	return NewRecurringWindow(days, times[0], times[1])
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse(recurringTimeFormat, s)
+	if err != nil {
+		return 0, ErrRecurringWindow
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func formatTimeOfDay(d time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(d/time.Hour), int((d%time.Hour)/time.Minute))
+}
+
+// RecurringRecordValidity is valid during a recurring window (eg business
+// hours on weekdays) between an overall start and end time. A nil start
+// time means the beginning of time and a nil end time means the end
+// of time.
+type RecurringRecordValidity struct {
+	rng    *RangeRecordValidity
+	loc    *time.Location
+	window *RecurringWindow
+}
+
+// The location must be UTC or a named time zone, eg "America/New_York"
+// so that it can be loaded by other nodes
+func NewRecurringRecordValidity(start *time.Time, end *time.Time, loc *time.Location, window *RecurringWindow) (*RecurringRecordValidity, error) {
+	rng, err := NewRangeRecordValidity(start, end)
+	if err != nil {
+		return nil, err
+	}
+	if loc == nil || loc == time.Local || loc.String() == "Local" {
+		return nil, fmt.Errorf("Recurring record time zone must be UTC or a named time zone")
+	}
+	if err = window.check(); err != nil {
+		return nil, err
+	}
+	return &RecurringRecordValidity{rng, loc, window}, nil
+}
+
+// Validity is formatted as
+// <start>~<end>;<time zone>;<days>;<from>-<to>
+// eg
+// 2018-01-01T00:00:00Z~∞;Europe/Paris;Mon,Tue,Wed,Thu,Fri;09:00-17:00
+func (v *RecurringRecordValidity) Validity() ([]byte, error) {
+	rng, err := v.rng.Validity()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(string(rng) + ";" + v.loc.String() + ";" + v.window.String()), nil
+}
+
+func (v *RecurringRecordValidity) ValidityType() *pb.IprsEntry_ValidityType {
+	t := pb.IprsEntry_Recurring
+	return &t
+}
+
+// RecurringValidity is the parsed validity of a Recurring record
+type RecurringValidity struct {
+	// A nil start time means the beginning of time and a nil end time
+	// means the end of time
+	Range    *[2]*time.Time
+	Location *time.Location
+	Window   *RecurringWindow
+}
+
+func RecurringParseValidity(r *pb.IprsEntry) (*RecurringValidity, error) {
+	parts := strings.SplitN(string(r.GetValidity()), ";", 3)
+	if len(parts) != 3 {
+		return nil, errors.New("Invalid Recurring record")
+	}
+
+	rng, err := parseTimeRange(parts[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if parts[1] == "Local" || parts[1] == "" {
+		return nil, fmt.Errorf("Invalid time zone [%s] in Recurring record", parts[1])
+	}
+	loc, err := loadLocation(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("Invalid time zone [%s] in Recurring record", parts[1])
+	}
+
+	w, err := parseRecurringWindow(parts[2])
+	if err != nil {
+		return nil, err
+	}
+
+	return &RecurringValidity{rng, loc, w}, nil
+}
+
+// loadLocation loads the named time zone, or returns it from the cache
+// if it has already been loaded. Time zones are loaded from the system's
+// tz database, or from the copy embedded in the binary (time/tzdata) if
+// the system doesn't have one. Nodes with different versions of the
+// database may disagree about the window of a record in a time zone
+// whose rules have changed.
+func loadLocation(name string) (*time.Location, error) {
+	locations.Lock()
+	defer locations.Unlock()
+
+	if loc, ok := locations.m[name]; ok {
+		return loc, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locations.m[name] = loc
+	return loc, nil
+}
+
+// RecurringValidityCheck checks that the given time is within the entry's
+// overall time range and within an occurrence of its recurring window
+func RecurringValidityCheck(entry *pb.IprsEntry, now time.Time, tolerance SkewTolerance) error {
+	rv, err := RecurringParseValidity(entry)
+	if err != nil {
+		log.Warning("Failed to parse IPRS Recurring record")
+		return err
+	}
+	if rv.Range[0] != nil && tolerance.isPending(*rv.Range[0], now) {
+		return ErrPendingRecord
+	}
+	if rv.Range[1] != nil && tolerance.isExpired(*rv.Range[1], now) {
+		return ErrExpiredRecord
+	}
+
+	// Allow for clock skew at either end of the window
+	for _, t := range []time.Time{now, now.Add(tolerance.Pending), now.Add(-tolerance.Expired)} {
+		if _, _, ok := rv.Window.Around(t, rv.Location); ok {
+			return nil
+		}
+	}
+	return ErrOutsideWindow
+}
+
+// RecurringWindowEnd returns the time at which the occurrence of the
+// entry's window that contains the given time ends (or the entry's
+// overall end time if that is sooner). If the given time is not within
+// an occurrence of the window, ok is false.
+func RecurringWindowEnd(entry *pb.IprsEntry, now time.Time) (end time.Time, ok bool, err error) {
+	rv, err := RecurringParseValidity(entry)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	_, end, ok = rv.Window.Around(now, rv.Location)
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	if rv.Range[1] != nil && rv.Range[1].Before(end) {
+		end = *rv.Range[1]
+	}
+	return end, true, nil
+}
+
+// recurringRecordChecker
+
+type recurringRecordChecker struct {
+	clock     clock.Clock
+	tolerance SkewTolerance
+}
+
+// NewRecurringRecordChecker creates a RecordChecker for Recurring records
+// that checks the window against the given clock, allowing for the given
+// amount of clock skew
+func NewRecurringRecordChecker(clk clock.Clock, tolerance SkewTolerance) *recurringRecordChecker {
+	return &recurringRecordChecker{clock.OrRealClock(clk), tolerance}
+}
+
+func (v *recurringRecordChecker) ValidateRecord(iprsKey rsp.IprsPath, entry *pb.IprsEntry) error {
+	return RecurringValidityCheck(entry, v.clock.Now(), v.tolerance)
+}
+
+func (v *recurringRecordChecker) SelectRecord(recs []*pb.IprsEntry, vals [][]byte) (int, error) {
+	var best_seq uint64
+	best_i := -1
+
+	for i, r := range recs {
+		// Best record is the one with the highest sequence number
+		if r == nil || r.GetSequence() < best_seq {
+			continue
+		}
+
+		if best_i == -1 || r.GetSequence() > best_seq {
+			best_seq = r.GetSequence()
+			best_i = i
+		} else if r.GetSequence() == best_seq {
+			// If sequence number is equal, look at the overall time range
+			t, err := RecurringParseValidity(r)
+			if err != nil {
+				continue
+			}
+
+			bestt, err := RecurringParseValidity(recs[best_i])
+			if err != nil {
+				continue
+			}
+
+			cmp := CompareTimeRanges(t.Range, bestt.Range)
+			if cmp > 0 {
+				best_i = i
+			} else if cmp == 0 {
+				// This is just to make sure the selection is deterministic
+				if bytes.Compare(vals[i], vals[best_i]) > 0 {
+					best_i = i
+				}
+			}
+		}
+	}
+	if best_i == -1 {
+		return 0, errors.New("no usable records in given set")
+	}
+
+	return best_i, nil
+}
+
+var RecurringRecordChecker = NewRecurringRecordChecker(clock.RealClock, SkewTolerance{})
@@ -0,0 +1,352 @@
+package iprs_record
+
+import (
+	"testing"
+	"time"
+
+	clock "github.com/dirkmc/go-iprs/clock"
+	rsp "github.com/dirkmc/go-iprs/path"
+	pb "github.com/dirkmc/go-iprs/pb"
+	u "github.com/ipfs/go-ipfs-util"
+	path "github.com/ipfs/go-ipfs/path"
+	ci "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
+)
+
+var weekdays = []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+
+// This is just so we can get an IprsEntry for a given sequence number,
+// overall time range and window
+func setupNewRecurringRecordFunc(t *testing.T) func(uint64, *time.Time, *time.Time, *time.Location, *RecurringWindow) *pb.IprsEntry {
+	// generate a key for signing the records
+	sr := u.NewSeededRand(15) // generate deterministic keypair
+	pk, _, err := ci.GenerateKeyPairWithReader(ci.RSA, 1024, sr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := NewRecordFactory(nil, nil)
+	iprsKey := getIprsPathFromKey(t, pk)
+
+	return func(seq uint64, start *time.Time, end *time.Time, loc *time.Location, w *RecurringWindow) *pb.IprsEntry {
+		vl, err := NewRecurringRecordValidity(start, end, loc, w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := f.NewRecord(vl, f.NewKeyRecordSigner(pk), path.Path("foo"))
+		e, err := r.Entry(iprsKey, seq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+}
+
+func newWindow(t *testing.T, days []time.Weekday, from, to string) *RecurringWindow {
+	w, err := NewRecurringWindow(days, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func TestNewRecurringRecord(t *testing.T) {
+	ts := time.Now()
+	InOneHour := ts.Add(time.Hour)
+
+	// Valid windows OK
+	w := newWindow(t, weekdays, "09:00", "17:00")
+	_, err := NewRecurringRecordValidity(&ts, &InOneHour, time.UTC, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewRecurringRecordValidity(nil, nil, time.UTC, newWindow(t, weekdays, "22:00", "02:00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// End before start FAIL
+	_, err = NewRecurringRecordValidity(&InOneHour, &ts, time.UTC, w)
+	if err != ErrRecordTimeRange {
+		t.Fatal("Expected end before start error")
+	}
+
+	// Local time zone FAIL
+	_, err = NewRecurringRecordValidity(nil, nil, time.Local, w)
+	if err == nil {
+		t.Fatal("Expected time zone error")
+	}
+
+	// No days FAIL
+	_, err = NewRecurringWindow(nil, "09:00", "17:00")
+	if err != ErrRecurringWindow {
+		t.Fatal("Expected recurring window error")
+	}
+
+	// Empty window FAIL
+	_, err = NewRecurringWindow(weekdays, "09:00", "09:00")
+	if err != ErrRecurringWindow {
+		t.Fatal("Expected recurring window error")
+	}
+
+	// Invalid time FAIL
+	_, err = NewRecurringWindow(weekdays, "09:00", "24:30")
+	if err != ErrRecurringWindow {
+		t.Fatal("Expected recurring window error")
+	}
+}
+
+func TestRecurringValidityRoundTrip(t *testing.T) {
+	NewRecord := setupNewRecurringRecordFunc(t)
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Unix(1000000, 0).UTC()
+
+	e := NewRecord(1, &start, nil, ny, newWindow(t, weekdays, "09:00", "17:30"))
+	exp := "1970-01-12T13:46:40Z~∞;America/New_York;Mon,Tue,Wed,Thu,Fri;09:00-17:30"
+	if string(e.GetValidity()) != exp {
+		t.Fatalf("Expected validity %s but got %s", exp, e.GetValidity())
+	}
+
+	rv, err := RecurringParseValidity(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rv.Range[0].Equal(start) || rv.Range[1] != nil {
+		t.Fatal("Expected overall time range to round trip")
+	}
+	if rv.Location.String() != "America/New_York" {
+		t.Fatal("Expected time zone to round trip")
+	}
+	if rv.Window.String() != "Mon,Tue,Wed,Thu,Fri;09:00-17:30" {
+		t.Fatal("Expected window to round trip")
+	}
+
+	// The time zone is only loaded once
+	rv2, err := RecurringParseValidity(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rv2.Location != rv.Location {
+		t.Fatal("Expected time zone to be loaded from the cache")
+	}
+
+	// Unknown and local time zones are rejected
+	for _, tz := range []string{"Nowhere/Special", "Local", ""} {
+		e.Validity = []byte("1970-01-12T13:46:40Z~∞;" + tz + ";Mon;09:00-17:30")
+		_, err = RecurringParseValidity(e)
+		if err == nil {
+			t.Fatalf("Expected error for time zone [%s]", tz)
+		}
+	}
+}
+
+func TestRecurringValidation(t *testing.T) {
+	NewRecord := setupNewRecurringRecordFunc(t)
+	// Monday 2018-01-01 at 10:00 UTC
+	ts := time.Date(2018, 1, 1, 10, 0, 0, 0, time.UTC)
+	clk := clock.NewMockClock(ts)
+	ValidateRecord := NewRecurringRecordChecker(clk, SkewTolerance{}).ValidateRecord
+	iprsKey, err := rsp.FromString("/iprs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	OneHourAgo := ts.Add(time.Hour * -1)
+	InOneHour := ts.Add(time.Hour)
+
+	business := NewRecord(1, nil, nil, time.UTC, newWindow(t, weekdays, "09:00", "17:00"))
+	overnight := NewRecord(1, nil, nil, time.UTC, newWindow(t, weekdays, "22:00", "02:00"))
+	pending := NewRecord(1, &InOneHour, nil, time.UTC, newWindow(t, weekdays, "09:00", "17:00"))
+	expired := NewRecord(1, nil, &OneHourAgo, time.UTC, newWindow(t, weekdays, "09:00", "17:00"))
+
+	// Within business hours OK
+	err = ValidateRecord(iprsKey, business)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ValidateRecord(iprsKey, overnight)
+	if err != ErrOutsideWindow {
+		t.Fatal("Expected outside window error")
+	}
+	err = ValidateRecord(iprsKey, pending)
+	if err != ErrPendingRecord {
+		t.Fatal("Expected pending error")
+	}
+	err = ValidateRecord(iprsKey, expired)
+	if err != ErrExpiredRecord {
+		t.Fatal("Expected expired error")
+	}
+
+	// Monday at 23:00
+	clk.Set(time.Date(2018, 1, 1, 23, 0, 0, 0, time.UTC))
+	err = ValidateRecord(iprsKey, business)
+	if err != ErrOutsideWindow {
+		t.Fatal("Expected outside window error")
+	}
+	err = ValidateRecord(iprsKey, overnight)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Saturday at 01:00, the overnight window started on Friday
+	clk.Set(time.Date(2018, 1, 6, 1, 0, 0, 0, time.UTC))
+	err = ValidateRecord(iprsKey, overnight)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Sunday at 01:00, no window started on Saturday
+	clk.Set(time.Date(2018, 1, 7, 1, 0, 0, 0, time.UTC))
+	err = ValidateRecord(iprsKey, overnight)
+	if err != ErrOutsideWindow {
+		t.Fatal("Expected outside window error")
+	}
+
+	// Saturday at 10:00
+	clk.Set(time.Date(2018, 1, 6, 10, 0, 0, 0, time.UTC))
+	err = ValidateRecord(iprsKey, business)
+	if err != ErrOutsideWindow {
+		t.Fatal("Expected outside window error")
+	}
+}
+
+func TestRecurringValidationTimeZone(t *testing.T) {
+	NewRecord := setupNewRecurringRecordFunc(t)
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Monday 2018-01-01 at 15:00 UTC is 10:00 in New York
+	clk := clock.NewMockClock(time.Date(2018, 1, 1, 15, 0, 0, 0, time.UTC))
+	ValidateRecord := NewRecurringRecordChecker(clk, SkewTolerance{}).ValidateRecord
+	iprsKey, err := rsp.FromString("/iprs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := NewRecord(1, nil, nil, ny, newWindow(t, weekdays, "09:00", "11:00"))
+	err = ValidateRecord(iprsKey, e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 10:00 UTC is 05:00 in New York
+	clk.Set(time.Date(2018, 1, 1, 10, 0, 0, 0, time.UTC))
+	err = ValidateRecord(iprsKey, e)
+	if err != ErrOutsideWindow {
+		t.Fatal("Expected outside window error")
+	}
+}
+
+func TestRecurringValidationSkewTolerance(t *testing.T) {
+	NewRecord := setupNewRecurringRecordFunc(t)
+	// Monday 2018-01-01 at 08:59:50 UTC
+	ts := time.Date(2018, 1, 1, 8, 59, 50, 0, time.UTC)
+	tolerance := SkewTolerance{Pending: time.Second * 30, Expired: time.Second * 30}
+	clk := clock.NewMockClock(ts)
+	ValidateRecord := NewRecurringRecordChecker(clk, tolerance).ValidateRecord
+	iprsKey, err := rsp.FromString("/iprs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := NewRecord(1, nil, nil, time.UTC, newWindow(t, weekdays, "09:00", "17:00"))
+
+	// Window starts within the tolerance OK
+	err = ValidateRecord(iprsKey, e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Window starts beyond the tolerance FAIL
+	clk.Set(time.Date(2018, 1, 1, 8, 59, 0, 0, time.UTC))
+	err = ValidateRecord(iprsKey, e)
+	if err != ErrOutsideWindow {
+		t.Fatal("Expected outside window error")
+	}
+
+	// Window ended within the tolerance OK
+	clk.Set(time.Date(2018, 1, 1, 17, 0, 10, 0, time.UTC))
+	err = ValidateRecord(iprsKey, e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Window ended beyond the tolerance FAIL
+	clk.Set(time.Date(2018, 1, 1, 17, 1, 0, 0, time.UTC))
+	err = ValidateRecord(iprsKey, e)
+	if err != ErrOutsideWindow {
+		t.Fatal("Expected outside window error")
+	}
+}
+
+func TestRecurringWindowEnd(t *testing.T) {
+	NewRecord := setupNewRecurringRecordFunc(t)
+	// Monday 2018-01-01 at 10:00 UTC
+	ts := time.Date(2018, 1, 1, 10, 0, 0, 0, time.UTC)
+	InOneHour := ts.Add(time.Hour)
+
+	e := NewRecord(1, nil, nil, time.UTC, newWindow(t, weekdays, "09:00", "17:00"))
+	end, ok, err := RecurringWindowEnd(e, ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || !end.Equal(time.Date(2018, 1, 1, 17, 0, 0, 0, time.UTC)) {
+		t.Fatal("Expected window to end at 17:00")
+	}
+
+	// Overall end time is before the end of the window
+	e = NewRecord(1, nil, &InOneHour, time.UTC, newWindow(t, weekdays, "09:00", "17:00"))
+	end, ok, err = RecurringWindowEnd(e, ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || !end.Equal(InOneHour) {
+		t.Fatal("Expected window to end at overall end time")
+	}
+
+	// Not in a window
+	_, ok, err = RecurringWindowEnd(e, ts.Add(time.Hour*-2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("Expected to be outside window")
+	}
+}
+
+func TestRecurringOrdering(t *testing.T) {
+	NewRecord := setupNewRecurringRecordFunc(t)
+
+	// select timestamp so selection is deterministic
+	ts := time.Unix(1000000, 0)
+	InOneHour := ts.Add(time.Hour)
+	InTwoHours := ts.Add(time.Hour * 2)
+	w := newWindow(t, weekdays, "09:00", "17:00")
+
+	e1 := NewRecord(1, &ts, &InOneHour, time.UTC, w)
+	e2 := NewRecord(2, &ts, &InOneHour, time.UTC, w)
+	e3 := NewRecord(2, &ts, &InTwoHours, time.UTC, w)
+	e4 := NewRecord(2, nil, &InTwoHours, time.UTC, w)
+	e5 := NewRecord(3, &ts, &InOneHour, time.UTC, w)
+
+	// e1 is the only record
+	assertRecurringSelected(t, e1, e1)
+	// e2 has the highest sequence number
+	assertRecurringSelected(t, e2, e1, e2)
+	// e3 has a later end time
+	assertRecurringSelected(t, e3, e1, e2, e3)
+	// e4 has an earlier start time
+	assertRecurringSelected(t, e4, e1, e2, e3, e4)
+	// e5 has the highest sequence number
+	assertRecurringSelected(t, e5, e1, e2, e3, e4, e5)
+}
+
+func assertRecurringSelected(t *testing.T, r *pb.IprsEntry, from ...*pb.IprsEntry) {
+	err := AssertSelected(RecurringRecordChecker.SelectRecord, r, from)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,161 @@
+package iprs_record
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"sync"
+
+	rsp "github.com/dirkmc/go-iprs/path"
+	pb "github.com/dirkmc/go-iprs/pb"
+	routing "gx/ipfs/QmPCGUjMRuBcPybZFpjhzpifwPP9wPRoiy5geTQKU4vqWA/go-libp2p-routing"
+)
+
+// ErrKeyMismatch is returned when a record is published at an IPRS key
+// that its signer cannot sign records for
+var ErrKeyMismatch = errors.New("iprs key does not match record signer")
+
+// PublishCheck is a check that a record must pass before it is published
+type PublishCheck int
+
+const (
+	// The IPRS key matches the signer's BasePath
+	CheckKey PublishCheck = iota
+	// The validity checker accepts the entry, eg it has not expired
+	CheckValidity
+	// The verifier accepts the entry's signature
+	CheckVerification
+)
+
+func (c PublishCheck) String() string {
+	switch c {
+	case CheckKey:
+		return "key"
+	case CheckValidity:
+		return "validity"
+	case CheckVerification:
+		return "verification"
+	}
+	return fmt.Sprintf("PublishCheck(%d)", int(c))
+}
+
+// SelfCheckError is returned when a record would be rejected by the
+// network, so it is not published
+type SelfCheckError struct {
+	Check PublishCheck
+	Err   error
+}
+
+func (e *SelfCheckError) Error() string {
+	return fmt.Sprintf("Record failed %s check before publishing: %s", e.Check, e.Err)
+}
+
+// certGetter gets the certificate with the given hash
+type certGetter func(ctx context.Context, hash string) (*x509.Certificate, error)
+
+// keyChecker is implemented by signers whose records may be published
+// at keys other than their BasePath. Certificates that are not part of
+// the signer are fetched with getCert.
+type keyChecker interface {
+	checkKey(ctx context.Context, iprsKey rsp.IprsPath, getCert certGetter) error
+}
+
+// verificationStager is implemented by signers that can put the data
+// required to verify their entries to a given value store, so that
+// entries can be verified before anything is written to the network
+type verificationStager interface {
+	stageVerification(ctx context.Context, vs routing.ValueStore) error
+}
+
+// checkKey checks that the signer can sign records for the IPRS key
+func checkKey(ctx context.Context, s RecordSigner, iprsKey rsp.IprsPath, getCert certGetter) error {
+	if kc, ok := s.(keyChecker); ok {
+		return kc.checkKey(ctx, iprsKey, getCert)
+	}
+	base, err := s.BasePath()
+	if err != nil {
+		return err
+	}
+	if iprsKey.GetHashString() != base.GetHashString() {
+		return ErrKeyMismatch
+	}
+	return nil
+}
+
+// SelfCheck checks that the entry signed by s would be accepted by the
+// network, without writing anything to the network. The data required
+// to verify the entry (eg public key) is staged locally.
+func (f *RecordFactory) SelfCheck(ctx context.Context, s RecordSigner, iprsKey rsp.IprsPath, entry *pb.IprsEntry) error {
+	// Signers that can't stage their verification data are verified
+	// against what is already on the network
+	staged := newStagingValueStore(f.r)
+	if stager, ok := s.(verificationStager); ok {
+		err := stager.stageVerification(ctx, staged)
+		if err != nil {
+			return &SelfCheckError{CheckVerification, err}
+		}
+	}
+	verifiers := f.verifiersFor(staged)
+
+	certv := verifiers[pb.IprsEntry_Cert].(*CertRecordVerifier)
+	err := checkKey(ctx, s, iprsKey, certv.getCert)
+	if err != nil {
+		return &SelfCheckError{CheckKey, err}
+	}
+
+	err = f.Validate(iprsKey, entry)
+	if err != nil {
+		return &SelfCheckError{CheckValidity, err}
+	}
+
+	err = f.verify(ctx, verifiers, iprsKey, entry)
+	if err != nil {
+		return &SelfCheckError{CheckVerification, err}
+	}
+
+	return nil
+}
+
+// stagingValueStore keeps the values that are put to it in memory, and
+// reads values that have not been put from the underlying value store
+type stagingValueStore struct {
+	routing.ValueStore
+	lk   sync.Mutex
+	vals map[string][]byte
+}
+
+func newStagingValueStore(vs routing.ValueStore) *stagingValueStore {
+	return &stagingValueStore{
+		ValueStore: vs,
+		vals:       make(map[string][]byte),
+	}
+}
+
+func (s *stagingValueStore) PutValue(ctx context.Context, k string, v []byte) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.vals[k] = v
+	return nil
+}
+
+func (s *stagingValueStore) get(k string) ([]byte, bool) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	v, ok := s.vals[k]
+	return v, ok
+}
+
+func (s *stagingValueStore) GetValue(ctx context.Context, k string) ([]byte, error) {
+	if v, ok := s.get(k); ok {
+		return v, nil
+	}
+	return s.ValueStore.GetValue(ctx, k)
+}
+
+func (s *stagingValueStore) GetValues(ctx context.Context, k string, count int) ([]routing.RecvdVal, error) {
+	if v, ok := s.get(k); ok {
+		return []routing.RecvdVal{{Val: v}}, nil
+	}
+	return s.ValueStore.GetValues(ctx, k, count)
+}
@@ -0,0 +1,107 @@
+package iprs_record
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	c "github.com/dirkmc/go-iprs/certificate"
+	rsp "github.com/dirkmc/go-iprs/path"
+	path "github.com/ipfs/go-ipfs/path"
+	mockrouting "github.com/ipfs/go-ipfs/routing/mock"
+	routing "gx/ipfs/QmPCGUjMRuBcPybZFpjhzpifwPP9wPRoiy5geTQKU4vqWA/go-libp2p-routing"
+	ds "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore"
+	dssync "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore/sync"
+	testutil "gx/ipfs/QmeDA8gNhvRTsbrjEieay5wezupJDiky8xvCzDABbsGzmp/go-testutil"
+)
+
+func TestSelfCheck(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	r := mockrouting.NewServer().ClientWithDatastore(ctx, testutil.RandIdentityOrFatal(t), dstore)
+	f := NewRecordFactory(r, nil)
+	p := path.Path("/ipfs/myIpfsHash")
+	eol := time.Now().Add(time.Hour)
+
+	pk, _, err := testutil.RandTestKeyPair(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherpk, _, err := testutil.RandTestKeyPair(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iprsKey := getIprsPathFromKey(t, pk)
+
+	// Expects publishing to fail the check before anything is written
+	// to the network
+	expectCheckFailure := func(rec *Record, iprsKey rsp.IprsPath, check PublishCheck, expected error) {
+		err := rec.Publish(ctx, iprsKey, 1)
+		scerr, ok := err.(*SelfCheckError)
+		if !ok || scerr.Check != check {
+			t.Fatalf("Expected %s check error, got %v", check, err)
+		}
+		if expected != nil && scerr.Err != expected {
+			t.Fatalf("Expected %s check error %s, got %s", check, expected, scerr.Err)
+		}
+		_, err = r.GetValue(ctx, iprsKey.String())
+		if err != routing.ErrNotFound && err != ds.ErrNotFound {
+			t.Fatal("Expected entry not to be published")
+		}
+	}
+
+	// Record published at a key that belongs to another signer FAIL
+	expectCheckFailure(f.NewEolKeyRecord(p, otherpk, eol), iprsKey, CheckKey, ErrKeyMismatch)
+	_, err = f.pkm.GetPublicKey(ctx, getIprsPathFromKey(t, otherpk))
+	if err == nil {
+		t.Fatal("Expected public key not to be published")
+	}
+
+	// Record that has already expired FAIL
+	expectCheckFailure(f.NewEolKeyRecord(p, pk, time.Now().Add(-time.Hour)), iprsKey, CheckValidity, ErrExpiredRecord)
+
+	// Record signed with a key that doesn't belong to the certificate FAIL
+	ca := newRootCA(t, "ca cert")
+	unrelatedPk, err := c.GenerateKey(c.KeyTypeECDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caKey := getIprsPathFromCert(t, ca.Cert, "/myIprsName")
+	expectCheckFailure(f.NewEolCertRecord(p, ca.Cert, unrelatedPk, eol), caKey, CheckVerification, nil)
+
+	// Root certificate signing for another root's name FAIL
+	unrelatedCA := newRootCA(t, "unrelated ca cert")
+	unrelatedKey := getIprsPathFromCert(t, unrelatedCA.Cert, "/myIprsName")
+	expectCheckFailure(f.NewEolCertRecord(p, ca.Cert, ca.Key, eol), unrelatedKey, CheckKey, ErrKeyMismatch)
+
+	// Certificate signing for a root that did not issue it FAIL
+	putCertificates(t, r, unrelatedCA.Cert)
+	otherCert, otherCertPk := issueCertificate(t, ca, "other cert")
+	expectCheckFailure(f.NewEolCertRecord(p, otherCert, otherCertPk, eol), unrelatedKey, CheckKey, ErrKeyMismatch)
+
+	// Dry run of a valid record checks the entry without publishing it
+	rec := f.NewEolKeyRecord(p, pk, eol)
+	entry, err := rec.DryRun(ctx, iprsKey, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.GetSequence() != 1 || len(entry.GetSignature()) == 0 {
+		t.Fatal("Expected dry run to return the signed entry")
+	}
+	_, err = r.GetValue(ctx, iprsKey.String())
+	if err != routing.ErrNotFound && err != ds.ErrNotFound {
+		t.Fatal("Expected dry run not to publish entry")
+	}
+
+	// Valid records SUCCESS
+	err = rec.Publish(ctx, iprsKey, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putCertificates(t, r, ca.Cert)
+	childCert, childPk := issueCertificate(t, ca, "child cert")
+	err = f.NewEolCertRecord(p, childCert, childPk, eol).Publish(ctx, caKey, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
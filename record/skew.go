@@ -0,0 +1,28 @@
+package iprs_record
+
+import (
+	"time"
+)
+
+// SkewTolerance allows for differences between the clock of the node
+// that published a record and the clock of the node validating it.
+// The zero value does not tolerate any skew.
+type SkewTolerance struct {
+	// Accept records that will become valid within this duration,
+	// eg a TimeRange record whose start is a few seconds in the future
+	Pending time.Duration
+	// Accept records that expired less than this duration ago
+	Expired time.Duration
+}
+
+// isPending returns true if a record that becomes valid at start
+// should be considered not yet valid at the given time
+func (t SkewTolerance) isPending(start time.Time, now time.Time) bool {
+	return now.Add(t.Pending).Before(start)
+}
+
+// isExpired returns true if a record that expires at end should
+// be considered expired at the given time
+func (t SkewTolerance) isExpired(end time.Time, now time.Time) bool {
+	return now.Add(-t.Expired).After(end)
+}
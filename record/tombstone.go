@@ -0,0 +1,24 @@
+package iprs_record
+
+import (
+	pb "github.com/dirkmc/go-iprs/pb"
+	routing "gx/ipfs/QmPCGUjMRuBcPybZFpjhzpifwPP9wPRoiy5geTQKU4vqWA/go-libp2p-routing"
+)
+
+// NewTombstoneRecord creates a record that unpublishes a name. A
+// tombstone is published with the next sequence number like any other
+// record, so it replaces the name's current entry. It should be valid
+// for at least as long as any entry published before it, so that older
+// entries are not resolved again once it expires.
+func NewTombstoneRecord(r routing.ValueStore, vl RecordValidity, s RecordSigner) *Record {
+	rec := NewRecord(r, vl, s, "")
+	rec.tombstone = true
+	return rec
+}
+
+// IsTombstone indicates whether the entry says that the name has been
+// deleted. The value type is ignored for SignatureV1 entries because it
+// is not covered by the signature.
+func IsTombstone(e *pb.IprsEntry) bool {
+	return e.GetValueType() == pb.IprsEntry_Tombstone && GetSignatureVersion(e) != SignatureV1
+}
@@ -0,0 +1,69 @@
+package iprs_record
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "github.com/dirkmc/go-iprs/pb"
+	path "github.com/ipfs/go-ipfs/path"
+	mockrouting "github.com/ipfs/go-ipfs/routing/mock"
+	ds "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore"
+	dssync "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore/sync"
+	testutil "gx/ipfs/QmeDA8gNhvRTsbrjEieay5wezupJDiky8xvCzDABbsGzmp/go-testutil"
+)
+
+func TestTombstone(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	r := mockrouting.NewServer().ClientWithDatastore(ctx, testutil.RandIdentityOrFatal(t), dstore)
+	f := NewRecordFactory(r, nil)
+	eol := time.Now().Add(time.Hour)
+
+	pk, _, err := testutil.RandTestKeyPair(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := f.NewKeyRecordSigner(pk)
+	iprsKey := getIprsPathFromKey(t, pk)
+
+	// Records are not tombstones
+	e1, err := f.NewRecord(NewEolRecordValidity(eol), s, path.Path("foo")).Entry(iprsKey, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if IsTombstone(e1) {
+		t.Fatal("Expected record not to be a tombstone")
+	}
+
+	// Tombstones have no value
+	tombstone := f.NewTombstoneRecord(NewEolRecordValidity(eol), s)
+	err = tombstone.Publish(ctx, iprsKey, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e2, err := tombstone.Entry(iprsKey, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsTombstone(e2) || len(e2.GetValue()) != 0 {
+		t.Fatal("Expected tombstone with no value")
+	}
+	err = f.Verify(ctx, iprsKey, e2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Tombstones win selection like any entry with a higher sequence number
+	err = AssertSelected(NewEolRecordChecker(nil, SkewTolerance{}).SelectRecord, e2, []*pb.IprsEntry{e1, e2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The value type is covered by the signature
+	e1.ValueType = pb.IprsEntry_Tombstone.Enum()
+	err = f.Verify(ctx, iprsKey, e1)
+	if err == nil {
+		t.Fatal("Expected error for record with modified value type")
+	}
+}
@@ -3,6 +3,7 @@ package iprs_resolver
 import (
 	"context"
 	"strings"
+	"time"
 
 	rsp "github.com/dirkmc/go-iprs/path"
 	rec "github.com/dirkmc/go-iprs/record"
@@ -37,8 +38,10 @@ func (r *DHTResolver) ResolveN(ctx context.Context, name string, depth int) (pat
 }
 
 // ResolveOnce implements Lookup. Uses the IPFS routing system to
-// resolve SFS-like names.
-func (r *DHTResolver) ResolveOnce(ctx context.Context, name string) (string, error) {
+// resolve SFS-like names. The TTL is the record's TTL (or the cache's
+// default TTL if the record doesn't have one), limited to the time
+// until the record expires.
+func (r *DHTResolver) ResolveOnce(ctx context.Context, name string) (string, time.Duration, error) {
 	log.Debugf("DHT ResolveOnce: [%s]", name)
 
 	// Ensure name starts with /iprs/
@@ -48,14 +51,21 @@ func (r *DHTResolver) ResolveOnce(ctx context.Context, name string) (string, err
 	iprsKey, err := rsp.FromString(name)
 	if err != nil {
 		log.Warningf("Could not parse [%s] to IprsKey", name)
-		return "", err
+		return "", 0, err
 	}
 
 	// Use the routing system to get the entry
 	entry, err := r.vstore.GetEntry(ctx, iprsKey)
 	if err != nil {
 		log.Warningf("RoutingResolve get failed for %s", name)
-		return "", err
+		return "", 0, err
+	}
+
+	// Check the record is currently valid (eg it has not expired)
+	err = r.verifier.Validate(iprsKey, entry)
+	if err != nil {
+		log.Warningf("Entry at %s is not valid: %s", name, err)
+		return "", 0, err
 	}
 
 	// Verify record signatures etc are correct
@@ -64,8 +74,14 @@ func (r *DHTResolver) ResolveOnce(ctx context.Context, name string) (string, err
 	err = r.verifier.Verify(ctx, iprsKey, entry)
 	if err != nil {
 		log.Warningf("Failed to verify entry at %s", name)
-		return "", err
+		return "", 0, err
+	}
+
+	// The name has been unpublished
+	if rec.IsTombstone(entry) {
+		log.Debugf("Entry at %s is a tombstone", name)
+		return "", r.vstore.EntryTtl(entry), ErrNameDeleted
 	}
 
-	return string(entry.GetValue()), nil
+	return string(entry.GetValue()), r.vstore.EntryTtl(entry), nil
 }
@@ -12,6 +12,7 @@ import (
 	dssync "gx/ipfs/QmdHG8MAuARdGHxx4rPQASLcvhz24fzjSQq7AJRAQEorq5/go-datastore/sync"
 	testutil "gx/ipfs/QmeDA8gNhvRTsbrjEieay5wezupJDiky8xvCzDABbsGzmp/go-testutil"
 	//	peer "gx/ipfs/QmXYjuNuxVzXKJCfWasQk1RqkhVLDM9jtUKhqc2WPQmFSB/go-libp2p-peer"
+	clock "github.com/dirkmc/go-iprs/clock"
 	rsp "github.com/dirkmc/go-iprs/path"
 	psh "github.com/dirkmc/go-iprs/publisher"
 	rec "github.com/dirkmc/go-iprs/record"
@@ -24,11 +25,11 @@ func TestDHTResolve(t *testing.T) {
 	dstore := dssync.MutexWrap(ds.NewMapDatastore())
 	id := testutil.RandIdentityOrFatal(t)
 	r := vs.NewMockValueStore(context.Background(), id, dstore)
-	factory := rec.NewRecordFactory(r)
-	vstore := vs.NewCachedValueStore(r, 0, nil)
+	factory := rec.NewRecordFactory(r, nil)
+	vstore := vs.NewCachedValueStore(r, 0, nil, nil)
 	kvstore := vs.NewKadValueStore(dstore, r)
 	resolver := NewDHTResolver(vstore, factory)
-	publisher := psh.NewDHTPublisher(psh.NewSeqManager(kvstore))
+	publisher := psh.NewDHTPublisher(psh.NewSeqManager(kvstore), factory)
 
 	pk, pubk, err := testutil.RandTestKeyPair(512)
 	if err != nil {
@@ -65,6 +66,102 @@ func TestDHTResolve(t *testing.T) {
 	}
 }
 
+func TestDHTResolveExpired(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	id := testutil.RandIdentityOrFatal(t)
+	r := vs.NewMockValueStore(context.Background(), id, dstore)
+	clk := clock.NewMockClock(time.Now())
+	factory := rec.NewRecordFactory(r, clk)
+	vstore := vs.NewCachedValueStore(r, 0, nil, clk)
+	kvstore := vs.NewKadValueStore(dstore, r)
+	resolver := NewDHTResolver(vstore, factory)
+	publisher := psh.NewDHTPublisher(psh.NewSeqManager(kvstore), factory)
+
+	pk, pubk, err := testutil.RandTestKeyPair(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := path.FromString("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+
+	pubkBytes, err := pubk.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	iprsKey, err := rsp.FromString("/iprs/" + u.Hash(pubkBytes).B58String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	eolRecord := factory.NewEolKeyRecord(h, pk, clk.Now().Add(time.Hour))
+	err = publisher.Publish(ctx, iprsKey, eolRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := resolver.Resolve(ctx, iprsKey.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != h {
+		t.Fatal("Got back incorrect value.")
+	}
+
+	// Move the clock beyond the record's EOL
+	clk.Add(time.Hour * 2)
+
+	_, err = resolver.Resolve(ctx, iprsKey.String())
+	if err != rec.ErrExpiredRecord {
+		t.Fatal("Expected expired record error")
+	}
+}
+
+func TestDHTResolveTtl(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	id := testutil.RandIdentityOrFatal(t)
+	r := vs.NewMockValueStore(context.Background(), id, dstore)
+	clk := clock.NewMockClock(time.Now())
+	factory := rec.NewRecordFactory(r, clk)
+	vstore := vs.NewCachedValueStore(r, 0, nil, clk)
+	kvstore := vs.NewKadValueStore(dstore, r)
+	resolver := NewDHTResolver(vstore, factory)
+	publisher := psh.NewDHTPublisher(psh.NewSeqManager(kvstore), factory)
+
+	pk, pubk, err := testutil.RandTestKeyPair(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := path.FromString("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+
+	pubkBytes, err := pubk.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	iprsKey, err := rsp.FromString("/iprs/" + u.Hash(pubkBytes).B58String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	factory.SetRecordTtl(time.Second * 30)
+	eolRecord := factory.NewEolKeyRecord(h, pk, clk.Now().Add(time.Hour))
+	err = publisher.Publish(ctx, iprsKey, eolRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, ttl, err := ResolveWithTtl(ctx, resolver, iprsKey.String(), DefaultDepthLimit, "/iprs/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != h {
+		t.Fatal("Got back incorrect value.")
+	}
+	if ttl != time.Second*30 {
+		t.Fatalf("Expected TTL of %s but got %s", time.Second*30, ttl)
+	}
+}
+
 /*
 func TestPrexistingExpiredRecord(t *testing.T) {
 	dstore := dssync.MutexWrap(ds.NewMapDatastore())
@@ -153,3 +250,63 @@ func verifyCanResolve(r Resolver, name string, exp path.Path) error {
 	return nil
 }
 */
+
+func TestDHTResolveTombstone(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	id := testutil.RandIdentityOrFatal(t)
+	r := vs.NewMockValueStore(context.Background(), id, dstore)
+	clk := clock.NewMockClock(time.Now())
+	factory := rec.NewRecordFactory(r, clk)
+	vstore := vs.NewCachedValueStore(r, 10, nil, clk)
+	kvstore := vs.NewKadValueStore(dstore, r)
+	resolver := NewDHTResolver(vstore, factory)
+	publisher := psh.NewDHTPublisher(psh.NewSeqManager(kvstore), factory)
+
+	pk, _, err := testutil.RandTestKeyPair(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := factory.NewKeyRecordSigner(pk)
+	iprsKey, err := s.BasePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := path.FromString("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+	eol := clk.Now().Add(time.Hour * 24)
+	err = publisher.Publish(ctx, iprsKey, factory.NewEolKeyRecord(h, pk, eol))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := resolver.Resolve(ctx, iprsKey.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != h {
+		t.Fatal("Got back incorrect value.")
+	}
+
+	// The tombstone has a higher sequence number so it replaces the
+	// entry (once the cached entry expires)
+	tombstone := factory.NewTombstoneRecord(rec.NewEolRecordValidity(eol), s)
+	err = publisher.Publish(ctx, iprsKey, tombstone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clk.Add(vs.DefaultResolverCacheTTL + time.Second)
+	_, err = resolver.Resolve(ctx, iprsKey.String())
+	if err != ErrNameDeleted {
+		t.Fatalf("Expected name deleted error, got %v", err)
+	}
+
+	// The tombstone is cached like any other entry
+	err = r.DeleteValue(iprsKey.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = resolver.Resolve(ctx, iprsKey.String())
+	if err != ErrNameDeleted {
+		t.Fatalf("Expected cached name deleted error, got %v", err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"net"
 	"strings"
+	"time"
 
 	path "github.com/ipfs/go-ipfs/path"
 	isd "gx/ipfs/QmZmmuAXgX73UQmX1jRKjTGmjzq24Jinqkq8vzkBtno4uX/go-is-domain"
@@ -49,12 +50,16 @@ type lookupRes struct {
 // ResolveOnce implements Lookup.
 // TXT records for a given domain name should contain a b58
 // encoded multihash.
-func (r *DNSResolver) ResolveOnce(ctx context.Context, name string) (string, error) {
+// The TTL of the DNS record itself is not available, so the result
+// has a TTL of DefaultResolverCacheTTL. If the TXT record points to an
+// IPRS record with a shorter TTL, the shorter TTL is used for the
+// overall resolution.
+func (r *DNSResolver) ResolveOnce(ctx context.Context, name string) (string, time.Duration, error) {
 	segments := strings.SplitN(name, "/", 2)
 	domain := segments[0]
 
 	if !isd.IsDomain(domain) {
-		return "", errors.New("not a valid domain name")
+		return "", 0, errors.New("not a valid domain name")
 	}
 	log.Debugf("DNSResolver resolving %s", domain)
 
@@ -68,7 +73,7 @@ func (r *DNSResolver) ResolveOnce(ctx context.Context, name string) (string, err
 	select {
 	case subRes = <-subChan:
 	case <-ctx.Done():
-		return "", ctx.Err()
+		return "", 0, ctx.Err()
 	}
 
 	var p string
@@ -79,18 +84,18 @@ func (r *DNSResolver) ResolveOnce(ctx context.Context, name string) (string, err
 		select {
 		case rootRes = <-rootChan:
 		case <-ctx.Done():
-			return "", ctx.Err()
+			return "", 0, ctx.Err()
 		}
 		if rootRes.error == nil {
 			p = rootRes.path
 		} else {
-			return "", ErrResolveFailed
+			return "", 0, ErrResolveFailed
 		}
 	}
 	if len(segments) > 1 {
-		return strings.TrimRight(p, "/") + "/" + segments[1], nil
+		return strings.TrimRight(p, "/") + "/" + segments[1], DefaultResolverCacheTTL, nil
 	} else {
-		return p, nil
+		return p, DefaultResolverCacheTTL, nil
 	}
 }
 
@@ -3,6 +3,7 @@ package iprs_resolver
 import (
 	"errors"
 	"context"
+	"time"
 
 	path "github.com/ipfs/go-ipfs/path"
 	proquint "gx/ipfs/QmYnf27kzqR2cxt6LFZdrAFJuQd6785fTkBvMuEj9EeRxM/proquint"
@@ -21,10 +22,10 @@ func (r *ProquintResolver) ResolveN(ctx context.Context, name string, depth int)
 }
 
 // resolveOnce implements resolver. Decodes the proquint string.
-func (r *ProquintResolver) ResolveOnce(ctx context.Context, name string) (string, error) {
+func (r *ProquintResolver) ResolveOnce(ctx context.Context, name string) (string, time.Duration, error) {
 	ok, err := proquint.IsProquint(name)
 	if err != nil || !ok {
-		return "", errors.New("not a valid proquint string")
+		return "", 0, errors.New("not a valid proquint string")
 	}
-	return string(proquint.Decode(name)), nil
+	return string(proquint.Decode(name)), DefaultResolverCacheTTL, nil
 }
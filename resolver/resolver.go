@@ -31,34 +31,51 @@ var ErrResolveFailed = errors.New("Could not resolve name.")
 // ErrResolveRecursion signals a recursion-depth limit.
 var ErrResolveRecursion = errors.New("Could not resolve name (recursion limit exceeded).")
 
+// ErrNameDeleted signals that the name was unpublished with a tombstone.
+var ErrNameDeleted = errors.New("Could not resolve name (name has been deleted).")
+
 type Lookup interface {
-	// ResolveOnce looks up a name once (without recursion).
-	ResolveOnce(ctx context.Context, name string) (value string, err error)
+	// ResolveOnce looks up a name once (without recursion). It also
+	// returns how long the value may be cached for.
+	ResolveOnce(ctx context.Context, name string) (value string, ttl time.Duration, err error)
 }
 
 // Resolve is a helper for implementing Resolver.ResolveN using resolveOnce.
 func Resolve(ctx context.Context, r Lookup, name string, depth int, prefixes ...string) (path.Path, error) {
-	for {
+	p, _, err := ResolveWithTtl(ctx, r, name, depth, prefixes...)
+	return p, err
+}
+
+// ResolveWithTtl is like Resolve but also returns how long the result may
+// be cached for, which is the shortest TTL of any name along the way.
+func ResolveWithTtl(ctx context.Context, r Lookup, name string, depth int, prefixes ...string) (path.Path, time.Duration, error) {
+	var ttl time.Duration
+	for i := 0; ; i++ {
 		// Lookup the path in the resolver
-		p, err := r.ResolveOnce(ctx, name)
+		p, pttl, err := r.ResolveOnce(ctx, name)
 		if err != nil {
 			log.Warningf("Could not resolve %s", name)
-			return "", err
+			return "", 0, err
 		}
 		log.Debugf("Resolved %s to %s", name, p)
 
+		if i == 0 || pttl < ttl {
+			ttl = pttl
+		}
+
 		// If we've bottomed out with an IPFS path we can return
 		if strings.HasPrefix(p, "/ipfs/") {
-			return parsePath(p)
+			pth, err := parsePath(p)
+			return pth, ttl, err
 		}
 
 		// If we've recursed up to the limit, bail out with an error
 		if depth == 1 {
 			pth, err := parsePath(p)
 			if err != nil {
-				return "", ErrResolveRecursion
+				return "", 0, ErrResolveRecursion
 			}
-			return pth, ErrResolveRecursion
+			return pth, ttl, ErrResolveRecursion
 		}
 
 		// If the path has a recognized prefix, remove it
@@ -75,7 +92,8 @@ func Resolve(ctx context.Context, r Lookup, name string, depth int, prefixes ...
 
 		// There were no recognzed prefixes, so just return the path itself
 		if !matched {
-			return parsePath(p)
+			pth, err := parsePath(p)
+			return pth, ttl, err
 		}
 
 		// Recurse
@@ -1,8 +1,10 @@
 package iprs_validation
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	clock "github.com/dirkmc/go-iprs/clock"
 	rsp "github.com/dirkmc/go-iprs/path"
 	pb "github.com/dirkmc/go-iprs/pb"
 	rec "github.com/dirkmc/go-iprs/record"
@@ -22,10 +24,20 @@ type recordChecker struct {
 	Selector     record.SelectorFunc
 }
 
-func newRecordChecker() *recordChecker {
+// NewRecordChecker creates a validator and selector for IPRS records
+// that checks record validity against the given clock, tolerating the
+// given amount of clock skew
+func NewRecordChecker(clk clock.Clock, tolerance rec.SkewTolerance) *recordChecker {
+	return newRecordChecker(clock.OrRealClock(clk), tolerance)
+}
+
+func newRecordChecker(clk clock.Clock, tolerance rec.SkewTolerance) *recordChecker {
 	validators := map[pb.IprsEntry_ValidityType]rec.RecordChecker{
-		pb.IprsEntry_EOL:       rec.EolRecordChecker,
-		pb.IprsEntry_TimeRange: rec.RangeRecordChecker,
+		pb.IprsEntry_EOL:        rec.NewEolRecordChecker(clk, tolerance),
+		pb.IprsEntry_TimeRange:  rec.NewRangeRecordChecker(clk, tolerance),
+		pb.IprsEntry_Composite:  rec.NewCompositeRecordChecker(clk, tolerance),
+		pb.IprsEntry_Recurring:  rec.NewRecurringRecordChecker(clk, tolerance),
+		pb.IprsEntry_MultiRange: rec.NewMultiRangeRecordChecker(clk, tolerance),
 	}
 
 	// Implements ValidatorFunc and verifies that the
@@ -49,7 +61,7 @@ func newRecordChecker() *recordChecker {
 		return validator.ValidateRecord(p, entry)
 	}
 
-	getEntry := func(i int, length int, val []byte, firstEntry *pb.IprsEntry) *pb.IprsEntry {
+	getEntry := func(i int, length int, val []byte) *pb.IprsEntry {
 		entry := new(pb.IprsEntry)
 		err := proto.Unmarshal(val, entry)
 		if err != nil {
@@ -64,17 +76,6 @@ func newRecordChecker() *recordChecker {
 			return nil
 		}
 
-		if firstEntry == nil {
-			return entry
-		}
-
-		if entry.GetValidityType() != firstEntry.GetValidityType() {
-			t1 := firstEntry.GetValidityType().String()
-			t2 := entry.GetValidityType().String()
-			log.Warningf("Records have mixed validity types (%s and %s). Ignoring %s at index %d of %d", t1, t2, t2, i, length)
-			return nil
-		}
-
 		return entry
 	}
 
@@ -85,26 +86,56 @@ func newRecordChecker() *recordChecker {
 			return 0, NoUsableRecordsError
 		}
 
-		var firstEntry *pb.IprsEntry
+		// The entry with the highest sequence number wins, whatever its
+		// validity type
 		var entries []*pb.IprsEntry
+		var bestSeq uint64
+		found := false
 		for i, val := range vals {
-			entry := getEntry(i, len(vals), val, firstEntry)
-			if firstEntry == nil && entry != nil {
-				firstEntry = entry
+			entry := getEntry(i, len(vals), val)
+			if entry != nil && (!found || entry.GetSequence() > bestSeq) {
+				bestSeq = entry.GetSequence()
+				found = true
 			}
 			entries = append(entries, entry)
 		}
 
-		if firstEntry == nil {
+		if !found {
 			return 0, NoUsableRecordsError
 		}
 
-		validator, ok := validators[firstEntry.GetValidityType()]
-		if !ok {
-			return 0, NoUsableRecordsError
+		// Entries with the highest sequence number are passed to the
+		// selector for their validity type to break the tie. If there are
+		// entries with different validity types, the selected entry with
+		// the greatest value (in bytes) wins, so that every node selects
+		// the same one.
+		best := -1
+		for t, validator := range validators {
+			candidates := make([]*pb.IprsEntry, len(entries))
+			count := 0
+			for i, entry := range entries {
+				if entry != nil && entry.GetSequence() == bestSeq && entry.GetValidityType() == t {
+					candidates[i] = entry
+					count++
+				}
+			}
+			if count == 0 {
+				continue
+			}
+
+			i, err := validator.SelectRecord(candidates, vals)
+			if err != nil {
+				continue
+			}
+			if best == -1 || bytes.Compare(vals[i], vals[best]) > 0 {
+				best = i
+			}
 		}
 
-		return validator.SelectRecord(entries, vals)
+		if best == -1 {
+			return 0, NoUsableRecordsError
+		}
+		return best, nil
 	}
 
 	return &recordChecker{
@@ -116,4 +147,4 @@ func newRecordChecker() *recordChecker {
 	}
 }
 
-var RecordChecker = newRecordChecker()
+var RecordChecker = newRecordChecker(clock.RealClock, rec.SkewTolerance{})
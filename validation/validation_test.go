@@ -0,0 +1,85 @@
+package iprs_validation
+
+import (
+	"testing"
+	"time"
+
+	pb "github.com/dirkmc/go-iprs/pb"
+	rec "github.com/dirkmc/go-iprs/record"
+	proto "github.com/gogo/protobuf/proto"
+)
+
+func getEntry(t *testing.T, vl rec.RecordValidity, seq uint64) *pb.IprsEntry {
+	validity, err := vl.Validity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &pb.IprsEntry{
+		Value:        []byte("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN"),
+		ValidityType: vl.ValidityType(),
+		Validity:     validity,
+		Sequence:     proto.Uint64(seq),
+	}
+}
+
+func assertSelected(t *testing.T, expected int, entries ...*pb.IprsEntry) {
+	var vals [][]byte
+	for _, e := range entries {
+		data, err := proto.Marshal(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vals = append(vals, data)
+	}
+
+	i, err := RecordChecker.Selector("/iprs/somehash", vals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i != expected {
+		t.Fatalf("Selected record %d, expected %d", i, expected)
+	}
+}
+
+func TestSelectMixedValidityTypes(t *testing.T) {
+	now := time.Now()
+	end := now.Add(time.Hour * 2)
+
+	// EOL record is superseded by a TimeRange tombstone with a higher
+	// sequence number, whichever order they are in
+	eol := getEntry(t, rec.NewEolRecordValidity(now.Add(time.Hour)), 1)
+	vl, err := rec.NewRangeRecordValidity(&now, &end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tombstone := getEntry(t, vl, 2)
+	tombstone.Value = []byte{}
+	tombstone.ValueType = pb.IprsEntry_Tombstone.Enum()
+
+	assertSelected(t, 1, eol, tombstone)
+	assertSelected(t, 0, tombstone, eol)
+
+	// The highest sequence number wins even if it isn't the first entry's
+	// validity type
+	eol3 := getEntry(t, rec.NewEolRecordValidity(now.Add(time.Hour)), 3)
+	assertSelected(t, 2, eol, tombstone, eol3)
+
+	// Entries with the same sequence number and validity type are
+	// selected by that type's selector, ie the later EOL
+	laterEol3 := getEntry(t, rec.NewEolRecordValidity(now.Add(time.Hour*3)), 3)
+	assertSelected(t, 3, eol, tombstone, eol3, laterEol3)
+
+	// Unparseable entries are ignored
+	vals := [][]byte{[]byte("not an entry")}
+	data, err := proto.Marshal(eol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i, err := RecordChecker.Selector("/iprs/somehash", append(vals, data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i != 1 {
+		t.Fatalf("Selected record %d, expected 1", i)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"time"
 
+	clock "github.com/dirkmc/go-iprs/clock"
 	rsp "github.com/dirkmc/go-iprs/path"
 	pb "github.com/dirkmc/go-iprs/pb"
 	rec "github.com/dirkmc/go-iprs/record"
@@ -22,6 +23,7 @@ type CachedValueStore struct {
 	vs    routing.ValueStore
 	cache *lru.Cache
 	ttl   time.Duration
+	clock clock.Clock
 }
 
 type cacheEntry struct {
@@ -31,7 +33,8 @@ type cacheEntry struct {
 
 // cachesize is the limit of the number of entries in the lru cache. Setting it
 // to '0' will disable caching.
-func NewCachedValueStore(vs routing.ValueStore, cachesize int, ttlp *time.Duration) *CachedValueStore {
+// If clk is nil the system clock is used to expire cache entries.
+func NewCachedValueStore(vs routing.ValueStore, cachesize int, ttlp *time.Duration, clk clock.Clock) *CachedValueStore {
 	var cache *lru.Cache
 	if cachesize > 0 {
 		cache, _ = lru.New(cachesize)
@@ -41,7 +44,7 @@ func NewCachedValueStore(vs routing.ValueStore, cachesize int, ttlp *time.Durati
 		ttl = *ttlp
 	}
 
-	return &CachedValueStore{vs, cache, ttl}
+	return &CachedValueStore{vs, cache, ttl, clock.OrRealClock(clk)}
 }
 
 func (s *CachedValueStore) cacheGet(iprsKey rsp.IprsPath) (*pb.IprsEntry, bool) {
@@ -64,7 +67,7 @@ func (s *CachedValueStore) cacheGet(iprsKey rsp.IprsPath) (*pb.IprsEntry, bool)
 	}
 
 	// If it's not expired, return it
-	if time.Now().Before(centry.eol) {
+	if s.clock.Now().Before(centry.eol) {
 		return centry.entry, true
 	}
 
@@ -79,23 +82,7 @@ func (s *CachedValueStore) cacheSet(iprsKey rsp.IprsPath, entry *pb.IprsEntry) {
 		return
 	}
 
-	// if completely unspecified, just use one minute
-	ttl := s.ttl
-	/*
-		TODO: Not sure if this is still being used by IPNS
-		if rec.Ttl != nil {
-			recttl := time.Duration(rec.GetTtl())
-			if recttl >= 0 {
-				ttl = recttl
-			}
-		}
-	*/
-
-	cacheTill := time.Now().Add(ttl)
-	eol, ok := getCacheEndTime(entry)
-	if ok && eol.Before(cacheTill) {
-		cacheTill = eol
-	}
+	cacheTill := s.clock.Now().Add(s.EntryTtl(entry))
 
 	s.cache.Add(iprsKey.String(), cacheEntry{
 		entry: entry,
@@ -103,6 +90,26 @@ func (s *CachedValueStore) cacheSet(iprsKey rsp.IprsPath, entry *pb.IprsEntry) {
 	})
 }
 
+// EntryTtl returns how long the entry may be cached for. This is the
+// entry's own TTL if it has one (or the store-wide TTL if it doesn't),
+// but no longer than the time until the entry expires.
+func (s *CachedValueStore) EntryTtl(entry *pb.IprsEntry) time.Duration {
+	ttl, ok := rec.GetTtl(entry)
+	if !ok {
+		ttl = s.ttl
+	}
+
+	now := s.clock.Now()
+	eol, ok := getCacheEndTime(entry, now)
+	if ok && eol.Before(now.Add(ttl)) {
+		ttl = eol.Sub(now)
+	}
+	if ttl < 0 {
+		ttl = 0
+	}
+	return ttl
+}
+
 func (s *CachedValueStore) PutEntry(ctx context.Context, iprsKey rsp.IprsPath, entry *pb.IprsEntry) error {
 	data, err := proto.Marshal(entry)
 	if err != nil {
@@ -143,6 +150,12 @@ func (s *CachedValueStore) GetEntry(ctx context.Context, iprsKey rsp.IprsPath) (
 		return nil, err
 	}
 
+	// Tombstones have no value, but are cached like any other entry
+	if rec.IsTombstone(entry) {
+		s.cacheSet(iprsKey, entry)
+		return entry, nil
+	}
+
 	// Check for old style IPNS record:
 	valh, err := mh.Cast(entry.GetValue())
 	if err == nil {
@@ -167,7 +180,11 @@ func (s *CachedValueStore) GetEntry(ctx context.Context, iprsKey rsp.IprsPath) (
 	return entry, nil
 }
 
-func getCacheEndTime(e *pb.IprsEntry) (time.Time, bool) {
+func getCacheEndTime(e *pb.IprsEntry, now time.Time) (time.Time, bool) {
+	return cacheEndTime(e, now, 0)
+}
+
+func cacheEndTime(e *pb.IprsEntry, now time.Time, depth int) (time.Time, bool) {
 	// If it's an EOL record, it's just the EOL
 	if e.GetValidityType() == pb.IprsEntry_EOL {
 		eol, err := rec.EolParseValidity(e)
@@ -185,5 +202,54 @@ func getCacheEndTime(e *pb.IprsEntry) (time.Time, bool) {
 		}
 		return *r[1], true
 	}
+	// If it's a Composite record, it's the earliest time at which one
+	// of the clauses' currently active windows ends
+	if e.GetValidityType() == pb.IprsEntry_Composite {
+		if depth >= rec.MaxCompositeDepth {
+			return now, true
+		}
+		clauses, err := rec.CompositeClauseEntries(e)
+		if err != nil {
+			return time.Time{}, false
+		}
+		var end time.Time
+		found := false
+		for _, ce := range clauses {
+			ceEnd, ok := cacheEndTime(ce, now, depth+1)
+			if ok && (!found || ceEnd.Before(end)) {
+				end = ceEnd
+				found = true
+			}
+		}
+		return end, found
+	}
+	// If it's a MultiRange record, it's the end of the currently active
+	// time range (if no time range is active, the record shouldn't be
+	// cached at all)
+	if e.GetValidityType() == pb.IprsEntry_MultiRange {
+		r, err := rec.MultiRangeActiveRange(e, now)
+		if err != nil {
+			return time.Time{}, false
+		}
+		if r == nil {
+			return now, true
+		}
+		if r[1] == nil {
+			return time.Time{}, false
+		}
+		return *r[1], true
+	}
+	// If it's a Recurring record, it's the end of the current window
+	// (if we're not in a window, the record shouldn't be cached at all)
+	if e.GetValidityType() == pb.IprsEntry_Recurring {
+		end, ok, err := rec.RecurringWindowEnd(e, now)
+		if err != nil {
+			return time.Time{}, false
+		}
+		if !ok {
+			return now, true
+		}
+		return end, true
+	}
 	return time.Time{}, false
 }
@@ -6,6 +6,7 @@ import (
 	"testing"
 	"time"
 
+	clock "github.com/dirkmc/go-iprs/clock"
 	rsp "github.com/dirkmc/go-iprs/path"
 	rec "github.com/dirkmc/go-iprs/record"
 	u "github.com/ipfs/go-ipfs-util"
@@ -32,7 +33,7 @@ func getEolRecord(t *testing.T, ts time.Time, r ValueStore) (rsp.IprsPath, *rec.
 	if err != nil {
 		t.Fatal(err)
 	}
-	factory := rec.NewRecordFactory(r)
+	factory := rec.NewRecordFactory(r, nil)
 	return iprsKey, factory.NewEolKeyRecord(p, pk, ts)
 }
 
@@ -41,12 +42,12 @@ func TestCacheSizeZero(t *testing.T) {
 	dstore := dssync.MutexWrap(ds.NewMapDatastore())
 	id := testutil.RandIdentityOrFatal(t)
 	r := NewMockValueStore(context.Background(), id, dstore)
-	vstore := NewCachedValueStore(r, 0, nil)
+	vstore := NewCachedValueStore(r, 0, nil, nil)
 	ts := time.Now().Add(time.Hour)
 	iprsKey, eolRecord := getEolRecord(t, ts, r)
 
 	// Put the entry
-	e, err := eolRecord.Entry(1)
+	e, err := eolRecord.Entry(iprsKey, 1)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -78,12 +79,12 @@ func TestCacheSizeTen(t *testing.T) {
 	dstore := dssync.MutexWrap(ds.NewMapDatastore())
 	id := testutil.RandIdentityOrFatal(t)
 	r := NewMockValueStore(context.Background(), id, dstore)
-	vstore := NewCachedValueStore(r, 10, nil)
+	vstore := NewCachedValueStore(r, 10, nil, nil)
 	ts := time.Now().Add(time.Hour)
 	iprsKey, eolRecord := getEolRecord(t, ts, r)
 
 	// Put the entry
-	e, err := eolRecord.Entry(1)
+	e, err := eolRecord.Entry(iprsKey, 1)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -134,12 +135,13 @@ func TestCacheEolExpired(t *testing.T) {
 	dstore := dssync.MutexWrap(ds.NewMapDatastore())
 	id := testutil.RandIdentityOrFatal(t)
 	r := NewMockValueStore(context.Background(), id, dstore)
-	vstore := NewCachedValueStore(r, 10, nil)
-	ts := time.Now().Add(time.Millisecond * 100)
+	clk := clock.NewMockClock(time.Now())
+	vstore := NewCachedValueStore(r, 10, nil, clk)
+	ts := clk.Now().Add(time.Millisecond * 100)
 	iprsKey, eolRecord := getEolRecord(t, ts, r)
 
 	// Put the entry
-	e, err := eolRecord.Entry(1)
+	e, err := eolRecord.Entry(iprsKey, 1)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -165,8 +167,8 @@ func TestCacheEolExpired(t *testing.T) {
 		t.Fatal("Got back incorrect value")
 	}
 
-	// Sleep beyond the entry's EOL
-	time.Sleep(time.Millisecond * 101)
+	// Move the clock beyond the entry's EOL
+	clk.Add(time.Millisecond * 101)
 
 	// Remove entry from routing
 	err = r.DeleteValue(iprsKey.String())
@@ -186,8 +188,9 @@ func TestCacheTimeRangeExpired(t *testing.T) {
 	dstore := dssync.MutexWrap(ds.NewMapDatastore())
 	id := testutil.RandIdentityOrFatal(t)
 	r := NewMockValueStore(context.Background(), id, dstore)
-	factory := rec.NewRecordFactory(r)
-	vstore := NewCachedValueStore(r, 10, nil)
+	factory := rec.NewRecordFactory(r, nil)
+	clk := clock.NewMockClock(time.Now())
+	vstore := NewCachedValueStore(r, 10, nil, clk)
 
 	pk, pubk, err := testutil.RandTestKeyPair(512)
 	if err != nil {
@@ -195,7 +198,7 @@ func TestCacheTimeRangeExpired(t *testing.T) {
 	}
 
 	p := path.FromString("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
-	ts := time.Now()
+	ts := clk.Now()
 	pubkBytes, err := pubk.Bytes()
 	if err != nil {
 		t.Fatal(err)
@@ -211,7 +214,7 @@ func TestCacheTimeRangeExpired(t *testing.T) {
 	}
 
 	// Put the entry
-	e, err := rangeRecord.Entry(1)
+	e, err := rangeRecord.Entry(iprsKey, 1)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -237,8 +240,8 @@ func TestCacheTimeRangeExpired(t *testing.T) {
 		t.Fatal("Got back incorrect value")
 	}
 
-	// Sleep beyond the entry's EOL
-	time.Sleep(time.Millisecond * 101)
+	// Move the clock beyond the entry's end time
+	clk.Add(time.Millisecond * 101)
 
 	// Remove entry from routing
 	err = r.DeleteValue(iprsKey.String())
@@ -252,3 +255,207 @@ func TestCacheTimeRangeExpired(t *testing.T) {
 		t.Fatal("Expected key not found error")
 	}
 }
+
+func TestCacheRecurringEndTime(t *testing.T) {
+	pk, pubk, err := testutil.RandTestKeyPair(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubkBytes, err := pubk.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	iprsKey, err := rsp.FromString("/iprs/" + u.Hash(pubkBytes).B58String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	factory := rec.NewRecordFactory(nil, nil)
+	days := []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+	w, err := rec.NewRecurringWindow(days, "09:00", "17:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vl, err := rec.NewRecurringRecordValidity(nil, nil, time.UTC, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := path.FromString("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+	e, err := factory.NewRecord(vl, factory.NewKeyRecordSigner(pk), p).Entry(iprsKey, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Within the window the entry should be cached until the window ends
+	// (Monday 2018-01-01 at 10:00 UTC)
+	now := time.Date(2018, 1, 1, 10, 0, 0, 0, time.UTC)
+	end, ok := getCacheEndTime(e, now)
+	if !ok || !end.Equal(time.Date(2018, 1, 1, 17, 0, 0, 0, time.UTC)) {
+		t.Fatal("Expected cache end time to be end of window")
+	}
+
+	// Outside the window the entry should not be cached
+	now = time.Date(2018, 1, 1, 18, 0, 0, 0, time.UTC)
+	end, ok = getCacheEndTime(e, now)
+	if !ok || end.After(now) {
+		t.Fatal("Expected entry not to be cached outside window")
+	}
+}
+
+func TestCacheMultiRangeEndTime(t *testing.T) {
+	pk, pubk, err := testutil.RandTestKeyPair(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubkBytes, err := pubk.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	iprsKey, err := rsp.FromString("/iprs/" + u.Hash(pubkBytes).B58String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	factory := rec.NewRecordFactory(nil, nil)
+	p := path.FromString("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+	ts := time.Unix(1000000, 0)
+	InOneHour := ts.Add(time.Hour)
+	InTwoHours := ts.Add(time.Hour * 2)
+	InThreeHours := ts.Add(time.Hour * 3)
+	r, err := factory.NewMultiRangeKeyRecord(p, pk, [2]*time.Time{&ts, &InOneHour}, [2]*time.Time{&InTwoHours, &InThreeHours})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := r.Entry(iprsKey, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Within a range the entry should be cached until the range ends
+	end, ok := getCacheEndTime(e, ts.Add(time.Minute))
+	if !ok || !end.Equal(InOneHour) {
+		t.Fatal("Expected cache end time to be end of first range")
+	}
+	end, ok = getCacheEndTime(e, InTwoHours.Add(time.Minute))
+	if !ok || !end.Equal(InThreeHours) {
+		t.Fatal("Expected cache end time to be end of second range")
+	}
+
+	// Between ranges the entry should not be cached
+	now := InOneHour.Add(time.Minute)
+	end, ok = getCacheEndTime(e, now)
+	if !ok || end.After(now) {
+		t.Fatal("Expected entry not to be cached between ranges")
+	}
+}
+
+func TestCacheCompositeEndTime(t *testing.T) {
+	pk, pubk, err := testutil.RandTestKeyPair(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubkBytes, err := pubk.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	iprsKey, err := rsp.FromString("/iprs/" + u.Hash(pubkBytes).B58String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Valid during office hours until the end of the month
+	factory := rec.NewRecordFactory(nil, nil)
+	days := []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+	w, err := rec.NewRecurringWindow(days, "09:00", "17:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recurring, err := rec.NewRecurringRecordValidity(nil, nil, time.UTC, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eol := time.Date(2018, 1, 31, 12, 0, 0, 0, time.UTC)
+	vl, err := rec.NewAndRecordValidity(recurring, rec.NewEolRecordValidity(eol))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := path.FromString("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+	e, err := factory.NewRecord(vl, factory.NewKeyRecordSigner(pk), p).Entry(iprsKey, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Within the window the entry should be cached until the window ends
+	// (Monday 2018-01-01 at 10:00 UTC), not until the EOL
+	now := time.Date(2018, 1, 1, 10, 0, 0, 0, time.UTC)
+	end, ok := getCacheEndTime(e, now)
+	if !ok || !end.Equal(time.Date(2018, 1, 1, 17, 0, 0, 0, time.UTC)) {
+		t.Fatal("Expected cache end time to be end of window")
+	}
+
+	// If the EOL is before the end of the window, it should be cached
+	// until the EOL
+	now = time.Date(2018, 1, 31, 10, 0, 0, 0, time.UTC)
+	end, ok = getCacheEndTime(e, now)
+	if !ok || !end.Equal(eol) {
+		t.Fatal("Expected cache end time to be EOL")
+	}
+
+	// Outside the window the entry should not be cached
+	now = time.Date(2018, 1, 1, 18, 0, 0, 0, time.UTC)
+	end, ok = getCacheEndTime(e, now)
+	if !ok || end.After(now) {
+		t.Fatal("Expected entry not to be cached outside window")
+	}
+}
+
+func TestCacheRecordTtl(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	id := testutil.RandIdentityOrFatal(t)
+	r := NewMockValueStore(context.Background(), id, dstore)
+	clk := clock.NewMockClock(time.Now())
+	vstore := NewCachedValueStore(r, 10, nil, clk)
+	iprsKey, eolRecord := getEolRecord(t, clk.Now().Add(time.Hour), r)
+
+	// The record's TTL is shorter than the store-wide TTL
+	eolRecord.SetTtl(time.Second * 10)
+	e, err := eolRecord.Entry(iprsKey, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vstore.EntryTtl(e) != time.Second*10 {
+		t.Fatal("Expected entry TTL to be the record's TTL")
+	}
+	err = vstore.PutEntry(ctx, iprsKey, e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Remove entry from routing. Should still be in cache.
+	err = r.DeleteValue(iprsKey.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = vstore.GetEntry(ctx, iprsKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Move the clock beyond the record's TTL. Should have expired
+	clk.Add(time.Second * 11)
+	_, err = vstore.GetEntry(ctx, iprsKey)
+	if err == nil {
+		t.Fatal("Expected key not found error")
+	}
+
+	// The TTL is limited to the time until the record expires
+	eolRecord.SetTtl(time.Hour * 2)
+	e, err = eolRecord.Entry(iprsKey, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vstore.EntryTtl(e) > time.Hour {
+		t.Fatal("Expected entry TTL to be limited by the record's EOL")
+	}
+}
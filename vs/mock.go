@@ -41,6 +41,12 @@ func NewMockValueStore(ctx context.Context, id testutil.Identity, dstore ds.Data
 	vs.Validator[c.CertType] = c.CertificateValidator
 	vs.Selector[c.CertType] = c.CertificateSelector
 
+	vs.Validator[c.CRLType] = c.RevocationListValidator
+	vs.Selector[c.CRLType] = c.RevocationListSelector
+
+	vs.Validator[c.AllowListType] = c.AllowListValidator
+	vs.Selector[c.AllowListType] = c.AllowListSelector
+
 	vs.Validator["iprs"] = v.RecordChecker.ValidChecker
 	vs.Selector["iprs"] = v.RecordChecker.Selector
 